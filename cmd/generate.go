@@ -333,6 +333,37 @@ func main() {
 		},
 	}
 	hexyaCmd.AddCommand(updateDBCmd)
+	cmd.SetUpdateDBFlags(updateDBCmd)
+
+	var migrationsCmd = &cobra.Command{
+		Use:   "migrations",
+		Short: "Show the status of data migrations",
+		Long: "Show the data migrations of all modules and whether they have been applied to the database.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ShowMigrations()
+		},
+	}
+	hexyaCmd.AddCommand(migrationsCmd)
+
+	var tenantCmd = &cobra.Command{
+		Use:   "tenant",
+		Short: "Manage tenant databases",
+		Long: "Create, list, duplicate and drop the tenant databases of a multi-tenant server.",
+	}
+	hexyaCmd.AddCommand(tenantCmd)
+	cmd.SetTenantCommands(tenantCmd)
+
+	var rulesCmd = &cobra.Command{
+		Use:   "rules MODEL [ID...]",
+		Short: "Explain the record rules of a model",
+		Long: "Show the record rules that apply to a user for a permission on MODEL and whether they allow the given records.",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ExplainRecordRules(args)
+		},
+	}
+	hexyaCmd.AddCommand(rulesCmd)
+	cmd.SetRulesFlags(rulesCmd)
 
 	cobra.OnInitialize(cmd.InitConfig)
 
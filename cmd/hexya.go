@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/gleke/hexya/src/tools/logging"
 	"github.com/spf13/cobra"
@@ -77,6 +78,22 @@ func SetHexyaFlags(c *cobra.Command) {
 	viper.BindPFlag("DB.SSLKey", c.PersistentFlags().Lookup("db-ssl-key"))
 	c.PersistentFlags().String("db-ssl-ca", "", "Path to certificate authority certificate(s) file")
 	viper.BindPFlag("DB.SSLCA", c.PersistentFlags().Lookup("db-ssl-ca"))
+	c.PersistentFlags().StringSlice("db-replicas", []string{}, "Comma separated list of read replicas of the database given as host or host:port. Other connection parameters are those of the primary database")
+	viper.BindPFlag("DB.Replicas", c.PersistentFlags().Lookup("db-replicas"))
+	c.PersistentFlags().Duration("db-max-replica-lag", 10*time.Second, "Maximum replication lag of a read replica for it to be used")
+	viper.BindPFlag("DB.MaxReplicaLag", c.PersistentFlags().Lookup("db-max-replica-lag"))
+	c.PersistentFlags().Int("db-max-open-conns", 0, "Maximum number of open connections to the database. 0 means unlimited")
+	viper.BindPFlag("DB.MaxOpenConns", c.PersistentFlags().Lookup("db-max-open-conns"))
+	c.PersistentFlags().Int("db-max-idle-conns", 2, "Maximum number of idle connections to the database. A negative value means no idle connections are kept")
+	viper.BindPFlag("DB.MaxIdleConns", c.PersistentFlags().Lookup("db-max-idle-conns"))
+	c.PersistentFlags().Duration("db-conn-max-lifetime", 0, "Maximum time a database connection may be reused. 0 means forever")
+	viper.BindPFlag("DB.ConnMaxLifetime", c.PersistentFlags().Lookup("db-conn-max-lifetime"))
+	c.PersistentFlags().Duration("db-statement-timeout", 0, "Default maximum duration of a database query. 0 means the database server setting")
+	viper.BindPFlag("DB.StatementTimeout", c.PersistentFlags().Lookup("db-statement-timeout"))
+	c.PersistentFlags().Duration("db-lock-timeout", 0, "Maximum duration of a wait for a database lock. 0 means the database server setting")
+	viper.BindPFlag("DB.LockTimeout", c.PersistentFlags().Lookup("db-lock-timeout"))
+	c.PersistentFlags().StringSlice("db-encryption-keys", []string{}, "Comma separated list of secrets used to encrypt fields values. The first one is used for encryption, others are kept for decrypting values after a key rotation")
+	viper.BindPFlag("DB.EncryptionKeys", c.PersistentFlags().Lookup("db-encryption-keys"))
 }
 
 // InitConfig initializes Hexya configuration system (viper).
@@ -0,0 +1,51 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gleke/hexya/src/models"
+	"github.com/gleke/hexya/src/server"
+	"github.com/spf13/cobra"
+)
+
+var migrationsCmd = &cobra.Command{
+	Use:   "migrations [projectDir]",
+	Short: "Show the status of data migrations",
+	Long:  `Show the data migrations of all modules and whether they have been applied to the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		runProject(projectDir, "migrations", args)
+	},
+}
+
+// ShowMigrations prints the status of the data migrations of all modules.
+// It is meant to be called from a project start file which imports all
+// the project's module.
+func ShowMigrations() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSTAGE\tVERSION\tSTATUS\tDESCRIPTION")
+	for _, status := range server.MigrationsStatus() {
+		state := "pending"
+		if status.Applied {
+			state = fmt.Sprintf("applied %s", status.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Module, status.Stage, status.Version, state, status.Description)
+	}
+	w.Flush()
+}
+
+func init() {
+	HexyaCmd.AddCommand(migrationsCmd)
+}
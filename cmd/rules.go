@@ -0,0 +1,118 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gleke/hexya/src/models"
+	"github.com/gleke/hexya/src/models/security"
+	"github.com/gleke/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules MODEL [ID...]",
+	Short: "Explain the record rules of a model",
+	Long: `Show the record rules that apply to a user for a permission on MODEL and the condition they add to the user's queries.
+If IDs are given, also show for each record whether the user has the permission and which rules allow or deny it.
+This command must be run from the project directory.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		args = append(args,
+			fmt.Sprintf("--user=%d", viper.GetInt64("Rules.User")),
+			fmt.Sprintf("--perm=%s", viper.GetString("Rules.Perm")))
+		runProject(".", "rules", args)
+	},
+}
+
+// ExplainRecordRules prints the record rules that apply to the user given
+// by the user flag for the permission given by the perm flag on the model
+// args[0], and the verdict of the rules on the records whose ids are the
+// other args.
+//
+// It is meant to be called from a project start file which imports all
+// the project's module.
+func ExplainRecordRules(args []string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	model := models.Registry.MustGet(args[0])
+	perm, ok := security.ParsePermission(viper.GetString("Rules.Perm"))
+	if !ok {
+		fmt.Printf("Unknown permission %s\n", viper.GetString("Rules.Perm"))
+		os.Exit(1)
+	}
+	ids := make([]int64, len(args)-1)
+	for i, arg := range args[1:] {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid record ID %s\n", arg)
+			os.Exit(1)
+		}
+		ids[i] = id
+	}
+	var explanation models.RecordRulesExplanation
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		explanation = model.ExplainRecordRules(env, viper.GetInt64("Rules.User"), perm, ids...)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printRecordRulesExplanation(explanation)
+}
+
+// printRecordRulesExplanation prints the given explanation to the standard output
+func printRecordRulesExplanation(explanation models.RecordRulesExplanation) {
+	fmt.Printf("Model: %s\nUser: %d\nPermission: %s\n\n", explanation.Model, explanation.UserID, explanation.Permission)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tTYPE\tGROUP\tPERMISSIONS")
+	for _, rule := range explanation.GlobalRules {
+		fmt.Fprintf(w, "%s\tglobal\t\t%s\n", rule.Name, rule.Perms)
+	}
+	for _, rule := range explanation.GroupRules {
+		fmt.Fprintf(w, "%s\tgroup\t%s\t%s\n", rule.Name, rule.Group.ID(), rule.Perms)
+	}
+	w.Flush()
+	if explanation.Condition.IsEmpty() {
+		fmt.Println("\nNo record rule applies: all records are allowed")
+	} else {
+		fmt.Printf("\nCondition:\n%s", explanation.Condition)
+	}
+	if len(explanation.Records) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tALLOWED\tALLOWING RULES\tDENYING RULES")
+	for _, verdict := range explanation.Records {
+		allowed := fmt.Sprintf("%t", verdict.Allowed)
+		if !verdict.Exists {
+			allowed = "not found"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", verdict.ID, allowed,
+			strings.Join(verdict.AllowingRules, ","), strings.Join(verdict.DenyingRules, ","))
+	}
+	w.Flush()
+}
+
+// SetRulesFlags adds the rules flags to the given command.
+func SetRulesFlags(c *cobra.Command) {
+	c.PersistentFlags().Int64("user", security.SuperUserID, "ID of the user whose rules are explained")
+	viper.BindPFlag("Rules.User", c.PersistentFlags().Lookup("user"))
+	c.PersistentFlags().String("perm", "read", "Comma separated list of permissions among read, write and unlink")
+	viper.BindPFlag("Rules.Perm", c.PersistentFlags().Lookup("perm"))
+}
+
+func init() {
+	SetRulesFlags(rulesCmd)
+	HexyaCmd.AddCommand(rulesCmd)
+}
@@ -19,6 +19,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
@@ -81,6 +83,12 @@ func StartServer() {
 	connectToDB()
 	i18n.BootStrap()
 	models.BootStrap()
+	if viper.GetBool("Server.MultiTenant") {
+		server.EnableMultiTenant()
+	}
+	if viper.GetBool("Server.Profiling") {
+		server.EnableProfiling(viper.GetDuration("Server.SlowCallThreshold"))
+	}
 	models.RunWorkerLoop()
 	server.LoadTranslations(resourceDir, i18n.Langs)
 	server.LoadInternalResources(resourceDir)
@@ -124,17 +132,39 @@ func setupDebug() {
 // connectToDB creates the connection to the database
 func connectToDB() {
 	models.DBConnect(models.ConnectionParams{
-		Driver:   viper.GetString("DB.Driver"),
-		Host:     viper.GetString("DB.Host"),
-		Port:     viper.GetString("DB.Port"),
-		User:     viper.GetString("DB.User"),
-		Password: viper.GetString("DB.Password"),
-		DBName:   viper.GetString("DB.Name"),
-		SSLMode:  viper.GetString("DB.SSLMode"),
-		SSLCert:  viper.GetString("DB.SSLCert"),
-		SSLKey:   viper.GetString("DB.SSLKey"),
-		SSLCA:    viper.GetString("DB.SSLCA"),
+		Driver:           viper.GetString("DB.Driver"),
+		Host:             viper.GetString("DB.Host"),
+		Port:             viper.GetString("DB.Port"),
+		User:             viper.GetString("DB.User"),
+		Password:         viper.GetString("DB.Password"),
+		DBName:           viper.GetString("DB.Name"),
+		SSLMode:          viper.GetString("DB.SSLMode"),
+		SSLCert:          viper.GetString("DB.SSLCert"),
+		SSLKey:           viper.GetString("DB.SSLKey"),
+		SSLCA:            viper.GetString("DB.SSLCA"),
+		Replicas:         replicasConnectionParams(viper.GetStringSlice("DB.Replicas")),
+		MaxReplicaLag:    viper.GetDuration("DB.MaxReplicaLag"),
+		MaxOpenConns:     viper.GetInt("DB.MaxOpenConns"),
+		MaxIdleConns:     viper.GetInt("DB.MaxIdleConns"),
+		ConnMaxLifetime:  viper.GetDuration("DB.ConnMaxLifetime"),
+		StatementTimeout: viper.GetDuration("DB.StatementTimeout"),
+		LockTimeout:      viper.GetDuration("DB.LockTimeout"),
 	})
+	models.SetEncryptionKeys(viper.GetStringSlice("DB.EncryptionKeys")...)
+}
+
+// replicasConnectionParams returns the connection params of the given
+// replicas, given as host or host:port.
+func replicasConnectionParams(replicas []string) []models.ConnectionParams {
+	res := make([]models.ConnectionParams, len(replicas))
+	for i, replica := range replicas {
+		res[i].Host = replica
+		if idx := strings.LastIndex(replica, ":"); idx >= 0 {
+			res[i].Host = replica[:idx]
+			res[i].Port = replica[idx+1:]
+		}
+	}
+	return res
 }
 
 // SetServerFlags adds the server flags to the given command.
@@ -151,6 +181,12 @@ func SetServerFlags(c *cobra.Command) {
 	viper.BindPFlag("Server.Certificate", c.PersistentFlags().Lookup("certificate"))
 	c.PersistentFlags().StringP("private-key", "K", "", "Private key file for HTTPS.")
 	viper.BindPFlag("Server.PrivateKey", c.PersistentFlags().Lookup("private-key"))
+	c.PersistentFlags().Bool("multi-tenant", false, "Serve the databases of all tenants, selecting the database of each request by its host name, header or session")
+	viper.BindPFlag("Server.MultiTenant", c.PersistentFlags().Lookup("multi-tenant"))
+	c.PersistentFlags().Bool("profiling", false, "Collect call counts, durations and query counts of model methods. Statistics are served at /debug/methods in debug mode")
+	viper.BindPFlag("Server.Profiling", c.PersistentFlags().Lookup("profiling"))
+	c.PersistentFlags().Duration("slow-call-threshold", time.Second, "When profiling, log method calls and requests lasting longer than this duration. 0 disables logging")
+	viper.BindPFlag("Server.SlowCallThreshold", c.PersistentFlags().Lookup("slow-call-threshold"))
 }
 
 func runCommand(c string, args ...string) error {
@@ -0,0 +1,134 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gleke/hexya/src/models"
+	"github.com/gleke/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Manage tenant databases",
+	Long: `Create, list, duplicate and drop the tenant databases of a multi-tenant server.
+Tenant commands must be run from the project directory.`,
+}
+
+// SetTenantCommands adds the tenant subcommands to the given command.
+// It is meant to be called from a project start file which imports all
+// the project's module.
+func SetTenantCommands(c *cobra.Command) {
+	addTenantCommands(c, func(action string, args []string) {
+		setupLogger()
+		server.PreInit()
+		connectToDB()
+		models.BootStrap()
+		switch action {
+		case "create":
+			CreateTenant(args[0])
+		case "list":
+			ListTenants()
+		case "duplicate":
+			DuplicateTenant(args[0], args[1])
+		case "drop":
+			DropTenant(args[0])
+		}
+	})
+}
+
+// addTenantCommands adds the tenant subcommands to the given command.
+// Each subcommand calls run with its name and arguments.
+func addTenantCommands(c *cobra.Command, run func(action string, args []string)) {
+	c.PersistentFlags().String("host", "", "Host name of the requests served by the tenant")
+	viper.BindPFlag("Tenant.Host", c.PersistentFlags().Lookup("host"))
+	c.AddCommand(&cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a tenant",
+		Long:  `Create the database of a new tenant and synchronize its schema with the models.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			run("create", args)
+		},
+	}, &cobra.Command{
+		Use:   "list",
+		Short: "List tenants",
+		Long:  `List the tenants registered in the default database.`,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			run("list", args)
+		},
+	}, &cobra.Command{
+		Use:   "duplicate SOURCE NAME",
+		Short: "Duplicate a tenant",
+		Long:  `Create a new tenant whose database is a copy of the database of the SOURCE tenant.`,
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			run("duplicate", args)
+		},
+	}, &cobra.Command{
+		Use:   "drop NAME",
+		Short: "Drop a tenant",
+		Long:  `Drop the database of a tenant and unregister it.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			run("drop", args)
+		},
+	})
+}
+
+// CreateTenant creates the database of the tenant with the given name and
+// updates it like the updatedb command. The default database must be connected.
+func CreateTenant(name string) {
+	models.CreateTenant(name, viper.GetString("Tenant.Host"))
+	connectToTenantDB(name)
+	updateDatabase()
+	fmt.Printf("Tenant %s created\n", name)
+}
+
+// connectToTenantDB closes the connection to the current database and
+// connects to the database of the given tenant. Tenant databases have
+// no read replica.
+func connectToTenantDB(name string) {
+	models.DBClose()
+	viper.Set("DB.Name", name)
+	viper.Set("DB.Replicas", []string{})
+	connectToDB()
+}
+
+// ListTenants prints the tenants registered in the default database.
+func ListTenants() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHOST\tCREATED")
+	for _, tenant := range models.Tenants() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", tenant.Name, tenant.Host, tenant.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// DuplicateTenant creates the tenant name as a copy of the source tenant.
+func DuplicateTenant(source, name string) {
+	models.DuplicateTenant(source, name, viper.GetString("Tenant.Host"))
+	fmt.Printf("Tenant %s duplicated to %s\n", source, name)
+}
+
+// DropTenant drops the database of the given tenant.
+func DropTenant(name string) {
+	models.DropTenant(name)
+	fmt.Printf("Tenant %s dropped\n", name)
+}
+
+func init() {
+	addTenantCommands(tenantCmd, func(action string, args []string) {
+		args = append([]string{action}, args...)
+		args = append(args, fmt.Sprintf("--host=%s", viper.GetString("Tenant.Host")))
+		runProject(".", "tenant", args)
+	})
+	HexyaCmd.AddCommand(tenantCmd)
+}
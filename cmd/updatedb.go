@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/gleke/hexya/src/models"
@@ -15,12 +16,19 @@ import (
 var updateDBCmd = &cobra.Command{
 	Use:   "updatedb",
 	Short: "Update the database schema",
-	Long:  `Synchronize the database schema with the models definitions.`,
+	Long: `Synchronize the database schema with the models definitions.
+With --all-tenants, the databases of all the tenants of a multi-tenant server are also updated.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectDir := "."
 		if len(args) > 0 {
 			projectDir = args[0]
 		}
+		args = append(args,
+			fmt.Sprintf("--dry-run=%t", viper.GetBool("UpdateDB.DryRun")),
+			fmt.Sprintf("--safe-mode=%t", viper.GetBool("UpdateDB.SafeMode")),
+			fmt.Sprintf("--allow-destructive-changes=%t", viper.GetBool("UpdateDB.AllowDestructiveChanges")),
+			fmt.Sprintf("--prune=%t", viper.GetBool("UpdateDB.Prune")),
+			fmt.Sprintf("--all-tenants=%t", viper.GetBool("UpdateDB.AllTenants")))
 		runProject(projectDir, "updatedb", args)
 	},
 }
@@ -33,7 +41,45 @@ func UpdateDB() {
 	server.PreInit()
 	connectToDB()
 	models.BootStrap()
+	updateDatabase()
+	if viper.GetBool("UpdateDB.AllTenants") {
+		updateTenants()
+	}
+}
+
+// updateTenants updates the databases of all the tenants registered in
+// the connected default database like updateDatabase.
+func updateTenants() {
+	for _, tenant := range models.Tenants() {
+		log.Info("Updating tenant database", "tenant", tenant.Name)
+		if viper.GetBool("UpdateDB.DryRun") {
+			fmt.Printf("\nTenant %s:\n", tenant.Name)
+		}
+		connectToTenantDB(tenant.Name)
+		updateDatabase()
+	}
+}
+
+// updateDatabase synchronizes the schema of the connected database with
+// the models, runs the migrations and loads the data records.
+func updateDatabase() {
+	models.SetSyncOptions(models.SyncOptions{
+		SafeMode:                    viper.GetBool("UpdateDB.SafeMode"),
+		AllowDestructiveTypeChanges: viper.GetBool("UpdateDB.AllowDestructiveChanges"),
+		Prune:                       viper.GetBool("UpdateDB.Prune"),
+	})
+	if viper.GetBool("UpdateDB.DryRun") {
+		plan := models.PlanDatabaseSync()
+		if plan.IsEmpty() {
+			fmt.Println("Database schema is up to date")
+			return
+		}
+		fmt.Printf("Schema changes:\n%s\n\nSQL:\n%s", plan, plan.SQL())
+		return
+	}
+	server.RunMigrations(server.PreSyncMigration)
 	models.SyncDatabase()
+	server.RunMigrations(server.PostSyncMigration)
 	resourceDir, err := filepath.Abs(viper.GetString("ResourceDir"))
 	if err != nil {
 		log.Panic("Unable to find Resource directory", "error", err)
@@ -48,6 +94,21 @@ func UpdateDB() {
 	log.Info("Database updated successfully")
 }
 
+// SetUpdateDBFlags adds the updatedb flags to the given command.
+func SetUpdateDBFlags(c *cobra.Command) {
+	c.PersistentFlags().Bool("dry-run", false, "Print the schema changes and the SQL queries that would be run without modifying the database")
+	viper.BindPFlag("UpdateDB.DryRun", c.PersistentFlags().Lookup("dry-run"))
+	c.PersistentFlags().Bool("safe-mode", true, "Keep and report unused tables, columns, views and sequences instead of dropping them and refuse column type changes that may lose data")
+	viper.BindPFlag("UpdateDB.SafeMode", c.PersistentFlags().Lookup("safe-mode"))
+	c.PersistentFlags().Bool("allow-destructive-changes", false, "Allow column type changes that may lose data in safe mode")
+	viper.BindPFlag("UpdateDB.AllowDestructiveChanges", c.PersistentFlags().Lookup("allow-destructive-changes"))
+	c.PersistentFlags().Bool("prune", false, "Drop the tables, columns, views and sequences that are not used by the models")
+	viper.BindPFlag("UpdateDB.Prune", c.PersistentFlags().Lookup("prune"))
+	c.PersistentFlags().Bool("all-tenants", false, "Also update the databases of all the tenants registered in the database")
+	viper.BindPFlag("UpdateDB.AllTenants", c.PersistentFlags().Lookup("all-tenants"))
+}
+
 func init() {
 	HexyaCmd.AddCommand(updateDBCmd)
+	SetUpdateDBFlags(updateDBCmd)
 }
@@ -165,6 +165,24 @@ func (l *Locale) FormatDateTime(datetime dates.DateTime) string {
 	return fmt.Sprintf("%s %s", datetime.Format(l.DateFormatGo), datetime.Format(l.TimeFormatGo))
 }
 
+// FormatTimeOfDay returns the given time of the day formatted
+// according to this Locale
+func (l *Locale) FormatTimeOfDay(t dates.Time) string {
+	return t.Format(l.TimeFormatGo)
+}
+
+// FormatDuration returns the given duration formatted as hours and minutes
+// (e.g. "26:30" or "-01:15"). Seconds are rounded to the nearest minute.
+func (l *Locale) FormatDuration(d dates.Duration) string {
+	minutes := d.Round(time.Minute) / time.Minute
+	sign := ""
+	if minutes < 0 {
+		sign = "-"
+		minutes = -minutes
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, minutes/60, minutes%60)
+}
+
 // groupDigits splits groups[0] at its last N digits and returns a new slice with, in order:
 // - the remainder of the split
 // - the n grouped digits
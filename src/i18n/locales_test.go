@@ -72,6 +72,16 @@ func TestLocale(t *testing.T) {
 			So(fr.FormatTime(dateTime), ShouldEqual, "15:02:00")
 			So(en.FormatTime(dateTime), ShouldEqual, "03:02:00 PM")
 		})
+		Convey("FormatTimeOfDay, FormatDuration", func() {
+			en := GetLocale("en")
+			fr := GetLocale("fr")
+			en.TimeFormatGo = "03:04:05 PM"
+			So(fr.FormatTimeOfDay(dates.ParseTime("15:02:00")), ShouldEqual, "15:02:00")
+			So(en.FormatTimeOfDay(dates.ParseTime("15:02:00")), ShouldEqual, "03:02:00 PM")
+			So(fr.FormatDuration(dates.ParseDuration("1 day 02:30:00")), ShouldEqual, "26:30")
+			So(fr.FormatDuration(dates.ParseDuration("-01:15:00")), ShouldEqual, "-01:15")
+			So(fr.FormatDuration(dates.ParseDuration("00:00:40")), ShouldEqual, "00:01")
+		})
 		Convey("FormatMonetary", func() {
 			fr := GetLocale("fr")
 			ja := GetLocale("ja")
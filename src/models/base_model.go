@@ -113,6 +113,7 @@ func commonMixinRead(rc *RecordCollection, fields FieldNames) []RecordData {
 	var res []RecordData
 	// Check if we have id in fields, and add it otherwise
 	fields = addIDIfNotPresent(fields)
+	fields = rc.filterReadableFields(fields)
 	// Do the actual reading
 	for _, rec := range rc.Records() {
 		fData := NewModelData(rc.model)
@@ -237,10 +238,24 @@ func commonMixinSearchByName(rc *RecordCollection, name string, op operator.Oper
 func commonMixinFieldsGet(rc *RecordCollection, args FieldsGetArgs) map[string]*FieldInfo {
 	// Get the field informations
 	res := rc.model.FieldsGet(args.Fields...)
+	// Hide the fields that the user cannot read
+	for fName, fInfo := range res {
+		fi := rc.model.fields.MustGet(fName)
+		if !fi.canRead(*rc.env) {
+			delete(res, fName)
+			continue
+		}
+		if !fi.canWrite(*rc.env) {
+			fInfo.ReadOnly = true
+		}
+	}
 
-	// Translate attributes when required
+	// Evaluate dynamic selections and translate attributes when required
 	lang := rc.Env().Context().GetString("lang")
 	for fName, fInfo := range res {
+		if fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(fName)); fi.selectionEnvFunc != nil {
+			fInfo.Selection = fi.currentSelection(rc.Env())
+		}
 		res[fName].Help = i18n.Registry.TranslateFieldHelp(lang, rc.model.name, fInfo.Name, fInfo.Help)
 		res[fName].String = i18n.Registry.TranslateFieldDescription(lang, rc.model.name, fInfo.Name, fInfo.String)
 		res[fName].Selection = i18n.Registry.TranslateFieldSelection(lang, rc.model.name, fInfo.Name, fInfo.Selection)
@@ -304,8 +319,12 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 	var warnings []string
 	filters := make(map[FieldName]Conditioner)
 
-	err := SimulateInNewEnvironment(rc.Env().Uid(), func(env Environment) {
-		values := params.Values.Underlying().FieldMap
+	err := SimulateInTenantEnvironmentContext(rc.env.Ctx(), rc.env.tenant, rc.Env().Uid(), func(env Environment) {
+		values := params.Values.Underlying().FieldMap.Copy()
+		// Fields that the user cannot write keep their current value
+		for _, f := range rc.nonWritableFields(values) {
+			delete(values, f)
+		}
 		data := NewModelDataFromRS(rc.WithEnv(env), values)
 		if rc.IsNotEmpty() {
 			data.Set(ID, rc.ids[0])
@@ -359,6 +378,9 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 						todo = append(todo, f)
 					}
 				}
+				for _, f := range rrs.nonWritableFields(vals.Underlying().FieldMap) {
+					vals.Underlying().Unset(rrs.model.FieldName(f))
+				}
 				rrs.WithContext("hexya_force_compute_write", true).Call("Write", vals)
 			}
 			// Warning
@@ -412,6 +434,7 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 // Search returns a new RecordSet filtering on the current one with the
 // additional given Condition.
 func commonMixinSearch(rc *RecordCollection, cond Conditioner) *RecordCollection {
+	rc.checkConditionReadAccess(cond.Underlying())
 	return rc.Search(cond.Underlying())
 }
 
@@ -448,6 +471,7 @@ func commonMixinSearchAll(rc *RecordCollection) *RecordCollection {
 
 // GroupBy returns a new RecordSet grouped with the given GROUP BY expressions.
 func commonMixinGroupBy(rc *RecordCollection, exprs ...FieldName) *RecordCollection {
+	rc.checkFieldsReadAccess("You are not allowed to group on this field", exprs...)
 	return rc.GroupBy(exprs...)
 }
 
@@ -466,6 +490,9 @@ func commonMixinOffset(rc *RecordCollection, offset int) *RecordCollection {
 //
 // rs.OrderBy("Company", "Name desc")
 func commonMixinOrderBy(rc *RecordCollection, exprs ...string) *RecordCollection {
+	for _, order := range rc.model.ordersFromStrings(exprs) {
+		rc.checkFieldsReadAccess("You are not allowed to order on this field", order.field)
+	}
 	return rc.OrderBy(exprs...)
 }
 
@@ -53,6 +53,7 @@ func BootStrap() {
 	createModelLinks()
 	inflateEmbeddings()
 	processUpdates()
+	inflateM2MOldNames()
 	updateFieldDefs()
 	updateRelatedPaths()
 	syncRelatedFieldInfo()
@@ -64,9 +65,14 @@ func BootStrap() {
 	checkFieldMethodsExist()
 	checkComputeMethodsSignature()
 	setupSecurity()
+	setupCompanyRules()
 	RegisterWorker(NewWorkerFunction(FreeTransientModels, freeTransientPeriod))
+	registerViewRefreshWorkers()
+	registerWebhooks()
+	listenSharedCacheInvalidations()
 
 	Registry.bootstrapped = true
+	ReloadGroups()
 }
 
 // BootStrapped returns true if the models have been bootstrapped
@@ -99,10 +105,60 @@ func processUpdates() {
 	}
 }
 
+// inflateM2MOldNames sets the old names of the link models and link fields
+// of many2many relations whose names derive from the name of a renamed model.
+func inflateM2MOldNames() {
+	for _, model := range Registry.registryByName {
+		if model.IsMixin() {
+			continue
+		}
+		for _, fi := range model.fields.registryByName {
+			if fi.fieldType != fieldtype.Many2Many {
+				continue
+			}
+			relModel := fi.relatedModel
+			if fi.m2mRelModel.name == M2MDefaultLinkModelName(model.name, relModel.name) {
+				for _, ourName := range append([]string{model.name}, model.oldNames...) {
+					for _, theirName := range append([]string{relModel.name}, relModel.oldNames...) {
+						fi.m2mRelModel.oldNames = appendOldName(fi.m2mRelModel.oldNames, fi.m2mRelModel.name, M2MDefaultLinkModelName(ourName, theirName))
+					}
+				}
+			}
+			for _, oldName := range model.oldNames {
+				if fi.m2mOurField.name == model.name {
+					fi.m2mOurField.oldNames = appendOldName(fi.m2mOurField.oldNames, fi.m2mOurField.name, oldName)
+				}
+			}
+			for _, oldName := range relModel.oldNames {
+				if fi.m2mTheirField.name == relModel.name {
+					fi.m2mTheirField.oldNames = appendOldName(fi.m2mTheirField.oldNames, fi.m2mTheirField.name, oldName)
+				}
+			}
+		}
+	}
+}
+
+// appendOldName appends oldName to oldNames if it is not already
+// in the list and if it is different from the current name.
+func appendOldName(oldNames []string, name, oldName string) []string {
+	if oldName == name {
+		return oldNames
+	}
+	for _, on := range oldNames {
+		if on == oldName {
+			return oldNames
+		}
+	}
+	return append(oldNames, oldName)
+}
+
 // updateFieldDefs updates fields definitions if necessary
 func updateFieldDefs() {
 	for _, model := range Registry.registryByName {
 		for _, fi := range model.fields.registryByName {
+			if fi.encrypted && fi.fieldType != fieldtype.Char && fi.fieldType != fieldtype.Text && fi.fieldType != fieldtype.Binary {
+				log.Panic("Only Char, Text and Binary fields can be encrypted", "model", model.name, "field", fi.name, "type", fi.fieldType)
+			}
 			switch fi.fieldType {
 			case fieldtype.Boolean:
 				if fi.defaultFunc != nil && fi.isSettable() {
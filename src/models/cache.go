@@ -176,6 +176,14 @@ func (c *cache) deleteData(model string, id int64) {
 	delete(c.x2mRelated[model], id)
 }
 
+// deleteModelData removes the cache entries of all records of the given model
+func (c *cache) deleteModelData(model string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.data, model)
+	delete(c.x2mRelated, model)
+}
+
 // removeM2MLinks removes all M2M links associated with the record with
 // the given id on the given field
 func (c *cache) removeM2MLinks(fi *Field, id int64) {
@@ -0,0 +1,254 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/gleke/hexya/src/models/fieldtype"
+	"github.com/gleke/hexya/src/models/security"
+)
+
+const (
+	// CompanyContextKey is the key of the context holding
+	// the ID of the current company of the user.
+	CompanyContextKey = "company_id"
+	// AllowedCompaniesContextKey is the key of the context holding
+	// the IDs of the companies whose records the user can access.
+	AllowedCompaniesContextKey = "allowed_company_ids"
+	// companyRuleName is the name of the record rule added to
+	// models with a company field.
+	companyRuleName = "hexya_company"
+	// companyContextName is the name of the field context of
+	// company dependent fields.
+	companyContextName = "company"
+)
+
+// A UserCompaniesFunc returns the IDs of the companies whose records
+// the user with the given uid is allowed to access.
+type UserCompaniesFunc func(env Environment, uid int64) []int64
+
+var (
+	userCompaniesMutex sync.RWMutex
+	userCompaniesFunc  UserCompaniesFunc
+)
+
+// RegisterUserCompaniesFunc registers the function that returns the
+// companies of a user. It is typically registered by the module that
+// defines the companies of the users.
+//
+// The companies requested by the context of an Environment are restricted
+// to the companies returned by this function. Users can only access the
+// records without company if no function is registered.
+func RegisterUserCompaniesFunc(f UserCompaniesFunc) {
+	userCompaniesMutex.Lock()
+	defer userCompaniesMutex.Unlock()
+	userCompaniesFunc = f
+}
+
+// userCompanies returns the IDs of the companies of the user of the given
+// Environment, as given by the registered UserCompaniesFunc.
+//
+// The function is called as superuser and without company context, so that
+// its queries are not restricted by company rules.
+func userCompanies(env Environment) []int64 {
+	userCompaniesMutex.RLock()
+	fnct := userCompaniesFunc
+	userCompaniesMutex.RUnlock()
+	if fnct == nil {
+		return nil
+	}
+	uid := env.uid
+	ctx := env.context.Copy()
+	ctx.Delete(CompanyContextKey)
+	ctx.Delete(AllowedCompaniesContextKey)
+	env.context = ctx
+	env.uid = security.SuperUserID
+	return fnct(env, uid)
+}
+
+// checkedAllowedCompanyIDs returns the IDs of the companies whose records
+// the user of the given Environment can access. It returns false as second
+// value if records are not restricted by company.
+//
+// The companies requested by the context are restricted to the companies of
+// the user, and default to all of them. The superuser is trusted and is only
+// restricted by the companies requested by the context, if any.
+func checkedAllowedCompanyIDs(env Environment) ([]int64, bool) {
+	requested := env.AllowedCompanyIDs()
+	hasRequest := env.context.HasKey(AllowedCompaniesContextKey) || env.CompanyID() != 0
+	if env.uid == security.SuperUserID {
+		return requested, hasRequest
+	}
+	companies := userCompanies(env)
+	if !hasRequest {
+		return companies, true
+	}
+	userCompanySet := make(map[int64]bool)
+	for _, id := range companies {
+		userCompanySet[id] = true
+	}
+	var res []int64
+	for _, id := range requested {
+		if userCompanySet[id] {
+			res = append(res, id)
+		}
+	}
+	return res, true
+}
+
+// companyContext returns the value of the company context of company
+// dependent fields for the given RecordSet.
+func companyContext(rs RecordSet) string {
+	companyID := rs.Env().CompanyID()
+	if companyID == 0 {
+		return ""
+	}
+	return strconv.FormatInt(companyID, 10)
+}
+
+// SetCompanyField sets the Many2One field with the given name as the company
+// field of this model.
+//
+// Users can then only access the records of this model that belong to the
+// allowed companies of their Environment and of the user (see
+// RegisterUserCompaniesFunc), or that have no company, and the relation
+// fields of the records cannot point to records of another company.
+//
+// Calling SetCompanyField with an empty name removes the company field.
+func (m *Model) SetCompanyField(name string) {
+	m.companyField = name
+	if Registry.bootstrapped {
+		m.setupCompanyRule()
+	}
+}
+
+// CompanyField returns the name of the company field of this
+// model, or an empty string if this model has no company field.
+func (m *Model) CompanyField() string {
+	return m.companyField
+}
+
+// setupCompanyRule adds the record rule that restricts the records of this
+// model to the allowed companies, or removes it if this model has no
+// company field.
+//
+// It panics if the company field is not a Many2One field of this model.
+func (m *Model) setupCompanyRule() {
+	m.rulesRegistry.RLock()
+	_, exists := m.rulesRegistry.rulesByName[companyRuleName]
+	m.rulesRegistry.RUnlock()
+	if exists {
+		m.RemoveRecordRule(companyRuleName)
+	}
+	if m.companyField == "" {
+		return
+	}
+	fi, ok := m.fields.Get(m.companyField)
+	if !ok || fi.fieldType != fieldtype.Many2One {
+		log.Panic("Company field must be a Many2One field of the model", "model", m.name, "field", m.companyField)
+	}
+	companyField := m.FieldName(fi.name)
+	m.AddRecordRule(&RecordRule{
+		Name:   companyRuleName,
+		Global: true,
+		ConditionFunc: func(env Environment) *Condition {
+			allowed, restricted := checkedAllowedCompanyIDs(env)
+			if !restricted {
+				return nil
+			}
+			if len(allowed) == 0 {
+				return m.Field(companyField).IsNull()
+			}
+			return m.Field(companyField).IsNull().Or().Field(companyField).In(allowed)
+		},
+		Perms: security.All,
+	})
+}
+
+// setupCompanyRules adds the company record rule to
+// all the models that have a company field.
+func setupCompanyRules() {
+	for _, model := range Registry.registryByName {
+		if model.companyField == "" {
+			continue
+		}
+		model.setupCompanyRule()
+	}
+}
+
+// companyRelationFields returns the relation fields of this model among the
+// given fields that point to records of a model with a company field on the
+// same company model. All relation fields are returned if the company field
+// is among the given fields.
+func (m *Model) companyRelationFields(fields FieldNames) []*Field {
+	companyFI := m.fields.MustGet(m.companyField)
+	for _, f := range fields {
+		if f.Name() == companyFI.name {
+			fields = nil
+			for _, fi := range m.fields.registryByName {
+				fields = append(fields, fi)
+			}
+			break
+		}
+	}
+	var res []*Field
+	for _, f := range fields {
+		fi, ok := m.fields.Get(f.Name())
+		if !ok || fi == companyFI || fi.isRelatedField() {
+			continue
+		}
+		if fi.fieldType != fieldtype.Many2One && fi.fieldType != fieldtype.Many2Many {
+			continue
+		}
+		if fi.relatedModel == nil || fi.relatedModel.companyField == "" {
+			continue
+		}
+		relCompanyFI := fi.relatedModel.fields.MustGet(fi.relatedModel.companyField)
+		if relCompanyFI.relatedModelName != companyFI.relatedModelName {
+			continue
+		}
+		res = append(res, fi)
+	}
+	return res
+}
+
+// checkCompanies panics if the given fields of the records of this
+// RecordCollection point to records that belong to another company.
+//
+// Records without company can be linked to records of any company.
+func (rc *RecordCollection) checkCompanies(fields FieldNames) {
+	if rc.model.companyField == "" || rc.hasNegIds {
+		return
+	}
+	relFields := rc.model.companyRelationFields(fields)
+	if len(relFields) == 0 {
+		return
+	}
+	// We check all companies, whatever the allowed companies of the user
+	ctx := rc.env.context.Copy()
+	ctx.Delete(CompanyContextKey)
+	ctx.Delete(AllowedCompaniesContextKey)
+	rSet := rc.WithNewContext(ctx).Sudo()
+	companyField := rc.model.FieldName(rc.model.companyField)
+	for _, rec := range rSet.Records() {
+		company := rec.Get(companyField).(RecordSet).Collection()
+		if company.IsEmpty() {
+			continue
+		}
+		for _, fi := range relFields {
+			relCompanyField := fi.relatedModel.FieldName(fi.relatedModel.companyField)
+			for _, relRec := range rec.Get(fi).(RecordSet).Collection().Records() {
+				relCompany := relRec.Get(relCompanyField).(RecordSet).Collection()
+				if relCompany.IsEmpty() || relCompany.ids[0] == company.ids[0] {
+					continue
+				}
+				log.Panic("Record cannot be linked to a record of another company", "model", rc.model.name,
+					"id", rec.ids[0], "field", fi.name, "relatedID", relRec.ids[0], "company", company.ids[0],
+					"relatedCompany", relCompany.ids[0])
+			}
+		}
+	}
+}
@@ -390,6 +390,13 @@ func (c ConditionField) ChildOf(data interface{}) *Condition {
 	return c.AddOperator(operator.ChildOf, data)
 }
 
+// Overlaps appends the 'overlaps' operator to the current Condition.
+// This operator is only valid on array fields and matches records
+// that have at least one element in common with data.
+func (c ConditionField) Overlaps(data interface{}) *Condition {
+	return c.AddOperator(operator.Overlaps, data)
+}
+
 // IsNull checks if the current condition field is null
 func (c ConditionField) IsNull() *Condition {
 	return c.AddOperator(operator.Equals, nil)
@@ -127,6 +127,24 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 			ids := strings.Split(record[i], "|")
 			relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field(fi.relatedModel.FieldName("HexyaExternalID")).In(ids))
 			val = relRC
+		case fi.fieldType == fieldtype.CharArray:
+			vals := []string{}
+			if record[i] != "" {
+				vals = strings.Split(record[i], "|")
+			}
+			val = vals
+		case fi.fieldType == fieldtype.IntegerArray:
+			vals := []int64{}
+			if record[i] != "" {
+				for _, v := range strings.Split(record[i], "|") {
+					iv, err := strconv.ParseInt(v, 0, 64)
+					if err != nil {
+						log.Panic("Error while converting integer", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
+					}
+					vals = append(vals, iv)
+				}
+			}
+			val = vals
 		case fi.fieldType == fieldtype.Binary:
 			if record[i] == "" {
 				continue
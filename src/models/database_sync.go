@@ -5,59 +5,237 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/gleke/hexya/src/models/security"
+	"github.com/gleke/hexya/src/tools/strutils"
 )
 
 // SyncDatabase creates or updates database tables with the data in the model registry
 func SyncDatabase() {
 	log.Info("Updating database schema")
-	adapter := adapters[db.DriverName()]
-	dbTables := adapter.tables()
+	newSchemaSync(false).run()
+	ReloadGroups()
+}
+
+// SyncOptions define how SyncDatabase handles changes that may lose data
+type SyncOptions struct {
+	// SafeMode prevents SyncDatabase from dropping data. Orphan
+	// tables, columns, views and boot sequences are kept and reported,
+	// and column type changes that may lose data are refused.
+	SafeMode bool
+	// AllowDestructiveTypeChanges allows column type changes that
+	// may lose data in safe mode.
+	AllowDestructiveTypeChanges bool
+	// Prune drops the tables, columns, views and boot sequences that
+	// are not used by the models, even in safe mode.
+	Prune bool
+}
+
+// systemTables are tables that are not defined by models and
+// that must not be dropped when synchronizing the database.
+var systemTables = make(map[string]bool)
+
+// RegisterSystemTable declares the given table as a system table that is
+// managed outside of the models, so that it is never dropped by SyncDatabase.
+func RegisterSystemTable(tableName string) {
+	systemTables[tableName] = true
+}
+
+// SchemaCreated returns true if the tables of the models have already been
+// created in the database of the given Environment, that is if the database
+// has already been synchronized.
+func SchemaCreated(env Environment) bool {
+	var tables []string
+	for _, model := range Registry.registryByName {
+		if model.IsMixin() || model.IsManual() {
+			continue
+		}
+		tables = append(tables, model.tableName)
+	}
+	if len(tables) == 0 {
+		return false
+	}
+	var count int
+	env.cr.Get(&count, `
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')
+			AND table_name IN (?)`, tables)
+	return count > 0
+}
+
+// syncOptions are the options used by SyncDatabase
+var syncOptions SyncOptions
+
+// SetSyncOptions sets the options used by SyncDatabase and PlanDatabaseSync.
+func SetSyncOptions(opts SyncOptions) {
+	syncOptions = opts
+}
+
+// PlanDatabaseSync computes the changes that SyncDatabase would apply
+// to the database schema, without modifying the database.
+func PlanDatabaseSync() SchemaPlan {
+	ss := newSchemaSync(true)
+	ss.run()
+	return ss.plan
+}
+
+// A schemaSync synchronizes the database schema with the model registry.
+//
+// Each change is recorded in the plan and executed unless dryRun is set.
+type schemaSync struct {
+	adapter dbAdapter
+	options SyncOptions
+	dryRun  bool
+	plan    SchemaPlan
+	// oldTables maps the tables renamed during this sync to their previous name
+	oldTables map[string]string
+	// renamed maps the indexes and constraints renamed during this sync to their previous name
+	renamed map[string]string
+	// droppedViews holds the views dropped during this sync
+	droppedViews map[string]bool
+}
+
+// newSchemaSync returns a new schemaSync for the current database
+func newSchemaSync(dryRun bool) *schemaSync {
+	return &schemaSync{
+		adapter:      adapters[db.DriverName()],
+		options:      syncOptions,
+		dryRun:       dryRun,
+		oldTables:    make(map[string]string),
+		renamed:      make(map[string]string),
+		droppedViews: make(map[string]bool),
+	}
+}
+
+// run computes and applies (unless in dry run mode) all schema changes
+func (ss *schemaSync) run() {
+	dbTables := ss.adapter.tables()
+	tableNames := make([]string, 0, len(Registry.registryByTableName))
+	for tableName := range Registry.registryByTableName {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
 	// Create or update sequences
-	updateDBSequences()
+	ss.updateDBSequences()
 	// Create or update existing tables
-	for tableName, model := range Registry.registryByTableName {
+	for _, tableName := range tableNames {
+		model := Registry.registryByTableName[tableName]
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
-		if _, ok := dbTables[tableName]; !ok {
-			createDBTable(model)
+		if _, ok := dbTables[tableName]; !ok && !ss.renameDBTable(model, dbTables) {
+			ss.createDBTable(model)
+			if ss.dryRun {
+				// Columns are created with the table
+				ss.updateDBIndexes(model)
+				ss.updateDBModelIndexes(model)
+				continue
+			}
 		}
-		updateDBColumns(model)
-		updateDBIndexes(model)
+		ss.updateDBColumns(model)
+		ss.updateDBIndexes(model)
+		ss.updateDBModelIndexes(model)
 	}
 	// Setup constraints
-	for _, model := range Registry.registryByTableName {
+	for _, tableName := range tableNames {
+		model := Registry.registryByTableName[tableName]
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
 		buildSQLErrorSubstitutionMap(model)
-		updateDBForeignKeyConstraints(model)
-		updateDBConstraints(model)
+		ss.updateDBForeignKeyConstraints(model)
+		ss.updateDBConstraints(model)
+	}
+	// Create or replace views
+	ss.updateDBViews()
+	if !ss.dryRun {
+		// Run init method on each model
+		for _, tableName := range tableNames {
+			model := Registry.registryByTableName[tableName]
+			if model.IsMixin() {
+				continue
+			}
+			runInit(model)
+		}
+	}
+	// Drop DB tables that are not in the models
+	dbTableNames := make([]string, 0, len(dbTables))
+	for dbTable := range dbTables {
+		dbTableNames = append(dbTableNames, dbTable)
 	}
-	// Run init method on each model
-	for _, model := range Registry.registryByTableName {
-		if model.IsMixin() {
+	sort.Strings(dbTableNames)
+	renamedTables := make(map[string]bool)
+	for _, oldTable := range ss.oldTables {
+		renamedTables[oldTable] = true
+	}
+	for _, dbTable := range dbTableNames {
+		if model, ok := Registry.registryByTableName[dbTable]; ok && !model.IsMixin() {
+			continue
+		}
+		if systemTables[dbTable] || renamedTables[dbTable] {
 			continue
 		}
-		runInit(model)
+		ss.dropDBTable(dbTable)
 	}
+}
 
-	// Drop DB tables that are not in the models
-	for dbTable := range adapter.tables() {
-		var modelExists bool
-		for tableName, model := range Registry.registryByTableName {
-			if dbTable != tableName || model.IsMixin() {
-				continue
-			}
-			modelExists = true
-			break
+// dbTableName returns the current name in the database of the given table.
+// This is the previous name of the table if it has been renamed in dry run mode.
+func (ss *schemaSync) dbTableName(tableName string) string {
+	if oldTable, ok := ss.oldTables[tableName]; ok && ss.dryRun {
+		return oldTable
+	}
+	return tableName
+}
+
+// indexExists returns true if the given index exists in the
+// database or has been renamed during this sync.
+func (ss *schemaSync) indexExists(tableName, indexName string) bool {
+	if _, ok := ss.renamed[indexName]; ok {
+		return true
+	}
+	return ss.adapter.indexExists(ss.dbTableName(tableName), indexName)
+}
+
+// constraintExists returns true if the given constraint exists in
+// the database or has been renamed during this sync.
+func (ss *schemaSync) constraintExists(constraintName string) bool {
+	if _, ok := ss.renamed[constraintName]; ok {
+		return true
+	}
+	return ss.adapter.constraintExists(constraintName)
+}
+
+// keepsData returns true if data must not be dropped in this sync
+func (ss *schemaSync) keepsData() bool {
+	return ss.options.SafeMode && !ss.options.Prune
+}
+
+// apply records the given change in the plan and executes it
+// in the database unless we are in dry run mode.
+func (ss *schemaSync) apply(change SchemaChange) {
+	ss.plan = append(ss.plan, change)
+	if change.Type.sign() == "!" {
+		log.Warn("Schema change not applied in safe mode", "change", change.Type, "table", change.Table, "name", change.Name)
+	}
+	if ss.dryRun || change.SQL == "" {
+		return
+	}
+	switch change.Type {
+	case SchemaSetNotNull, SchemaDropNotNull:
+		query, args := sanitizeQuery(change.SQL, change.Args...)
+		if _, err := db.Exec(query, args...); err != nil {
+			log.Warn("unable to change NOT NULL constraint", "table", change.Table, "column", change.Name, "change", change.Type)
 		}
-		if !modelExists {
-			dropDBTable(dbTable)
+	default:
+		if len(change.Args) == 0 {
+			dbExecuteRawNoTx(change.SQL)
+			return
 		}
+		dbExecuteNoTx(change.SQL, change.Args...)
 	}
 }
 
@@ -67,6 +245,11 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 	for sqlConstrName, sqlConstr := range model.sqlConstraints {
 		model.sqlErrors[sqlConstrName] = sqlConstr.errorString
 	}
+	for indexName, index := range model.sqlIndexes {
+		if index.unique {
+			model.sqlErrors[indexName] = fmt.Sprintf("%s must be unique", strings.Join(index.exprs, ", "))
+		}
+	}
 	for _, field := range model.fields.registryByJSON {
 		if field.unique {
 			cName := fmt.Sprintf("%s_%s_key", model.tableName, field.json)
@@ -80,27 +263,52 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 }
 
 // updateDBSequences creates sequences in the DB from data in the registry.
-func updateDBSequences() {
-	adapter := adapters[db.DriverName()]
+//
+// The start value of existing sequences is not modified.
+func (ss *schemaSync) updateDBSequences() {
+	dbSeqs := ss.adapter.sequences("%_bootseq")
+	seqNames := make([]string, 0, len(Registry.sequences))
+	for seqName := range Registry.sequences {
+		seqNames = append(seqNames, seqName)
+	}
+	sort.Strings(seqNames)
 	// Create or alter boot sequences
-	for _, sequence := range Registry.sequences {
+	for _, seqName := range seqNames {
+		sequence := Registry.sequences[seqName]
 		if !sequence.boot {
 			continue
 		}
-		exists := false
-		for _, dbSeq := range adapter.sequences("%_bootseq") {
-			if sequence.JSON == dbSeq.Name {
+		var (
+			dbSeq  seqData
+			exists bool
+		)
+		for _, ds := range dbSeqs {
+			if sequence.JSON == ds.Name {
+				dbSeq = ds
 				exists = true
 			}
 		}
 		if !exists {
-			adapter.createSequence(sequence.JSON, sequence.Increment, sequence.Start)
+			ss.apply(SchemaChange{
+				Type: SchemaCreateSequence,
+				Name: sequence.JSON,
+				To:   fmt.Sprintf("increment %d, start %d", sequence.Increment, sequence.Start),
+				SQL:  ss.adapter.createSequenceSQL(sequence.JSON, sequence.Increment, sequence.Start),
+			})
 			continue
 		}
-		adapter.alterSequence(sequence.JSON, sequence.Increment, sequence.Start)
+		if dbSeq.Increment != sequence.Increment {
+			ss.apply(SchemaChange{
+				Type: SchemaAlterSequence,
+				Name: sequence.JSON,
+				From: fmt.Sprintf("increment %d", dbSeq.Increment),
+				To:   fmt.Sprintf("increment %d", sequence.Increment),
+				SQL:  ss.adapter.alterSequenceSQL(sequence.JSON, sequence.Increment, 0),
+			})
+		}
 	}
 	// Drop unused boot sequences
-	for _, dbSeq := range adapter.sequences("%_bootseq") {
+	for _, dbSeq := range dbSeqs {
 		var sequenceExists bool
 		for _, sequence := range Registry.sequences {
 			if sequence.JSON == dbSeq.Name {
@@ -108,236 +316,711 @@ func updateDBSequences() {
 				break
 			}
 		}
-		if !sequenceExists {
-			adapter.dropSequence(dbSeq.Name)
+		switch {
+		case sequenceExists:
+			continue
+		case ss.keepsData():
+			ss.apply(SchemaChange{
+				Type: SchemaKeepSequence,
+				Name: dbSeq.Name,
+			})
+			continue
 		}
+		ss.apply(SchemaChange{
+			Type: SchemaDropSequence,
+			Name: dbSeq.Name,
+			SQL:  ss.adapter.dropSequenceSQL(dbSeq.Name),
+		})
 	}
 }
 
 // createDBTable creates a table in the database from the given Model
 // It only creates the primary key. Call updateDBColumns to create columns.
-func createDBTable(m *Model) {
-	adapter := adapters[db.DriverName()]
+func (ss *schemaSync) createDBTable(m *Model) {
 	var columns []string
-	for colName, fi := range m.fields.registryByJSON {
-		if colName == "id" || !fi.isStored() {
-			continue
-		}
-		col := fmt.Sprintf("%s %s", colName, adapter.columnSQLDefinition(fi, false))
+	colNames := m.storedColumnNames()
+	for _, colName := range colNames {
+		col := fmt.Sprintf("%s %s", colName, ss.adapter.columnSQLDefinition(m.fields.registryByJSON[colName], false))
 		columns = append(columns, col)
 	}
 	query := fmt.Sprintf(`
 CREATE TABLE %s (
 	id serial NOT NULL PRIMARY KEY`,
-		adapter.quoteTableName(m.tableName))
+		ss.adapter.quoteTableName(m.tableName))
 	if len(columns) > 0 {
 		query += ",\n\t" + strings.Join(columns, ",\n\t")
 	}
 	query += "\n)"
-	dbExecuteNoTx(query)
+	ss.apply(SchemaChange{
+		Type:  SchemaCreateTable,
+		Table: m.tableName,
+		SQL:   query,
+	})
+	// Record columns in the plan. They are created with the table.
+	for _, colName := range colNames {
+		ss.apply(SchemaChange{
+			Type:  SchemaAddColumn,
+			Table: m.tableName,
+			Name:  colName,
+			To:    ss.adapter.columnSQLDefinition(m.fields.registryByJSON[colName], false),
+		})
+	}
 }
 
 // dropDBTable drops the given table in the database
-func dropDBTable(tableName string) {
-	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
-	dbExecuteNoTx(query)
+func (ss *schemaSync) dropDBTable(tableName string) {
+	if ss.keepsData() {
+		ss.apply(SchemaChange{
+			Type:  SchemaKeepTable,
+			Table: tableName,
+		})
+		return
+	}
+	ss.dropDependentViews(tableName)
+	ss.apply(SchemaChange{
+		Type:  SchemaDropTable,
+		Table: tableName,
+		SQL:   fmt.Sprintf(`DROP TABLE %s`, ss.adapter.quoteTableName(tableName)),
+	})
+}
+
+// renameDBTable renames the table of a previous name of the given model
+// to the model's table, if such a table exists in the database and is not
+// used by another model. It returns true if the table has been renamed.
+func (ss *schemaSync) renameDBTable(m *Model, dbTables map[string]bool) bool {
+	renamedTables := make(map[string]bool)
+	for _, oldTable := range ss.oldTables {
+		renamedTables[oldTable] = true
+	}
+	for _, oldTable := range m.oldTableNames() {
+		if !dbTables[oldTable] || renamedTables[oldTable] {
+			continue
+		}
+		if other, ok := Registry.registryByTableName[oldTable]; ok && !other.IsMixin() {
+			continue
+		}
+		ss.apply(SchemaChange{
+			Type:  SchemaRenameTable,
+			Table: m.tableName,
+			From:  oldTable,
+			To:    m.tableName,
+			SQL:   fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, ss.adapter.quoteTableName(oldTable), ss.adapter.quoteTableName(m.tableName)),
+		})
+		ss.oldTables[m.tableName] = oldTable
+		oldSeq, newSeq := fmt.Sprintf("%s_id_seq", oldTable), fmt.Sprintf("%s_id_seq", m.tableName)
+		if ss.adapter.sequenceExists(oldSeq) && !ss.adapter.sequenceExists(newSeq) {
+			ss.apply(SchemaChange{
+				Type:  SchemaRenameSequence,
+				Table: m.tableName,
+				Name:  newSeq,
+				From:  oldSeq,
+				To:    newSeq,
+				SQL:   fmt.Sprintf(`ALTER SEQUENCE %s RENAME TO %s`, ss.adapter.quoteTableName(oldSeq), ss.adapter.quoteTableName(newSeq)),
+			})
+		}
+		oldPKey, newPKey := fmt.Sprintf("%s_pkey", oldTable), fmt.Sprintf("%s_pkey", m.tableName)
+		if ss.adapter.constraintExists(oldPKey) && !ss.adapter.constraintExists(newPKey) {
+			ss.renameConstraint(m.tableName, oldPKey, newPKey)
+		}
+		for constraintName := range m.sqlConstraints {
+			oldName := fmt.Sprintf("%s_%s_mancon", strings.TrimSuffix(constraintName, fmt.Sprintf("_%s_mancon", m.tableName)), oldTable)
+			if ss.adapter.constraintExists(oldName) {
+				ss.renameConstraint(m.tableName, oldName, constraintName)
+			}
+		}
+		dbIndexes := ss.adapter.modelIndexes(ss.dbTableName(m.tableName))
+		for indexName := range m.sqlIndexes {
+			oldName := fmt.Sprintf("%s_%s_manidx", strings.TrimSuffix(indexName, fmt.Sprintf("_%s_manidx", m.tableName)), oldTable)
+			if _, ok := dbIndexes[oldName]; ok {
+				ss.renameIndex(m.tableName, oldName, indexName)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// renameDBColumn renames the column oldColName of the given table to colName
+func (ss *schemaSync) renameDBColumn(tableName, oldColName, colName string) {
+	query := fmt.Sprintf(`
+		ALTER TABLE %s
+		RENAME COLUMN %s TO %s
+	`, ss.adapter.quoteTableName(ss.dbTableName(tableName)), oldColName, colName)
+	ss.apply(SchemaChange{
+		Type:  SchemaRenameColumn,
+		Table: tableName,
+		Name:  colName,
+		From:  oldColName,
+		To:    colName,
+		SQL:   query,
+	})
+}
+
+// renameColumnObjects renames the index and the constraints of the column
+// oldColName of oldTableName after the column or its table has been renamed.
+func (ss *schemaSync) renameColumnObjects(oldTableName, oldColName, tableName, colName string) {
+	oldIndex := fmt.Sprintf("%s_%s_index", oldTableName, oldColName)
+	if ss.adapter.indexExists(ss.dbTableName(tableName), oldIndex) {
+		ss.renameIndex(tableName, oldIndex, fmt.Sprintf("%s_%s_index", tableName, colName))
+	}
+	for _, suffix := range []string{"fkey", "key"} {
+		oldName := fmt.Sprintf("%s_%s_%s", oldTableName, oldColName, suffix)
+		if ss.adapter.constraintExists(oldName) {
+			ss.renameConstraint(tableName, oldName, fmt.Sprintf("%s_%s_%s", tableName, colName, suffix))
+		}
+	}
+}
+
+// renameIndex renames the index oldName of the given table to newName
+func (ss *schemaSync) renameIndex(tableName, oldName, newName string) {
+	ss.apply(SchemaChange{
+		Type:  SchemaRenameIndex,
+		Table: tableName,
+		Name:  newName,
+		From:  oldName,
+		To:    newName,
+		SQL:   fmt.Sprintf(`ALTER INDEX %s RENAME TO %s`, oldName, newName),
+	})
+	ss.renamed[newName] = oldName
+}
+
+// renameConstraint renames the constraint oldName of the given table to newName
+func (ss *schemaSync) renameConstraint(tableName, oldName, newName string) {
+	query := fmt.Sprintf(`
+		ALTER TABLE %s RENAME CONSTRAINT %s TO %s
+	`, ss.adapter.quoteTableName(ss.dbTableName(tableName)), oldName, newName)
+	ss.apply(SchemaChange{
+		Type:  SchemaRenameConstraint,
+		Table: tableName,
+		Name:  newName,
+		From:  oldName,
+		To:    newName,
+		SQL:   query,
+	})
+	ss.renamed[newName] = oldName
 }
 
 // updateDBColumns synchronizes the colums of the database with the
 // given Model.
-func updateDBColumns(mi *Model) {
-	adapter := adapters[db.DriverName()]
-	dbColumns := adapter.columns(mi.tableName)
+//
+// Columns of fields with old names are renamed instead of being created
+// when a column with one of these names exists and is not used anymore.
+func (ss *schemaSync) updateDBColumns(mi *Model) {
+	dbColumns := ss.adapter.columns(ss.dbTableName(mi.tableName))
+	oldTableName, tableRenamed := ss.oldTables[mi.tableName]
+	if !tableRenamed {
+		oldTableName = mi.tableName
+	}
 	// create or update columns from registry data
-	for colName, fi := range mi.fields.registryByJSON {
-		if colName == "id" || !fi.isStored() {
-			continue
-		}
+	for _, colName := range mi.storedColumnNames() {
+		fi := mi.fields.registryByJSON[colName]
+		oldColName := colName
 		dbColData, ok := dbColumns[colName]
 		if !ok {
-			createDBColumn(fi)
+			oldColName, ok = ss.findOldDBColumn(fi, dbColumns)
+			if ok {
+				ss.renameDBColumn(mi.tableName, oldColName, colName)
+				dbColData = dbColumns[oldColName]
+				delete(dbColumns, oldColName)
+				dbColumns[colName] = dbColData
+			}
+		}
+		if ok && (tableRenamed || oldColName != colName) {
+			ss.renameColumnObjects(oldTableName, oldColName, mi.tableName, colName)
+		}
+		if !ok {
+			ss.createDBColumn(fi)
 			continue
 		}
-		if dbColData.DataType != adapter.typeSQL(fi) {
-			updateDBColumnDataType(fi)
+		if dbColData.DataType != ss.adapter.typeSQL(fi) {
+			ss.updateDBColumnDataType(fi, dbColData.DataType)
 		}
-		if (dbColData.IsNullable == "NO" && !adapter.fieldIsNotNull(fi)) ||
-			(dbColData.IsNullable == "YES" && adapter.fieldIsNotNull(fi)) {
-			updateDBColumnNullable(fi)
+		if (dbColData.IsNullable == "NO" && !ss.adapter.fieldIsNotNull(fi)) ||
+			(dbColData.IsNullable == "YES" && ss.adapter.fieldIsNotNull(fi)) {
+			ss.updateDBColumnNullable(fi)
 		}
 	}
 	// drop columns that no longer exist
+	dbColNames := make([]string, 0, len(dbColumns))
 	for colName := range dbColumns {
+		dbColNames = append(dbColNames, colName)
+	}
+	sort.Strings(dbColNames)
+	for _, colName := range dbColNames {
 		if _, ok := mi.fields.registryByJSON[colName]; !ok {
-			dropDBColumn(mi.tableName, colName)
+			ss.dropDBColumn(mi.tableName, colName, dbColumns[colName])
 		}
 	}
 }
 
+// findOldDBColumn returns the column of dbColumns that matches an old
+// name of the given field and that is not used by another field.
+func (ss *schemaSync) findOldDBColumn(fi *Field, dbColumns map[string]ColumnData) (string, bool) {
+	for _, oldColName := range fi.oldColumnNames() {
+		if _, exists := dbColumns[oldColName]; !exists {
+			continue
+		}
+		if _, used := fi.model.fields.registryByJSON[oldColName]; used {
+			continue
+		}
+		return oldColName, true
+	}
+	return "", false
+}
+
 // createDBColumn insert the column described by Field in the database
-func createDBColumn(fi *Field) {
+func (ss *schemaSync) createDBColumn(fi *Field) {
 	if !fi.isStored() {
 		log.Panic("createDBColumn should not be called on non stored fields", "model", fi.model.name, "field", fi.json)
 	}
-	adapter := adapters[db.DriverName()]
 	// Add column without not null
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ADD COLUMN %s %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi, true))
-	dbExecuteNoTx(query)
+	`, ss.adapter.quoteTableName(fi.model.tableName), fi.json, ss.adapter.columnSQLDefinition(fi, true))
+	ss.apply(SchemaChange{
+		Type:  SchemaAddColumn,
+		Table: fi.model.tableName,
+		Name:  fi.json,
+		To:    ss.adapter.columnSQLDefinition(fi, false),
+		SQL:   query,
+	})
 	// Set default value if defined
 	if fi.defaultFunc != nil {
 		updateQuery := fmt.Sprintf(`
 			UPDATE %s SET %s = ? WHERE %s IS NULL
-		`, adapter.quoteTableName(fi.model.tableName), fi.json, fi.json)
+		`, ss.adapter.quoteTableName(fi.model.tableName), fi.json, fi.json)
 		var defaultValue interface{}
 		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
 			defaultValue = fi.defaultFunc(env)
 		})
-		dbExecuteNoTx(updateQuery, defaultValue)
+		switch {
+		case fi.fieldType.IsArrayType():
+			defaultValue = ss.adapter.arrayArg(defaultValue)
+		case fi.encrypted:
+			defaultValue = encryptFieldValue(fi, defaultValue)
+		}
+		ss.apply(SchemaChange{
+			Type:  SchemaSetDefault,
+			Table: fi.model.tableName,
+			Name:  fi.json,
+			SQL:   updateQuery,
+			Args:  []interface{}{defaultValue},
+		})
 	}
 	// Add not null if required
-	updateDBColumnNullable(fi)
+	if ss.adapter.fieldIsNotNull(fi) {
+		ss.updateDBColumnNullable(fi)
+	}
 }
 
-// updateDBColumnDataType updates the data type in database for the given Field
-func updateDBColumnDataType(fi *Field) {
-	adapter := adapters[db.DriverName()]
+// updateDBColumnDataType updates the data type in database for the given Field.
+// dbType is the current data type of the column in the database.
+//
+// In safe mode, type changes that may lose data are refused
+// unless AllowDestructiveTypeChanges is set.
+func (ss *schemaSync) updateDBColumnDataType(fi *Field, dbType string) {
+	if ss.options.SafeMode && !ss.options.AllowDestructiveTypeChanges && !ss.adapter.isSafeTypeChange(dbType, ss.adapter.typeSQL(fi)) {
+		ss.apply(SchemaChange{
+			Type:  SchemaRefuseColumnType,
+			Table: fi.model.tableName,
+			Name:  fi.json,
+			From:  dbType,
+			To:    ss.adapter.typeSQL(fi),
+		})
+		return
+	}
+	ss.dropDependentViews(fi.model.tableName)
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ALTER COLUMN %s SET DATA TYPE %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi))
-	dbExecuteNoTx(query)
+	`, ss.adapter.quoteTableName(fi.model.tableName), fi.json, ss.adapter.typeSQL(fi))
+	ss.apply(SchemaChange{
+		Type:  SchemaAlterColumnType,
+		Table: fi.model.tableName,
+		Name:  fi.json,
+		From:  dbType,
+		To:    ss.adapter.typeSQL(fi),
+		SQL:   query,
+	})
 }
 
 // updateDBColumnNullable updates the NULL/NOT NULL data in database for the given Field
-func updateDBColumnNullable(fi *Field) {
-	adapter := adapters[db.DriverName()]
-	var verb string
-	if adapter.fieldIsNotNull(fi) {
+func (ss *schemaSync) updateDBColumnNullable(fi *Field) {
+	verb := "DROP"
+	changeType := SchemaDropNotNull
+	if ss.adapter.fieldIsNotNull(fi) {
 		verb = "SET"
-	} else {
-		verb = "DROP"
+		changeType = SchemaSetNotNull
 	}
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ALTER COLUMN %s %s NOT NULL
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
-	query, _ = sanitizeQuery(query)
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Warn("unable to change NOT NULL constraint", "model", fi.model.name, "field", fi.name, "verb", verb)
-	}
+	`, ss.adapter.quoteTableName(fi.model.tableName), fi.json, verb)
+	ss.apply(SchemaChange{
+		Type:  changeType,
+		Table: fi.model.tableName,
+		Name:  fi.json,
+		SQL:   query,
+	})
 }
 
 // dropDBColumn drops the column colName from table tableName in database
-func dropDBColumn(tableName, colName string) {
-	adapter := adapters[db.DriverName()]
+//
+// In safe mode, the column is kept and made nullable so that
+// it does not prevent the creation of new records.
+func (ss *schemaSync) dropDBColumn(tableName, colName string, dbColData ColumnData) {
+	if ss.keepsData() {
+		ss.apply(SchemaChange{
+			Type:  SchemaKeepColumn,
+			Table: tableName,
+			Name:  colName,
+		})
+		if dbColData.IsNullable == "NO" {
+			ss.apply(SchemaChange{
+				Type:  SchemaDropNotNull,
+				Table: tableName,
+				Name:  colName,
+				SQL: fmt.Sprintf(`
+		ALTER TABLE %s
+		ALTER COLUMN %s DROP NOT NULL
+	`, ss.adapter.quoteTableName(tableName), colName),
+			})
+		}
+		return
+	}
+	ss.dropDependentViews(tableName)
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		DROP COLUMN %s
-	`, adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	`, ss.adapter.quoteTableName(tableName), colName)
+	ss.apply(SchemaChange{
+		Type:  SchemaDropColumn,
+		Table: tableName,
+		Name:  colName,
+		SQL:   query,
+	})
 }
 
 // updateDBForeignKeyConstraints creates or updates fk constraints
 // based on the data of the given Model
-func updateDBForeignKeyConstraints(m *Model) {
-	adapter := adapters[db.DriverName()]
-	for colName, fi := range m.fields.registryByJSON {
-		fkContraintInDB := adapter.constraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
+func (ss *schemaSync) updateDBForeignKeyConstraints(m *Model) {
+	for _, colName := range m.columnNames() {
+		fi := m.fields.registryByJSON[colName]
+		fkContraintInDB := ss.constraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
 		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored()
 		switch {
 		case fieldIsFK && !fkContraintInDB:
-			createFKConstraint(m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
+			ss.createFKConstraint(m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
 		case !fieldIsFK && fkContraintInDB:
-			dropFKConstraint(m.tableName, colName)
+			ss.dropFKConstraint(m.tableName, colName)
 		}
 	}
 }
 
 // updateDBConstraints creates or updates sql constraints
 // based on the data of the given Model
-func updateDBConstraints(m *Model) {
-	adapter := adapters[db.DriverName()]
-	for constraintName, constraint := range m.sqlConstraints {
-		if !adapter.constraintExists(constraintName) {
-			createConstraint(m.tableName, constraintName, constraint.sql)
+func (ss *schemaSync) updateDBConstraints(m *Model) {
+	constraintNames := make([]string, 0, len(m.sqlConstraints))
+	for constraintName := range m.sqlConstraints {
+		constraintNames = append(constraintNames, constraintName)
+	}
+	sort.Strings(constraintNames)
+	for _, constraintName := range constraintNames {
+		if !ss.constraintExists(constraintName) {
+			ss.createConstraint(m.tableName, constraintName, m.sqlConstraints[constraintName].sql, SchemaCreateConstraint)
 		}
 	}
-dbConLoop:
-	for _, dbConstraintName := range adapter.constraints(fmt.Sprintf("%%_%s_mancon", m.tableName)) {
-		for constraintName := range m.sqlConstraints {
-			if constraintName == dbConstraintName {
-				continue dbConLoop
-			}
+	for _, dbConstraintName := range ss.adapter.constraints(fmt.Sprintf("%%_%s_mancon", m.tableName)) {
+		if _, ok := m.sqlConstraints[dbConstraintName]; ok {
+			continue
 		}
-		dropConstraint(m.tableName, dbConstraintName)
+		ss.dropConstraint(m.tableName, dbConstraintName, SchemaDropConstraint)
 	}
 }
 
 // createFKConstraint creates an FK constraint for the given column that references the given targetTable
-func createFKConstraint(tableName, colName, targetTable, ondelete string) {
-	adapter := adapters[db.DriverName()]
-	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
-	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
+func (ss *schemaSync) createFKConstraint(tableName, colName, targetTable, ondelete string) {
+	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, ss.adapter.quoteTableName(targetTable), ondelete)
+	ss.createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint, SchemaCreateForeignKey)
 }
 
 // dropFKConstraint drops an FK constraint for colName in the given table
-func dropFKConstraint(tableName, colName string) {
-	dropConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName))
+func (ss *schemaSync) dropFKConstraint(tableName, colName string) {
+	ss.dropConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), SchemaDropForeignKey)
 }
 
-// createConstraint creates a constraint in the given table
-func createConstraint(tableName, constraintName, sql string) {
-	adapter := adapters[db.DriverName()]
+// createConstraint creates a constraint in the given table.
+// changeType is the type of change to record in the plan.
+func (ss *schemaSync) createConstraint(tableName, constraintName, sql string, changeType SchemaChangeType) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s ADD CONSTRAINT %s %s
-	`, adapter.quoteTableName(tableName), constraintName, sql)
-	dbExecuteNoTx(query)
+	`, ss.adapter.quoteTableName(tableName), constraintName, sql)
+	ss.apply(SchemaChange{
+		Type:  changeType,
+		Table: tableName,
+		Name:  constraintName,
+		To:    sql,
+		SQL:   query,
+	})
 }
 
 // dropConstraint drops a constraint with the given name
-func dropConstraint(tableName, constraintName string) {
-	adapter := adapters[db.DriverName()]
+// changeType is the type of change to record in the plan.
+func (ss *schemaSync) dropConstraint(tableName, constraintName string, changeType SchemaChangeType) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s
-	`, adapter.quoteTableName(tableName), constraintName)
-	dbExecuteNoTx(query)
+	`, ss.adapter.quoteTableName(tableName), constraintName)
+	ss.apply(SchemaChange{
+		Type:  changeType,
+		Table: tableName,
+		Name:  constraintName,
+		SQL:   query,
+	})
 }
 
 // updateDBIndexes creates or updates indexes based on the data of
 // the given Model
-func updateDBIndexes(m *Model) {
-	adapter := adapters[db.DriverName()]
-	for colName, fi := range m.fields.registryByJSON {
-		indexInDB := adapter.indexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
+func (ss *schemaSync) updateDBIndexes(m *Model) {
+	for _, colName := range m.columnNames() {
+		fi := m.fields.registryByJSON[colName]
+		indexInDB := ss.indexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
 		switch {
 		case fi.index && !indexInDB:
-			createColumnIndex(m.tableName, colName)
+			ss.createColumnIndex(m.tableName, colName, ss.adapter.indexMethodSQL(fi))
 		case indexInDB && !fi.index:
-			dropColumnIndex(m.tableName, colName)
+			ss.dropColumnIndex(m.tableName, colName)
+		}
+	}
+}
+
+// updateDBModelIndexes creates, updates or drops the indexes declared
+// on the given Model with AddIndex.
+//
+// The definition of each index is stored as a comment on the index in
+// the database, so that indexes whose definition changed are recreated.
+func (ss *schemaSync) updateDBModelIndexes(m *Model) {
+	dbIndexes := ss.adapter.modelIndexes(ss.dbTableName(m.tableName))
+	for newName, oldName := range ss.renamed {
+		if def, ok := dbIndexes[oldName]; ok {
+			delete(dbIndexes, oldName)
+			dbIndexes[newName] = def
+		}
+	}
+	indexNames := make([]string, 0, len(m.sqlIndexes))
+	for indexName := range m.sqlIndexes {
+		indexNames = append(indexNames, indexName)
+	}
+	sort.Strings(indexNames)
+	for _, indexName := range indexNames {
+		def := m.sqlIndexDefinition(m.sqlIndexes[indexName])
+		dbDef, exists := dbIndexes[indexName]
+		switch {
+		case !exists:
+			ss.createModelIndex(m.tableName, indexName, def)
+		case dbDef != def:
+			ss.dropModelIndex(m.tableName, indexName, dbDef)
+			ss.createModelIndex(m.tableName, indexName, def)
+		}
+	}
+	dbIndexNames := make([]string, 0, len(dbIndexes))
+	for indexName := range dbIndexes {
+		dbIndexNames = append(dbIndexNames, indexName)
+	}
+	sort.Strings(dbIndexNames)
+	for _, indexName := range dbIndexNames {
+		if _, ok := m.sqlIndexes[indexName]; !ok {
+			ss.dropModelIndex(m.tableName, indexName, dbIndexes[indexName])
+		}
+	}
+}
+
+// createModelIndex creates the index indexName on the given table with the
+// given definition and stores the definition as a comment on the index.
+func (ss *schemaSync) createModelIndex(tableName, indexName, def string) {
+	var unique string
+	if strings.HasPrefix(def, "UNIQUE ") {
+		unique = "UNIQUE "
+	}
+	query := fmt.Sprintf(`
+		CREATE %sINDEX %s ON %s %s
+	`, unique, indexName, ss.adapter.quoteTableName(tableName), strings.TrimPrefix(def, "UNIQUE "))
+	ss.apply(SchemaChange{
+		Type:  SchemaCreateIndex,
+		Table: tableName,
+		Name:  indexName,
+		To:    def,
+		SQL:   query,
+	})
+	ss.apply(SchemaChange{
+		Type:  SchemaSetComment,
+		Table: tableName,
+		Name:  indexName,
+		SQL:   fmt.Sprintf(`COMMENT ON INDEX %s IS %s`, indexName, ss.adapter.literalSQL(def)),
+	})
+}
+
+// dropModelIndex drops the index indexName of the given table.
+// def is the current definition of the index in the database.
+func (ss *schemaSync) dropModelIndex(tableName, indexName, def string) {
+	ss.apply(SchemaChange{
+		Type:  SchemaDropIndex,
+		Table: tableName,
+		Name:  indexName,
+		From:  def,
+		SQL:   fmt.Sprintf(`DROP INDEX IF EXISTS %s`, indexName),
+	})
+}
+
+// updateDBViews creates the views of view models in dependency order.
+// A view is replaced if its query differs from the one stored in its
+// comment or if a view it depends on has been replaced. Views that have
+// been dropped during this sync are created again.
+//
+// The views that do not belong to a view model are then dropped, or
+// kept and reported in safe mode.
+func (ss *schemaSync) updateDBViews() {
+	dbViews := ss.adapter.views()
+	replaced := make(map[string]bool)
+	for _, model := range viewModels() {
+		viewSQL := model.viewSQL()
+		dbView, exists := dbViews[model.tableName]
+		exists = exists && !ss.droppedViews[model.tableName]
+		mustCreate := !exists || dbView.Comment != viewSQL || dbView.Materialized != model.view.Materialized
+		for _, dep := range model.view.DependsOn {
+			if replaced[dep] {
+				mustCreate = true
+			}
+		}
+		if !mustCreate {
+			continue
+		}
+		if exists {
+			ss.dropDBView(model.tableName, dbView.Materialized)
 		}
+		ss.createDBView(model, viewSQL)
+		replaced[model.name] = true
+	}
+	viewNames := make([]string, 0, len(dbViews))
+	for viewName := range dbViews {
+		viewNames = append(viewNames, viewName)
+	}
+	sort.Strings(viewNames)
+	for _, viewName := range viewNames {
+		if model, ok := Registry.registryByTableName[viewName]; ok && model.IsView() {
+			continue
+		}
+		if systemTables[viewName] || ss.droppedViews[viewName] {
+			continue
+		}
+		if ss.keepsData() {
+			ss.apply(SchemaChange{
+				Type:  SchemaKeepView,
+				Table: viewName,
+			})
+			continue
+		}
+		ss.dropDBView(viewName, dbViews[viewName].Materialized)
+	}
+}
+
+// createDBView creates the view of the given model with the given query
+// and stores the query as a comment on the view. Materialized views get
+// a unique index on id so that they can be refreshed concurrently.
+func (ss *schemaSync) createDBView(m *Model, viewSQL string) {
+	viewType := "VIEW"
+	if m.view.Materialized {
+		viewType = "MATERIALIZED VIEW"
+	}
+	tableName := ss.adapter.quoteTableName(m.tableName)
+	ss.apply(SchemaChange{
+		Type:  SchemaCreateView,
+		Table: m.tableName,
+		To:    strings.ToLower(viewType),
+		SQL:   fmt.Sprintf(`CREATE %s %s AS %s`, viewType, tableName, viewSQL),
+	})
+	ss.apply(SchemaChange{
+		Type:  SchemaSetComment,
+		Table: m.tableName,
+		SQL:   fmt.Sprintf(`COMMENT ON %s %s IS %s`, viewType, tableName, ss.adapter.literalSQL(viewSQL)),
+	})
+	if m.view.Materialized {
+		indexName := fmt.Sprintf("%s_id_viewidx", m.tableName)
+		ss.apply(SchemaChange{
+			Type:  SchemaCreateIndex,
+			Table: m.tableName,
+			Name:  indexName,
+			To:    "(id)",
+			SQL:   fmt.Sprintf(`CREATE UNIQUE INDEX %s ON %s (id)`, indexName, tableName),
+		})
 	}
 }
 
-// createColumnIndex creates an column index for colName in the given table
-func createColumnIndex(tableName, colName string) {
-	adapter := adapters[db.DriverName()]
+// dropDBView drops the given view, and the views that depend on it.
+//
+// The dropped views are recorded so that the views of view models
+// are created again by updateDBViews.
+func (ss *schemaSync) dropDBView(tableName string, materialized bool) {
+	if ss.droppedViews[tableName] {
+		return
+	}
+	dependents := ss.adapter.dependentViews(tableName)
+	viewType := "VIEW"
+	if materialized {
+		viewType = "MATERIALIZED VIEW"
+	}
+	ss.apply(SchemaChange{
+		Type:  SchemaDropView,
+		Table: tableName,
+		From:  strings.ToLower(viewType),
+		SQL:   fmt.Sprintf(`DROP %s IF EXISTS %s CASCADE`, viewType, ss.adapter.quoteTableName(tableName)),
+	})
+	ss.droppedViews[tableName] = true
+	for _, view := range dependents {
+		if model, ok := Registry.registryByTableName[view.Name]; !ok || !model.IsView() {
+			log.Warn("View dropped with the view it depends on", "view", view.Name, "dependency", tableName)
+		}
+		ss.droppedViews[view.Name] = true
+	}
+}
+
+// dropDependentViews drops the views that depend on the given table, so
+// that its columns can be altered or dropped. The views of view models are
+// created again by updateDBViews.
+func (ss *schemaSync) dropDependentViews(tableName string) {
+	for _, view := range ss.adapter.dependentViews(ss.dbTableName(tableName)) {
+		ss.dropDBView(view.Name, view.Materialized)
+	}
+}
+
+// createColumnIndex creates an column index for colName in the given table.
+// method is the SQL string of the index method, or an empty string for the default.
+func (ss *schemaSync) createColumnIndex(tableName, colName, method string) {
+	indexName := fmt.Sprintf("%s_%s_index", tableName, colName)
 	query := fmt.Sprintf(`
-		CREATE INDEX %s ON %s (%s)
-	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+		CREATE INDEX %s ON %s %s (%s)
+	`, indexName, ss.adapter.quoteTableName(tableName), method, colName)
+	ss.apply(SchemaChange{
+		Type:  SchemaCreateIndex,
+		Table: tableName,
+		Name:  indexName,
+		To:    strings.TrimSpace(fmt.Sprintf("%s (%s)", method, colName)),
+		SQL:   query,
+	})
 }
 
 // dropColumnIndex drops a column index for colName in the given table
-func dropColumnIndex(tableName, colName string) {
+func (ss *schemaSync) dropColumnIndex(tableName, colName string) {
+	indexName := fmt.Sprintf("%s_%s_index", tableName, colName)
 	query := fmt.Sprintf(`
 		DROP INDEX IF EXISTS %s
-	`, fmt.Sprintf("%s_%s_index", tableName, colName))
-	dbExecuteNoTx(query)
+	`, indexName)
+	ss.apply(SchemaChange{
+		Type:  SchemaDropIndex,
+		Table: tableName,
+		Name:  indexName,
+		SQL:   query,
+	})
 }
 
 // runInit runs the Init function of the given model if it exists
@@ -351,3 +1034,92 @@ func runInit(model *Model) {
 		}
 	}
 }
+
+// columnNames returns the sorted list of the column names of this model
+func (m *Model) columnNames() []string {
+	res := make([]string, 0, len(m.fields.registryByJSON))
+	for colName := range m.fields.registryByJSON {
+		res = append(res, colName)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// storedColumnNames returns the sorted list of the column names of
+// this model's stored fields, without the id column.
+func (m *Model) storedColumnNames() []string {
+	var res []string
+	for _, colName := range m.columnNames() {
+		if colName == "id" || !m.fields.registryByJSON[colName].isStored() {
+			continue
+		}
+		res = append(res, colName)
+	}
+	return res
+}
+
+// oldTableNames returns the names of the tables of this model's old names
+func (m *Model) oldTableNames() []string {
+	res := make([]string, len(m.oldNames))
+	for i, oldName := range m.oldNames {
+		res[i] = oldName
+		if !isColumnName(oldName) {
+			res[i] = strutils.SnakeCase(oldName)
+		}
+	}
+	return res
+}
+
+// oldColumnNames returns the column names of this field's old names
+func (f *Field) oldColumnNames() []string {
+	res := make([]string, len(f.oldNames))
+	for i, oldName := range f.oldNames {
+		res[i] = oldName
+		if !isColumnName(oldName) {
+			res[i] = SnakeCaseFieldName(oldName, f.fieldType)
+		}
+	}
+	return res
+}
+
+// isColumnName returns true if the given old name is already a
+// table or column name, i.e. it does not start with an upper case letter.
+func isColumnName(name string) bool {
+	return name != "" && !unicode.IsUpper([]rune(name)[0])
+}
+
+// sqlIndexDefinition returns the SQL definition of the given index of this
+// model, e.g. "UNIQUE USING gin (name, (lower(email))) WHERE active".
+//
+// Field names of the index are replaced by their column name, with an optional
+// order such as "Name DESC". Other expressions are used as is.
+func (m *Model) sqlIndexDefinition(idx sqlIndex) string {
+	exprs := make([]string, len(idx.exprs))
+	for i, expr := range idx.exprs {
+		toks := strings.SplitN(strings.TrimSpace(expr), " ", 2)
+		fi, ok := m.fields.Get(toks[0])
+		if !ok {
+			exprs[i] = fmt.Sprintf("(%s)", expr)
+			continue
+		}
+		if !fi.isStored() {
+			log.Panic("Indexed fields must be stored", "model", m.name, "index", idx.name, "field", fi.name)
+		}
+		exprs[i] = fi.json
+		if len(toks) > 1 {
+			exprs[i] += " " + strings.ToUpper(strings.TrimSpace(toks[1]))
+		}
+	}
+	var res string
+	if idx.unique {
+		res = "UNIQUE "
+	}
+	if idx.method != "" {
+		res += fmt.Sprintf("USING %s ", idx.method)
+	}
+	res += fmt.Sprintf("(%s)", strings.Join(exprs, ", "))
+	if idx.where != "" {
+		res += fmt.Sprintf(" WHERE %s", idx.where)
+	}
+	return res
+}
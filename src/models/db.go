@@ -15,7 +15,9 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"io"
 	"time"
 
 	"github.com/gleke/hexya/src/models/operator"
@@ -41,6 +43,28 @@ type ConnectionParams struct {
 	SSLCert  string
 	SSLKey   string
 	SSLCA    string
+	// Replicas are the connection parameters of the read replicas of
+	// the database. Empty parameters default to those of the primary.
+	Replicas []ConnectionParams
+	// MaxReplicaLag is the maximum replication lag of a replica for
+	// it to be used. It defaults to defaultMaxReplicaLag.
+	MaxReplicaLag time.Duration
+	// MaxOpenConns is the maximum number of open connections to the
+	// database. There is no limit if it is 0.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections to the
+	// database. The database/sql default is used if it is 0 and no
+	// idle connection is kept if it is negative.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum time a connection may be reused.
+	// Connections are reused forever if it is 0.
+	ConnMaxLifetime time.Duration
+	// StatementTimeout is the default maximum duration of a query.
+	// The database server setting is used if it is 0.
+	StatementTimeout time.Duration
+	// LockTimeout is the maximum duration of a wait for a lock.
+	// The database server setting is used if it is 0.
+	LockTimeout time.Duration
 }
 
 // ConnectionString returns the connection string for these connection params
@@ -57,6 +81,13 @@ type ColumnData struct {
 	ColumnDefault sql.NullString
 }
 
+// A viewData holds information from the db schema about one view
+type viewData struct {
+	Name         string `db:"name"`
+	Materialized bool   `db:"materialized"`
+	Comment      string `db:"comment"`
+}
+
 // A seqData holds the data of a sequence in the database
 type seqData struct {
 	Name       string `db:"sequence_name"`
@@ -69,6 +100,14 @@ type dbAdapter interface {
 	connectionString(ConnectionParams) string
 	// operatorSQL returns the sql string and placeholders for the given DomainOperator
 	operatorSQL(operator.Operator, interface{}) (string, interface{})
+	// arrayOperatorSQL returns the sql string and placeholders for the given DomainOperator
+	// when applied to an array column. arg must be a slice of the array's element type.
+	arrayOperatorSQL(operator.Operator, interface{}) (string, interface{})
+	// arrayArg returns the given slice as a value suitable for an array column query argument
+	arrayArg(interface{}) interface{}
+	// scanArray scans the given array column value returned by the database into dest,
+	// which must be a pointer to a slice
+	scanArray(src interface{}, dest interface{}) error
 	// typeSQL returns the SQL type string, including columns constraints if any
 	typeSQL(fi *Field) string
 	// columnSQLDefinition returns the SQL type string, including columns constraints if any
@@ -86,6 +125,21 @@ type dbAdapter interface {
 	quoteTableName(string) string
 	// indexExists returns true if an index with the given name exists in the given table
 	indexExists(table string, name string) bool
+	// modelIndexes returns the indexes declared with AddIndex on the given table
+	// as a map of their name and the definition stored in their comment
+	modelIndexes(table string) map[string]string
+	// views returns the views and materialized views of the database
+	views() map[string]viewData
+	// dependentViews returns the views and materialized views that depend,
+	// directly or through other views, on the given table or view
+	dependentViews(name string) []viewData
+	// indexMethodSQL returns the SQL string of the index method to use for the given
+	// field's column index, or an empty string for the default method
+	indexMethodSQL(fi *Field) string
+	// dateTruncSQL returns the SQL expression of the given date or datetime column
+	// truncated to the given date part. If tz is not empty, the column is a UTC datetime
+	// that is truncated in the tz timezone and the result is given back in UTC.
+	dateTruncSQL(column, part, tz string) string
 	// constraintExists returns true if a constraint with the given name exists
 	constraintExists(name string) bool
 	// constraints returns a list of all constraints matching the given SQL pattern
@@ -93,16 +147,54 @@ type dbAdapter interface {
 	// setTransactionIsolation returns the SQL string to set the transaction isolation
 	// level to serializable
 	setTransactionIsolation() string
+	// createDatabaseSQL returns the SQL query to create the database with the
+	// given name as a copy of the given template database, or of the default
+	// template if empty.
+	createDatabaseSQL(name, template string) string
+	// dropDatabaseSQL returns the SQL query to drop the database with the given name
+	dropDatabaseSQL(name string) string
+	// setStatementTimeout returns the SQL string to set the statement timeout of
+	// the current transaction. A zero duration disables the timeout.
+	setStatementTimeout(timeout time.Duration) string
+	// setLockTimeout returns the SQL string to set the lock timeout of the current
+	// transaction. A zero duration disables the timeout.
+	setLockTimeout(timeout time.Duration) string
+	// setReadOnlyTransaction returns the SQL string to set a read only transaction
+	// with an isolation level that is available on replicas
+	setReadOnlyTransaction() string
+	// replicaLag returns the replication lag of the given replica
+	replicaLag(replica *sqlx.DB) (time.Duration, error)
+	// notifySQL returns the SQL query to send a notification to the listeners of a
+	// channel. The query has a placeholder for the channel and one for the payload.
+	notifySQL() string
+	// listen starts listening to the notifications of the given channel on the
+	// database given by params. handler is called with the payload of each
+	// notification, and with an empty payload when notifications may have been
+	// lost. Listening stops when the returned io.Closer is closed.
+	listen(params ConnectionParams, channel string, handler func(payload string)) (io.Closer, error)
 	// createSequence creates a DB sequence with the given name
 	createSequence(name string, increment, start int64)
+	// createSequenceSQL returns the SQL query to create a DB sequence with the given name
+	createSequenceSQL(name string, increment, start int64) string
 	// dropSequence drop the DB sequence with the given name
 	dropSequence(name string)
+	// dropSequenceSQL returns the SQL query to drop the DB sequence with the given name
+	dropSequenceSQL(name string) string
 	// alterSequence modifies the DB sequence given by name
 	alterSequence(name string, increment, restart int64)
+	// alterSequenceSQL returns the SQL query to modify the DB sequence given by name
+	alterSequenceSQL(name string, increment, restart int64) string
+	// literalSQL returns the given query argument as an SQL literal
+	literalSQL(arg interface{}) string
+	// isSafeTypeChange returns true if a column of type from can be
+	// converted to type to without losing data
+	isSafeTypeChange(from, to string) bool
 	// nextSequenceValue returns the next value of the given given sequence
 	nextSequenceValue(name string) int64
 	// sequences returns a list of all sequences matching the given SQL pattern
 	sequences(pattern string) []seqData
+	// sequenceExists returns true if a sequence with the given name exists
+	sequenceExists(name string) bool
 	// childrenIdsQuery returns a query that finds all descendant of the given
 	// a record from table including itself. The query has a placeholder for the
 	// record's ID
@@ -120,37 +212,169 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 	adapters[name] = adapter
 }
 
-// Cursor is a wrapper around a database transaction
+// Cursor is a wrapper around a database transaction.
+//
+// The transaction is started at the first query. The queries of a read
+// only Cursor are run on a read replica until its first write query,
+// after which the Cursor runs on the primary database.
 type Cursor struct {
-	tx *sqlx.Tx
+	ctx              context.Context
+	conn             *sqlx.DB
+	tx               *sqlx.Tx
+	readOnly         bool
+	onReplica        bool
+	written          bool
+	statementTimeout time.Duration
+	lockTimeout      time.Duration
+	// queries is the number of queries executed by this Cursor
+	queries int64
 }
 
 // Execute a query without returning any rows. It panics in case of error.
 // The args are for any placeholder parameters in the query.
 func (c *Cursor) Execute(query string, args ...interface{}) sql.Result {
-	return dbExecute(c.tx, query, args...)
+	return dbExecute(c.ctx, c.transaction(true), query, args...)
 }
 
 // Get queries a row into the database and maps the result into dest.
 // The query must return only one row. Get panics on errors
 func (c *Cursor) Get(dest interface{}, query string, args ...interface{}) {
-	dbGet(c.tx, dest, query, args...)
+	dbGet(c.ctx, c.transaction(!isReadQuery(query)), dest, query, args...)
 }
 
 // Select queries multiple rows and map the result into dest which must be a slice.
 // Select panics on errors.
 func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
-	dbSelect(c.tx, dest, query, args...)
+	dbSelect(c.ctx, c.transaction(!isReadQuery(query)), dest, query, args...)
+}
+
+// query returns the rows found by the given query.
+// It panics in case of error.
+func (c *Cursor) query(query string, args ...interface{}) *sqlx.Rows {
+	return dbQuery(c.ctx, c.transaction(!isReadQuery(query)), query, args...)
+}
+
+// transaction returns the transaction in which to run a query, starting
+// it if needed. write must be true if the query modifies the database.
+func (c *Cursor) transaction(write bool) *sqlx.Tx {
+	c.queries++
+	if write {
+		c.written = true
+		if c.onReplica {
+			// Move to the primary database
+			c.tx.Rollback()
+			c.tx = nil
+			c.onReplica = false
+		}
+		c.readOnly = false
+	}
+	if c.tx != nil {
+		return c.tx
+	}
+	if c.readOnly && c.conn == db {
+		if replica := pickReplica(); replica != nil {
+			c.tx = c.begin(replica, true)
+			c.onReplica = true
+			return c.tx
+		}
+	}
+	c.tx = c.begin(c.conn, false)
+	return c.tx
+}
+
+// setReadOnly marks this Cursor as read only. It has no effect
+// if a write query has already been run with this Cursor.
+func (c *Cursor) setReadOnly() {
+	if c.written {
+		return
+	}
+	if c.tx != nil && !c.onReplica {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	c.readOnly = true
+}
+
+// commit the transaction of this Cursor, if it has been started
+func (c *Cursor) commit() error {
+	if c.tx == nil {
+		return nil
+	}
+	return c.tx.Commit()
+}
+
+// rollback the transaction of this Cursor, if it has been started
+func (c *Cursor) rollback() {
+	if c.tx == nil {
+		return
+	}
+	c.tx.Rollback()
 }
 
-// newCursor returns a new db cursor on the given database
-func newCursor(db *sqlx.DB) *Cursor {
-	adapter := adapters[db.DriverName()]
-	tx := db.MustBegin()
-	dbExecute(tx, adapter.setTransactionIsolation())
-	return &Cursor{
-		tx: tx,
+// setStatementTimeout sets the statement timeout of the transaction
+// of this Cursor. A zero duration disables the timeout.
+func (c *Cursor) setStatementTimeout(timeout time.Duration) {
+	c.statementTimeout = timeout
+	if c.tx == nil {
+		return
 	}
+	adapter := adapters[c.conn.DriverName()]
+	dbExecute(c.ctx, c.tx, adapter.setStatementTimeout(timeout))
+}
+
+// newCursor returns a new db cursor on the given database with the
+// timeouts of the connection parameters. The queries of the cursor
+// are canceled when the given context is done.
+func newCursor(ctx context.Context, conn *sqlx.DB) *Cursor {
+	cr := Cursor{
+		ctx:              ctx,
+		conn:             conn,
+		statementTimeout: connParams.StatementTimeout,
+		lockTimeout:      connParams.LockTimeout,
+	}
+	if cr.statementTimeout == 0 {
+		cr.statementTimeout = noTimeout
+	}
+	if cr.lockTimeout == 0 {
+		cr.lockTimeout = noTimeout
+	}
+	return &cr
+}
+
+// noTimeout is the timeout value of a Cursor that
+// uses the timeout setting of the database server.
+const noTimeout time.Duration = -1
+
+// begin starts a new transaction on the given database and sets its
+// timeouts. If replica is true, the transaction is a read only transaction.
+func (c *Cursor) begin(conn *sqlx.DB, replica bool) *sqlx.Tx {
+	adapter := adapters[conn.DriverName()]
+	tx, err := conn.BeginTxx(c.ctx, nil)
+	if err != nil {
+		log.Panic("Unable to begin transaction", "error", err)
+	}
+	if replica {
+		dbExecute(c.ctx, tx, adapter.setReadOnlyTransaction())
+	} else {
+		dbExecute(c.ctx, tx, adapter.setTransactionIsolation())
+	}
+	if c.statementTimeout != noTimeout {
+		dbExecute(c.ctx, tx, adapter.setStatementTimeout(c.statementTimeout))
+	}
+	if c.lockTimeout != noTimeout {
+		dbExecute(c.ctx, tx, adapter.setLockTimeout(c.lockTimeout))
+	}
+	return tx
+}
+
+// configurePool sets the connection pool limits of the
+// given connection from the given connection parameters.
+func configurePool(conn *sqlx.DB, params ConnectionParams) {
+	conn.SetMaxOpenConns(params.MaxOpenConns)
+	if params.MaxIdleConns != 0 {
+		conn.SetMaxIdleConns(params.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(params.ConnMaxLifetime)
 }
 
 // DBParams returns the DB connection parameters currently in use
@@ -163,22 +387,28 @@ func DBConnect(params ConnectionParams) {
 	connParams = params
 	connStr := DBParams().ConnectionString()
 	db = sqlx.MustConnect(params.Driver, connStr)
+	configurePool(db, params)
 	log.Info("Connected to database", "driver", params.Driver, "connStr", connStr)
+	connectReplicas(params)
 }
 
 // DBClose is a wrapper around sqlx.Close
 // It closes the connection to the database
 func DBClose() {
+	stopSharedCacheListener()
+	stopGroupsListener()
+	closeReplicas()
+	closeTenants()
 	err := db.Close()
 	log.Info("Closed database", "error", err)
 }
 
-// dbExecute is a wrapper around sqlx.MustExec
+// dbExecute is a wrapper around sqlx.ExecContext
 // It executes a query that returns no row
-func dbExecute(cr *sqlx.Tx, query string, args ...interface{}) sql.Result {
+func dbExecute(ctx context.Context, cr *sqlx.Tx, query string, args ...interface{}) sql.Result {
 	query, args = sanitizeQuery(query, args...)
 	t := time.Now()
-	res, err := cr.Exec(query, args...)
+	res, err := cr.ExecContext(ctx, query, args...)
 	logSQLResult(err, t, query, args...)
 	return res
 }
@@ -192,13 +422,23 @@ func dbExecuteNoTx(query string, args ...interface{}) sql.Result {
 	return res
 }
 
-// dbGet is a wrapper around sqlx.Get
+// dbExecuteRawNoTx executes the given query in the database without any transaction
+// and without expanding or rebinding placeholders, so that '?' characters in
+// literals or operators of DDL statements are kept as is.
+func dbExecuteRawNoTx(query string) sql.Result {
+	t := time.Now()
+	res, err := db.Exec(query)
+	logSQLResult(err, t, query)
+	return res
+}
+
+// dbGet is a wrapper around sqlx.GetContext
 // It gets the value of a single row found by the given query and arguments
 // It panics in case of error
-func dbGet(cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
+func dbGet(ctx context.Context, cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
 	t := time.Now()
-	err := cr.Get(dest, query, args...)
+	err := cr.GetContext(ctx, dest, query, args...)
 	logSQLResult(err, t, query, args)
 }
 
@@ -212,13 +452,13 @@ func dbGetNoTx(dest interface{}, query string, args ...interface{}) {
 	logSQLResult(err, t, query, args)
 }
 
-// dbSelect is a wrapper around sqlx.Select
+// dbSelect is a wrapper around sqlx.SelectContext
 // It gets the value of a multiple rows found by the given query and arguments
 // dest must be a slice. It panics in case of error
-func dbSelect(cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
+func dbSelect(ctx context.Context, cr *sqlx.Tx, dest interface{}, query string, args ...interface{}) {
 	query, args = sanitizeQuery(query, args...)
 	t := time.Now()
-	err := cr.Select(dest, query, args...)
+	err := cr.SelectContext(ctx, dest, query, args...)
 	logSQLResult(err, t, query, args)
 }
 
@@ -232,13 +472,13 @@ func dbSelectNoTx(dest interface{}, query string, args ...interface{}) {
 	logSQLResult(err, t, query, args)
 }
 
-// dbQuery is a wrapper around sqlx.Queryx
+// dbQuery is a wrapper around sqlx.QueryxContext
 // It returns a sqlx.Rowsx found by the given query and arguments
 // It panics in case of error
-func dbQuery(cr *sqlx.Tx, query string, args ...interface{}) *sqlx.Rows {
+func dbQuery(ctx context.Context, cr *sqlx.Tx, query string, args ...interface{}) *sqlx.Rows {
 	query, args = sanitizeQuery(query, args...)
 	t := time.Now()
-	rows, err := cr.Queryx(query, args...)
+	rows, err := cr.QueryxContext(ctx, query, args...)
 	logSQLResult(err, t, query, args)
 	return rows
 }
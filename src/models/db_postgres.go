@@ -15,11 +15,15 @@
 package models
 
 import (
+	"database/sql/driver"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/gleke/hexya/src/models/fieldtype"
 	"github.com/gleke/hexya/src/models/operator"
 	"github.com/gleke/hexya/src/tools/nbutils"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
@@ -42,19 +46,42 @@ var pgOperators = map[operator.Operator]string{
 	operator.GreaterOrEqual: ">= ?",
 }
 
+var pgArrayOperators = map[operator.Operator]string{
+	operator.Equals:      "= ?",
+	operator.NotEquals:   "!= ?",
+	operator.Contains:    "@> ?",
+	operator.NotContains: "@> ? IS NOT TRUE",
+	operator.Overlaps:    "&& ?",
+	operator.In:          "<@ ?",
+	operator.NotIn:       "<@ ? IS NOT TRUE",
+}
+
 var pgTypes = map[fieldtype.Type]string{
-	fieldtype.Boolean:   "boolean",
-	fieldtype.Char:      "character varying",
-	fieldtype.Text:      "text",
-	fieldtype.Date:      "date",
-	fieldtype.DateTime:  "timestamp without time zone",
-	fieldtype.Integer:   "integer",
-	fieldtype.Float:     "numeric",
-	fieldtype.HTML:      "text",
-	fieldtype.Binary:    "bytea",
-	fieldtype.Selection: "character varying",
-	fieldtype.Many2One:  "integer",
-	fieldtype.One2One:   "integer",
+	fieldtype.Boolean:      "boolean",
+	fieldtype.Char:         "character varying",
+	fieldtype.Text:         "text",
+	fieldtype.Date:         "date",
+	fieldtype.DateTime:     "timestamp without time zone",
+	fieldtype.Duration:     "interval",
+	fieldtype.Time:         "time without time zone",
+	fieldtype.Integer:      "integer",
+	fieldtype.Float:        "numeric",
+	fieldtype.HTML:         "text",
+	fieldtype.Binary:       "bytea",
+	fieldtype.Selection:    "character varying",
+	fieldtype.Many2One:     "integer",
+	fieldtype.One2One:      "integer",
+	fieldtype.IntegerArray: "integer[]",
+	fieldtype.CharArray:    "character varying[]",
+}
+
+// pgSafeTypeChanges lists the column type conversions that do not lose data
+var pgSafeTypeChanges = map[string][]string{
+	"character varying": {"text"},
+	"text":              {"character varying"},
+	"integer":           {"bigint", "numeric"},
+	"bigint":            {"numeric"},
+	"date":              {"timestamp without time zone"},
 }
 
 // connectionString returns the connection string for the given parameters
@@ -98,8 +125,33 @@ func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (st
 	return op, arg
 }
 
+// arrayOperatorSQL returns the sql string and placeholders for the given DomainOperator
+// when applied to an array column. arg must be a slice of the array's element type.
+func (d *postgresAdapter) arrayOperatorSQL(do operator.Operator, arg interface{}) (string, interface{}) {
+	op, ok := pgArrayOperators[do]
+	if !ok {
+		log.Panic("Operator not supported on array fields", "operator", do)
+	}
+	return op, d.arrayArg(arg)
+}
+
+// arrayArg returns the given slice as a value suitable for an array column query argument
+func (d *postgresAdapter) arrayArg(arg interface{}) interface{} {
+	return pq.Array(arg)
+}
+
+// scanArray scans the given array column value returned by the database into dest,
+// which must be a pointer to a slice
+func (d *postgresAdapter) scanArray(src interface{}, dest interface{}) error {
+	return pq.Array(dest).Scan(src)
+}
+
 // typeSQL returns the sql type string for the given Field
 func (d *postgresAdapter) typeSQL(fi *Field) string {
+	if fi.encrypted {
+		// Encrypted values are stored base64 encoded and are longer than clear values
+		return "text"
+	}
 	typ, _ := pgTypes[fi.fieldType]
 	return typ
 }
@@ -109,14 +161,14 @@ func (d *postgresAdapter) typeSQL(fi *Field) string {
 // If null is true, then the column will be nullable, whatever the field defines
 func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
 	var res string
-	typ, ok := pgTypes[fi.fieldType]
-	res = typ
+	_, ok := pgTypes[fi.fieldType]
+	res = d.typeSQL(fi)
 	if !ok {
 		log.Panic("Unknown column type", "type", fi.fieldType, "model", fi.model.name, "field", fi.name)
 	}
 	switch fi.fieldType {
 	case fieldtype.Char:
-		if fi.size > 0 {
+		if fi.size > 0 && !fi.encrypted {
 			res = fmt.Sprintf("%s(%d)", res, fi.size)
 		}
 	case fieldtype.Float:
@@ -166,7 +218,8 @@ func (d *postgresAdapter) quoteTableName(tableName string) string {
 // columns returns a list of ColumnData for the given tableName
 func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
 	query := fmt.Sprintf(`
-		SELECT column_name, data_type, is_nullable, column_default
+		SELECT column_name, CASE WHEN data_type = 'ARRAY' THEN udt_name::regtype::text ELSE data_type END AS data_type,
+			is_nullable, column_default
 		FROM information_schema.columns
 		WHERE table_schema NOT IN ('pg_catalog', 'information_schema') AND table_name = '%s'
 	`, tableName)
@@ -189,6 +242,91 @@ func (d *postgresAdapter) indexExists(table string, name string) bool {
 	return cnt > 0
 }
 
+// modelIndexes returns the indexes declared with AddIndex on the given table
+// as a map of their name and the definition stored in their comment
+func (d *postgresAdapter) modelIndexes(table string) map[string]string {
+	query := `
+		SELECT i.relname AS name, COALESCE(obj_description(i.oid, 'pg_class'), '') AS comment
+		FROM pg_index x
+			JOIN pg_class i ON i.oid = x.indexrelid
+			JOIN pg_class t ON t.oid = x.indrelid
+		WHERE t.relname = ? AND i.relname LIKE '%\_manidx'
+	`
+	var indexes []struct {
+		Name    string `db:"name"`
+		Comment string `db:"comment"`
+	}
+	dbSelectNoTx(&indexes, query, table)
+	res := make(map[string]string, len(indexes))
+	for _, idx := range indexes {
+		res[idx.Name] = idx.Comment
+	}
+	return res
+}
+
+// views returns the views and materialized views of the database
+func (d *postgresAdapter) views() map[string]viewData {
+	query := `
+		SELECT c.relname AS name, c.relkind = 'm' AS materialized,
+			COALESCE(obj_description(c.oid, 'pg_class'), '') AS comment
+		FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm') AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	`
+	var views []viewData
+	dbSelectNoTx(&views, query)
+	res := make(map[string]viewData, len(views))
+	for _, view := range views {
+		res[view.Name] = view
+	}
+	return res
+}
+
+// dependentViews returns the views and materialized views that depend,
+// directly or through other views, on the given table or view
+func (d *postgresAdapter) dependentViews(name string) []viewData {
+	query := `
+		WITH RECURSIVE deps AS (
+			SELECT v.oid, v.relname, v.relkind
+			FROM pg_depend d
+				JOIN pg_rewrite r ON r.oid = d.objid
+				JOIN pg_class v ON v.oid = r.ev_class
+			WHERE d.refobjid = to_regclass(?) AND v.oid <> d.refobjid
+			UNION
+			SELECT v.oid, v.relname, v.relkind
+			FROM deps
+				JOIN pg_depend d ON d.refobjid = deps.oid
+				JOIN pg_rewrite r ON r.oid = d.objid
+				JOIN pg_class v ON v.oid = r.ev_class
+			WHERE v.oid <> deps.oid
+		)
+		SELECT relname AS name, relkind = 'm' AS materialized FROM deps ORDER BY relname
+	`
+	var views []viewData
+	dbSelectNoTx(&views, query, d.quoteTableName(name))
+	return views
+}
+
+// indexMethodSQL returns the SQL string of the index method to use for the given
+// field's column index, or an empty string for the default method
+func (d *postgresAdapter) indexMethodSQL(fi *Field) string {
+	if fi.fieldType.IsArrayType() {
+		return "USING GIN"
+	}
+	return ""
+}
+
+// dateTruncSQL returns the SQL expression of the given date or datetime column
+// truncated to the given date part. If tz is not empty, the column is a UTC datetime
+// that is truncated in the tz timezone and the result is given back in UTC.
+func (d *postgresAdapter) dateTruncSQL(column, part, tz string) string {
+	if tz == "" {
+		return fmt.Sprintf("date_trunc('%s', %s)::date", part, column)
+	}
+	return fmt.Sprintf("(date_trunc('%s', %s AT TIME ZONE 'UTC' AT TIME ZONE '%s') AT TIME ZONE '%s') AT TIME ZONE 'UTC'",
+		part, column, tz, tz)
+}
+
 // constraintExists returns true if a constraint with the given name exists in the given table
 func (d *postgresAdapter) constraintExists(name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_constraint WHERE conname = '%s'", name)
@@ -207,18 +345,31 @@ func (d *postgresAdapter) constraints(pattern string) []string {
 
 // createSequence creates a DB sequence with the given name
 func (d *postgresAdapter) createSequence(name string, increment, start int64) {
-	query := fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.createSequenceSQL(name, increment, start))
+}
+
+// createSequenceSQL returns the SQL query to create a DB sequence with the given name
+func (d *postgresAdapter) createSequenceSQL(name string, increment, start int64) string {
+	return fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
 }
 
 // dropSequence drops the DB sequence with the given name
 func (d *postgresAdapter) dropSequence(name string) {
-	query := fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.dropSequenceSQL(name))
+}
+
+// dropSequenceSQL returns the SQL query to drop the DB sequence with the given name
+func (d *postgresAdapter) dropSequenceSQL(name string) string {
+	return fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
 }
 
 // alterSequence modifies the DB sequence given by name
 func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
+	dbExecuteNoTx(d.alterSequenceSQL(name, increment, restart))
+}
+
+// alterSequenceSQL returns the SQL query to modify the DB sequence given by name
+func (d *postgresAdapter) alterSequenceSQL(name string, increment, restart int64) string {
 	query := fmt.Sprintf(`ALTER SEQUENCE %s`, name)
 	if increment != 0 {
 		query += fmt.Sprintf(` INCREMENT BY %d`, increment)
@@ -226,7 +377,47 @@ func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
 	if restart != 0 {
 		query += fmt.Sprintf(` RESTART WITH %d`, restart)
 	}
-	dbExecuteNoTx(query)
+	return query
+}
+
+// isSafeTypeChange returns true if a column of type from can be
+// converted to type to without losing data
+func (d *postgresAdapter) isSafeTypeChange(from, to string) bool {
+	for _, typ := range pgSafeTypeChanges[from] {
+		if typ == to {
+			return true
+		}
+	}
+	return false
+}
+
+// literalSQL returns the given query argument as an SQL literal,
+// so that queries can be printed in a form that can be run as is.
+func (d *postgresAdapter) literalSQL(arg interface{}) string {
+	if valuer, ok := arg.(driver.Valuer); ok {
+		val, err := valuer.Value()
+		if err != nil {
+			log.Panic("Unable to get SQL value of argument", "arg", arg, "error", err)
+		}
+		arg = val
+	}
+	switch a := arg.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if a {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprintf("%v", a)
+	case time.Time:
+		return pq.QuoteLiteral(a.Format("2006-01-02 15:04:05.999999"))
+	case []byte:
+		return pq.QuoteLiteral(string(a))
+	default:
+		return pq.QuoteLiteral(fmt.Sprintf("%v", a))
+	}
 }
 
 // nextSequenceValue returns the next value of the given given sequence
@@ -245,12 +436,102 @@ func (d *postgresAdapter) sequences(pattern string) []seqData {
 	return res
 }
 
+// sequenceExists returns true if a sequence with the given name exists
+func (d *postgresAdapter) sequenceExists(name string) bool {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.sequences WHERE sequence_name = '%s'", name)
+	var cnt int
+	dbGetNoTx(&cnt, query)
+	return cnt > 0
+}
+
 // setTransactionIsolation returns the SQL string to set the
 // transaction isolation level to serializable
 func (d *postgresAdapter) setTransactionIsolation() string {
 	return "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
 }
 
+// createDatabaseSQL returns the SQL query to create the database with the
+// given name as a copy of the given template database, or of the default
+// template if empty.
+func (d *postgresAdapter) createDatabaseSQL(name, template string) string {
+	if template == "" {
+		return fmt.Sprintf(`CREATE DATABASE %s`, d.quoteTableName(name))
+	}
+	return fmt.Sprintf(`CREATE DATABASE %s TEMPLATE %s`, d.quoteTableName(name), d.quoteTableName(template))
+}
+
+// dropDatabaseSQL returns the SQL query to drop the database with the given name
+func (d *postgresAdapter) dropDatabaseSQL(name string) string {
+	return fmt.Sprintf(`DROP DATABASE IF EXISTS %s`, d.quoteTableName(name))
+}
+
+// setStatementTimeout returns the SQL string to set the statement timeout of
+// the current transaction. A zero duration disables the timeout.
+func (d *postgresAdapter) setStatementTimeout(timeout time.Duration) string {
+	return fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())
+}
+
+// setLockTimeout returns the SQL string to set the lock timeout of the current
+// transaction. A zero duration disables the timeout.
+func (d *postgresAdapter) setLockTimeout(timeout time.Duration) string {
+	return fmt.Sprintf("SET LOCAL lock_timeout = %d", timeout.Milliseconds())
+}
+
+// setReadOnlyTransaction returns the SQL string to set a read only transaction
+// with an isolation level that is available on replicas
+func (d *postgresAdapter) setReadOnlyTransaction() string {
+	return "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"
+}
+
+// replicaLag returns the replication lag of the given replica.
+// The lag is 0 if the replica has replayed all the data it received.
+func (d *postgresAdapter) replicaLag(replica *sqlx.DB) (time.Duration, error) {
+	query := `
+		SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END
+	`
+	var lag float64
+	if err := replica.Get(&lag, query); err != nil {
+		return 0, err
+	}
+	return time.Duration(lag * float64(time.Second)), nil
+}
+
+// notifySQL returns the SQL query to send a notification to the listeners of a
+// channel. The query has a placeholder for the channel and one for the payload.
+func (d *postgresAdapter) notifySQL() string {
+	return `SELECT pg_notify(?, ?)`
+}
+
+// listen starts listening to the notifications of the given channel on the
+// database given by params. handler is called with the payload of each
+// notification, and with an empty payload when notifications may have been
+// lost. Listening stops when the returned io.Closer is closed.
+func (d *postgresAdapter) listen(params ConnectionParams, channel string, handler func(payload string)) (io.Closer, error) {
+	listener := pq.NewListener(d.connectionString(params), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Database listener error", "channel", channel, "error", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go func() {
+		for notification := range listener.Notify {
+			if notification == nil {
+				// The connection has been reestablished
+				handler("")
+				continue
+			}
+			handler(notification.Extra)
+		}
+	}()
+	return listener, nil
+}
+
 // childrenIdsQuery returns a query that finds all descendant of the given
 // a record from table including itself. The query has a placeholder for the
 // record's ID
@@ -0,0 +1,84 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"reflect"
+
+	"github.com/gleke/hexya/src/tools/encryption"
+)
+
+// encryptionKeys is the keyring used to encrypt and decrypt
+// the values of encrypted fields.
+var encryptionKeys *encryption.Keyring
+
+// SetEncryptionKeys sets the secrets used to encrypt the values of encrypted fields.
+//
+// The first secret is used to encrypt new values, while all secrets are used to
+// decrypt and search existing values. Keys can therefore be rotated by adding a new
+// secret in first position and keeping the old ones until all records are rewritten.
+func SetEncryptionKeys(secrets ...string) {
+	encryptionKeys = encryption.NewKeyring(secrets...)
+}
+
+// encryptFieldValue returns the given value encrypted for storage in
+// the column of the given field. Null and empty values are not encrypted.
+func encryptFieldValue(fi *Field, value interface{}) interface{} {
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return value
+	}
+	res, err := encryptionKeys.Encrypt(str, fi.deterministic)
+	if err != nil {
+		log.Panic("Unable to encrypt field value", "model", fi.model.name, "field", fi.name, "error", err)
+	}
+	return res
+}
+
+// decryptFieldValue returns the clear value of the given encrypted
+// value read from the database for the given field.
+func decryptFieldValue(fi *Field, value interface{}) interface{} {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		return value
+	}
+	res, err := encryptionKeys.Decrypt(str)
+	if err != nil {
+		log.Panic("Unable to decrypt field value", "model", fi.model.name, "field", fi.name, "error", err)
+	}
+	return res
+}
+
+// encryptedSearchValues returns the list of encrypted values to search for in
+// the column of the given field so as to find the given clear value(s).
+//
+// arg can be a single value or a slice of values. Each value is encrypted with
+// all keys so that records encrypted with an older key are found too.
+func encryptedSearchValues(fi *Field, arg interface{}) []string {
+	if !fi.deterministic {
+		log.Panic("Encrypted fields can only be searched if they are deterministic", "model", fi.model.name, "field", fi.name)
+	}
+	val := reflect.ValueOf(arg)
+	if val.Kind() != reflect.Slice {
+		val = reflect.ValueOf([]interface{}{arg})
+	}
+	var res []string
+	for i := 0; i < val.Len(); i++ {
+		str, ok := val.Index(i).Interface().(string)
+		if !ok {
+			log.Panic("Encrypted fields can only be searched with string values", "model", fi.model.name, "field", fi.name, "value", val.Index(i).Interface())
+		}
+		encValues, err := encryptionKeys.EncryptAll(str)
+		if err != nil {
+			log.Panic("Unable to encrypt search value", "model", fi.model.name, "field", fi.name, "error", err)
+		}
+		res = append(res, encValues...)
+	}
+	return res
+}
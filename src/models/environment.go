@@ -15,9 +15,12 @@
 package models
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/gleke/hexya/src/models/types"
+	"github.com/gleke/hexya/src/models/types/dates"
 	"github.com/gleke/hexya/src/tools/logging"
 )
 
@@ -37,6 +40,7 @@ const maxRecursionDepth uint8 = 100
 // The Environment also stores caches.
 type Environment struct {
 	cr             *Cursor
+	tenant         string
 	uid            int64
 	context        *types.Context
 	cache          *cache
@@ -45,6 +49,22 @@ type Environment struct {
 	previousMethod *Method
 	recursions     uint8
 	nextNegativeID int64
+	// sharedCacheVersion is the version of the shared cache
+	// when this Environment has been created
+	sharedCacheVersion uint64
+	// sharedCacheInvalidations holds the ids of the records of each
+	// model that have been invalidated in the shared cache by this
+	// Environment. The whole model has been invalidated if nil.
+	sharedCacheInvalidations map[string][]int64
+	// events are the lifecycle events to pass to the
+	// post-commit handlers when the transaction is committed
+	events *[]Event
+	// profiler profiles the method calls of this
+	// Environment. It is nil if profiling is disabled.
+	profiler *envProfiler
+	// ruleConditions caches the conditions of the record
+	// rules built for each user in this Environment
+	ruleConditions map[ruleConditionKey]*Condition
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -52,6 +72,19 @@ func (env Environment) Cr() *Cursor {
 	return env.cr
 }
 
+// Ctx returns the context.Context of this Environment.
+//
+// The queries of this Environment are canceled when this context is done.
+func (env Environment) Ctx() context.Context {
+	return env.cr.ctx
+}
+
+// Tenant returns the name of the tenant on whose database this
+// Environment runs, or an empty string for the default database.
+func (env Environment) Tenant() string {
+	return env.tenant
+}
+
 // Uid returns the user id of the Environment
 func (env Environment) Uid() int64 {
 	return env.uid
@@ -62,13 +95,63 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
+// Timezone returns the location of the timezone given by the 'tz' key of the context.
+//
+// It returns UTC if there is no 'tz' key or if the timezone is unknown.
+func (env Environment) Timezone() *time.Location {
+	tz := env.context.GetString("tz")
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := dates.LoadLocation(tz)
+	if err != nil {
+		log.Warn("Unknown timezone in context, using UTC", "tz", tz, "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// CompanyID returns the ID of the current company given by the
+// 'company_id' key of the context, or 0 if there is none.
+func (env Environment) CompanyID() int64 {
+	return env.context.GetInteger(CompanyContextKey)
+}
+
+// AllowedCompanyIDs returns the IDs of the companies whose records are
+// requested to be accessed in this Environment.
+//
+// They are given by the 'allowed_company_ids' key of the context, or by the
+// 'company_id' key if there is none. It returns nil if none of these keys is
+// set. Company rules further restrict these companies to the companies of
+// the user.
+func (env Environment) AllowedCompanyIDs() []int64 {
+	if env.context.HasKey(AllowedCompaniesContextKey) {
+		return env.context.GetIntegerSlice(AllowedCompaniesContextKey)
+	}
+	if companyID := env.CompanyID(); companyID != 0 {
+		return []int64{companyID}
+	}
+	return nil
+}
+
+// Today returns the current date in the timezone of this Environment.
+//
+// This should be preferred to dates.Today() when computing dates for the user.
+func (env Environment) Today() dates.Date {
+	return dates.TodayIn(env.Timezone())
+}
+
 // commit the transaction of this environment.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
-func (env Environment) commit() {
-	env.Cr().tx.Commit()
+func (env Environment) commit() error {
+	if err := env.Cr().commit(); err != nil {
+		return err
+	}
+	env.notifySharedCacheInvalidations()
+	return nil
 }
 
 // rollback the transaction of this environment.
@@ -77,7 +160,7 @@ func (env Environment) commit() {
 // did not create yourself with NewEnvironment. Just panic instead
 // for the framework to roll back automatically for you.
 func (env Environment) rollback() {
-	env.Cr().tx.Rollback()
+	env.Cr().rollback()
 }
 
 // checkRecursion panics if the recursion depth limit is reached
@@ -121,11 +204,37 @@ func (env Environment) DumpCache() string {
 // or rollback() on the returned Environment after operation to release
 // the database connection.
 func newEnvironment(uid int64) Environment {
+	return newTenantEnvironment(context.Background(), "", uid)
+}
+
+// newTenantEnvironment returns a new Environment for the given user ID
+// on the database of the given tenant. The queries of the Environment
+// are canceled when ctx is done.
+//
+// It panics if the database of the tenant cannot be reached.
+//
+// WARNING: Callers to newTenantEnvironment should ensure to either call
+// commit() or rollback() on the returned Environment after operation to
+// release the database connection.
+func newTenantEnvironment(ctx context.Context, tenant string, uid int64) Environment {
+	conn, err := tenantDB(tenant)
+	if err != nil {
+		log.Panic("Unable to create environment", "tenant", tenant, "error", err)
+	}
+	// The version is read before the transaction starts so that the
+	// invalidations committed in between are not missed.
+	version := currentSharedCacheVersion()
 	env := Environment{
-		cr:      newCursor(db),
-		uid:     uid,
-		context: types.NewContext(),
-		cache:   newCache(),
+		cr:                       newCursor(ctx, conn),
+		tenant:                   tenant,
+		uid:                      uid,
+		context:                  types.NewContext(),
+		cache:                    newCache(),
+		sharedCacheVersion:       version,
+		sharedCacheInvalidations: make(map[string][]int64),
+		events:                   new([]Event),
+		profiler:                 newEnvProfiler(ctx),
+		ruleConditions:           make(map[ruleConditionKey]*Condition),
 	}
 	return env
 }
@@ -138,19 +247,31 @@ func newEnvironment(uid int64) Environment {
 // errors are automatically retried several times before returning an
 // error if they still occur.
 func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
-	return doExecuteInNewEnvironment(uid, 0, fnct)
+	return doExecuteInNewEnvironment(context.Background(), "", uid, 0, fnct)
 }
 
-func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
-	env := newEnvironment(uid)
+// ExecuteInNewEnvironmentContext executes the given fnct in a new Environment
+// within a new transaction, like ExecuteInNewEnvironment.
+//
+// The queries of the Environment are canceled and the transaction is
+// rolled back when the given context is done. The Environment runs on
+// the database of the tenant of the context (see ContextWithTenant).
+func ExecuteInNewEnvironmentContext(ctx context.Context, uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(ctx, TenantFromContext(ctx), uid, 0, fnct)
+}
+
+func doExecuteInNewEnvironment(ctx context.Context, tenant string, uid int64, retries uint8, fnct func(Environment)) (rError error) {
+	var env Environment
 	defer func() {
 		if r := recover(); r != nil {
-			env.rollback()
+			if env.cr != nil {
+				env.rollback()
+			}
 			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
 				// Transaction error
 				retries++
 				if retries < DBSerializationMaxRetries {
-					if doExecuteInNewEnvironment(uid, retries, fnct) == nil {
+					if doExecuteInNewEnvironment(ctx, tenant, uid, retries, fnct) == nil {
 						rError = nil
 						return
 					}
@@ -159,8 +280,13 @@ func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment))
 			rError = logging.LogPanicData(r)
 			return
 		}
-		env.commit()
+		if err := env.commit(); err != nil {
+			rError = logging.LogPanicData(err)
+			return
+		}
+		env.runCommitHandlers()
 	}()
+	env = newTenantEnvironment(ctx, tenant, uid)
 	fnct(env)
 	return nil
 }
@@ -170,21 +296,36 @@ func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment))
 //
 // This function always rolls back the transaction but returns an error
 // only if fnct panicked during its execution.
+//
+// The new Environment is read only (see Environment.ReadOnly).
 func SimulateInNewEnvironment(uid int64, fnct func(Environment)) error {
-	return doSimulateInNewEnvironment(uid, 0, fnct)
+	return doSimulateInNewEnvironment(context.Background(), "", uid, 0, fnct)
 }
 
-func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
-	env := newEnvironment(uid)
+// SimulateInNewEnvironmentContext executes the given fnct in a new Environment
+// within a new transaction and rolls back the transaction at the end, like
+// SimulateInNewEnvironment.
+//
+// The queries of the Environment are canceled when the given context is
+// done. The Environment runs on the database of the tenant of the context
+// (see ContextWithTenant).
+func SimulateInNewEnvironmentContext(ctx context.Context, uid int64, fnct func(Environment)) error {
+	return doSimulateInNewEnvironment(ctx, TenantFromContext(ctx), uid, 0, fnct)
+}
+
+func doSimulateInNewEnvironment(ctx context.Context, tenant string, uid int64, retries uint8, fnct func(Environment)) (rError error) {
+	var env Environment
 	defer func() {
-		env.rollback()
+		if env.cr != nil {
+			env.rollback()
+		}
 		if r := recover(); r != nil {
 			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
 				// Transaction error. We try again even if we rollback anyway
 				// to be as close as ExecuteInNewEnvironment as possible
 				retries++
 				if retries < DBSerializationMaxRetries {
-					if doSimulateInNewEnvironment(uid, retries, fnct) == nil {
+					if doSimulateInNewEnvironment(ctx, tenant, uid, retries, fnct) == nil {
 						rError = nil
 						return
 					}
@@ -194,10 +335,36 @@ func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)
 			return
 		}
 	}()
+	env = newTenantEnvironment(ctx, tenant, uid).ReadOnly()
 	fnct(env)
 	return
 }
 
+// ReadOnly marks this Environment as read only and returns it.
+//
+// The queries of a read only Environment are run on a read replica of
+// the database, if one is available with a lag below the maximum replica
+// lag. The Environment is moved to the primary database at its first
+// write query and stays there until the end of the transaction.
+//
+// ReadOnly has no effect if this Environment has already written to the
+// database. Otherwise, queries previously run in this Environment are
+// not guaranteed to see the same snapshot as the next ones.
+func (env Environment) ReadOnly() Environment {
+	env.cr.setReadOnly()
+	return env
+}
+
+// WithTimeout sets the statement timeout of the queries of this
+// Environment's transaction to the given duration and returns the
+// Environment. A zero duration disables the timeout.
+//
+// The timeout defaults to the StatementTimeout of the connection parameters.
+func (env Environment) WithTimeout(timeout time.Duration) Environment {
+	env.cr.setStatementTimeout(timeout)
+	return env
+}
+
 // Pool returns an empty RecordCollection for the given modelName
 func (env Environment) Pool(modelName string) *RecordCollection {
 	return newRecordCollection(env, modelName)
@@ -0,0 +1,222 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sync"
+
+	"github.com/gleke/hexya/src/tools/logging"
+)
+
+// An EventType is the type of a lifecycle event of the records of a model
+type EventType uint8
+
+// Lifecycle event types
+const (
+	CreateEvent EventType = iota + 1
+	WriteEvent
+	UnlinkEvent
+)
+
+// String method for EventType
+func (et EventType) String() string {
+	switch et {
+	case CreateEvent:
+		return "create"
+	case WriteEvent:
+		return "write"
+	case UnlinkEvent:
+		return "unlink"
+	}
+	return "unknown"
+}
+
+// An Event is a lifecycle event of records of a model that is
+// passed to the post-commit handlers.
+type Event struct {
+	Type   EventType
+	Model  string
+	IDs    []int64
+	Fields FieldNames
+	UserID int64
+	Tenant string
+}
+
+// A writeSubscription is a handler called after records are written
+type writeSubscription struct {
+	fields  map[string]bool
+	handler func(RecordSet, FieldNames)
+}
+
+// ModelEvents is the event bus of a model, on which handlers
+// subscribe to the lifecycle events of the model's records.
+//
+// Handlers are called within the transaction in which the event
+// occurs, except post-commit handlers which are called after the
+// transaction has been successfully committed.
+type ModelEvents struct {
+	sync.RWMutex
+	model        *Model
+	afterCreate  []func(RecordSet)
+	afterWrite   []writeSubscription
+	beforeUnlink []func(RecordSet)
+	afterCommit  []func(Event)
+}
+
+// AfterCreate subscribes the given handler to the creation of records.
+// The handler is called with the created records.
+func (me *ModelEvents) AfterCreate(handler func(rs RecordSet)) {
+	me.Lock()
+	defer me.Unlock()
+	me.afterCreate = append(me.afterCreate, handler)
+}
+
+// AfterWrite subscribes the given handler to the update of records.
+// The handler is called with the updated records and the written fields.
+//
+// If fields are given, the handler is only called when one of them
+// is written.
+func (me *ModelEvents) AfterWrite(handler func(rs RecordSet, fields FieldNames), fields ...FieldName) {
+	me.Lock()
+	defer me.Unlock()
+	sub := writeSubscription{handler: handler}
+	if len(fields) > 0 {
+		sub.fields = make(map[string]bool)
+		for _, field := range fields {
+			sub.fields[me.model.fields.MustGet(field.Name()).json] = true
+		}
+	}
+	me.afterWrite = append(me.afterWrite, sub)
+}
+
+// BeforeUnlink subscribes the given handler to the deletion of records.
+// The handler is called with the records about to be deleted.
+func (me *ModelEvents) BeforeUnlink(handler func(rs RecordSet)) {
+	me.Lock()
+	defer me.Unlock()
+	me.beforeUnlink = append(me.beforeUnlink, handler)
+}
+
+// AfterCommit subscribes the given handler to the events of the records
+// of the model in a transaction. The handler is called with each event
+// after the transaction has been successfully committed.
+//
+// Panics in the handler are logged and do not affect other handlers.
+func (me *ModelEvents) AfterCommit(handler func(event Event)) {
+	me.Lock()
+	defer me.Unlock()
+	me.afterCommit = append(me.afterCommit, handler)
+}
+
+// Events returns the event bus of this model
+func (m *Model) Events() *ModelEvents {
+	return m.events
+}
+
+// newModelEvents returns a pointer to a new event bus for the given model
+func newModelEvents(model *Model) *ModelEvents {
+	return &ModelEvents{
+		model: model,
+	}
+}
+
+// publishCreateEvent calls the handlers of the creation
+// of the records of this RecordCollection.
+func (rc *RecordCollection) publishCreateEvent(fields FieldNames) {
+	me := rc.model.events
+	me.RLock()
+	handlers := me.afterCreate
+	me.RUnlock()
+	for _, handler := range handlers {
+		handler(rc)
+	}
+	rc.queueEvent(CreateEvent, fields)
+}
+
+// publishWriteEvent calls the handlers of the update of
+// the given fields of the records of this RecordCollection.
+func (rc *RecordCollection) publishWriteEvent(fields FieldNames) {
+	me := rc.model.events
+	me.RLock()
+	subs := me.afterWrite
+	me.RUnlock()
+	for _, sub := range subs {
+		if sub.fields != nil {
+			var found bool
+			for _, field := range fields {
+				if sub.fields[field.JSON()] {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		sub.handler(rc, fields)
+	}
+	rc.queueEvent(WriteEvent, fields)
+}
+
+// publishUnlinkEvent calls the handlers of the deletion
+// of the records of this RecordCollection.
+func (rc *RecordCollection) publishUnlinkEvent() {
+	me := rc.model.events
+	me.RLock()
+	handlers := me.beforeUnlink
+	me.RUnlock()
+	for _, handler := range handlers {
+		handler(rc)
+	}
+	rc.queueEvent(UnlinkEvent, nil)
+}
+
+// queueEvent adds an event of the given type on this RecordCollection
+// to the events to pass to the post-commit handlers of its model.
+func (rc *RecordCollection) queueEvent(eventType EventType, fields FieldNames) {
+	me := rc.model.events
+	me.RLock()
+	hasHandlers := len(me.afterCommit) > 0
+	me.RUnlock()
+	if !hasHandlers || rc.hasNegIds {
+		return
+	}
+	ids := make([]int64, len(rc.ids))
+	copy(ids, rc.ids)
+	*rc.env.events = append(*rc.env.events, Event{
+		Type:   eventType,
+		Model:  rc.model.name,
+		IDs:    ids,
+		Fields: fields,
+		UserID: rc.env.uid,
+		Tenant: rc.env.tenant,
+	})
+}
+
+// runCommitHandlers calls the post-commit handlers with the events
+// that occurred in this Environment. It must be called only after the
+// transaction of this Environment has been successfully committed.
+func (env Environment) runCommitHandlers() {
+	for _, event := range *env.events {
+		me := Registry.MustGet(event.Model).events
+		me.RLock()
+		handlers := me.afterCommit
+		me.RUnlock()
+		for _, handler := range handlers {
+			runCommitHandler(handler, event)
+		}
+	}
+	*env.events = nil
+}
+
+// runCommitHandler calls the given post-commit handler
+// with the given event and logs it if it panics.
+func runCommitHandler(handler func(Event), event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.LogPanicData(r)
+		}
+	}()
+	handler(event)
+}
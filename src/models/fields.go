@@ -18,10 +18,12 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/gleke/hexya/src/models/fieldtype"
+	"github.com/gleke/hexya/src/models/security"
 	"github.com/gleke/hexya/src/models/types"
 	"github.com/gleke/hexya/src/tools/nbutils"
 	"github.com/gleke/hexya/src/tools/strutils"
@@ -220,6 +222,7 @@ type Field struct {
 	m2mTheirField    *Field
 	selection        types.Selection
 	selectionFunc    func() types.Selection
+	selectionEnvFunc func(Environment) types.Selection
 	fieldType        fieldtype.Type
 	groupOperator    string
 	size             int
@@ -230,6 +233,9 @@ type Field struct {
 	dependencies     []computeData
 	embed            bool
 	noCopy           bool
+	encrypted        bool
+	deterministic    bool
+	oldNames         []string
 	defaultFunc      func(Environment) interface{}
 	onDelete         OnDeleteAction
 	onChange         string
@@ -241,6 +247,8 @@ type Field struct {
 	contexts         FieldContexts
 	ctxType          ctxType
 	updates          []map[string]interface{}
+	readGroups       map[*security.Group]bool
+	writeGroups      map[*security.Group]bool
 }
 
 // isComputedField returns true if this field is computed
@@ -305,6 +313,17 @@ func (f *Field) isContextedField() bool {
 	return false
 }
 
+// currentSelection returns the selection of this field in the given Environment.
+//
+// If the field has an environment selection function, it is called with env.
+// Otherwise, the static selection of the field is returned.
+func (f *Field) currentSelection(env Environment) types.Selection {
+	if f.selectionEnvFunc != nil {
+		return f.selectionEnvFunc(env)
+	}
+	return f.selection
+}
+
 // JSON returns this field name as FieldName type
 func (f *Field) JSON() string {
 	return f.json
@@ -355,6 +374,14 @@ func SnakeCaseFieldName(fName string, typ fieldtype.Type) string {
 	return res
 }
 
+// M2MDefaultLinkModelName returns the name of the link model of a many2many
+// relation between the given models when no link model name is specified.
+func M2MDefaultLinkModelName(model1, model2 string) string {
+	modelNames := []string{model1, model2}
+	sort.Strings(modelNames)
+	return fmt.Sprintf("%s%sRel", modelNames[0], modelNames[1])
+}
+
 // CreateM2MRelModelInfo creates a Model relModelName (if it does not exist)
 // for the m2m relation defined between model1 and model2.
 // It returns the Model of the intermediate model, the Field of that model
@@ -383,6 +410,7 @@ func CreateM2MRelModelInfo(relModelName, model1, model2, field1, field2 string,
 		sqlErrors:       make(map[string]string),
 		defaultOrderStr: []string{"ID"},
 	}
+	newMI.events = newModelEvents(newMI)
 	if mixin {
 		newMI.options |= MixinModel
 	}
@@ -439,6 +467,7 @@ func createContextsModel(fi *Field, contexts FieldContexts) *Model {
 		sqlErrors:       make(map[string]string),
 		defaultOrderStr: []string{"ID"},
 	}
+	newModel.events = newModelEvents(&newModel)
 	pkField := &Field{
 		name:      "ID",
 		json:      "id",
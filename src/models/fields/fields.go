@@ -4,9 +4,7 @@
 package fields
 
 import (
-	"fmt"
 	"log"
-	"sort"
 
 	"github.com/gleke/hexya/src/models"
 	"github.com/gleke/hexya/src/models/fieldtype"
@@ -28,8 +26,12 @@ type FieldDefinition interface {
 //
 // TypeBinary fields are stored in the database. Consider other disk based
 // alternatives if you have a large amount of data to store.
+//
+// If Encrypted is set, values are encrypted in the database with the
+// application keys (see models.SetEncryptionKeys).
 type Binary struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -44,6 +46,8 @@ type Binary struct {
 	Depends         []string
 	Related         string
 	NoCopy          bool
+	Encrypted       bool
+	Deterministic   bool
 	GoType          interface{}
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
@@ -63,29 +67,31 @@ func (bf Binary) DeclareField(fc *models.FieldsCollection, name string) *models.
 //
 // Clients are expected to handle boolean fields as checkboxes.
 type Boolean struct {
-	JSON            string
-	String          string
-	Help            string
-	Stored          bool
-	Required        bool
-	ReadOnly        bool
-	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
-	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
-	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
-	Index           bool
-	Compute         models.Methoder
-	Depends         []string
-	Related         string
-	NoCopy          bool
-	GoType          interface{}
-	OnChange        models.Methoder
-	OnChangeWarning models.Methoder
-	OnChangeFilters models.Methoder
-	Constraint      models.Methoder
-	Inverse         models.Methoder
-	Contexts        models.FieldContexts
-	Default         func(models.Environment) interface{}
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	GoType           interface{}
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
 }
 
 // DeclareField creates a boolean field for the given models.FieldsCollection with the given name.
@@ -100,32 +106,40 @@ func (bf Boolean) DeclareField(fc *models.FieldsCollection, name string) *models
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle TypeChar fields as single line inputs.
+//
+// If Encrypted is set, values are encrypted in the database with the
+// application keys (see models.SetEncryptionKeys). Encrypted fields can
+// only be searched by equality and only if Deterministic is also set.
 type Char struct {
-	JSON            string
-	String          string
-	Help            string
-	Stored          bool
-	Required        bool
-	ReadOnly        bool
-	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
-	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
-	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
-	Index           bool
-	Compute         models.Methoder
-	Depends         []string
-	Related         string
-	NoCopy          bool
-	Size            int
-	GoType          interface{}
-	Translate       bool
-	OnChange        models.Methoder
-	OnChangeWarning models.Methoder
-	OnChangeFilters models.Methoder
-	Constraint      models.Methoder
-	Inverse         models.Methoder
-	Contexts        models.FieldContexts
-	Default         func(models.Environment) interface{}
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Encrypted        bool
+	Deterministic    bool
+	Size             int
+	GoType           interface{}
+	Translate        bool
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
 }
 
 // DeclareField creates a char field for the given models.FieldsCollection with the given name.
@@ -135,11 +149,14 @@ func (cf Char) DeclareField(fc *models.FieldsCollection, name string) *models.Fi
 	return fInfo
 }
 
-// A Date is a field for storing dates without time.
+// A CharArray is a field for storing a list of short texts, such as tags
+// or codes, in a single database column.
 //
-// Clients are expected to handle Date fields with a date picker.
-type Date struct {
+// If Index is set, a GIN index is created so that Contains and Overlaps
+// conditions can be efficiently searched.
+type CharArray struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -148,12 +165,10 @@ type Date struct {
 	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
 	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
 	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
 	Index           bool
 	Compute         models.Methoder
 	Depends         []string
 	Related         string
-	GroupOperator   string
 	NoCopy          bool
 	GoType          interface{}
 	OnChange        models.Methoder
@@ -165,6 +180,44 @@ type Date struct {
 	Default         func(models.Environment) interface{}
 }
 
+// DeclareField creates a char array field for the given models.FieldsCollection with the given name.
+func (cf CharArray) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	fInfo := models.CreateFieldFromStruct(fc, &cf, name, fieldtype.CharArray, new([]string))
+	return fInfo
+}
+
+// A Date is a field for storing dates without time.
+//
+// Clients are expected to handle Date fields with a date picker.
+type Date struct {
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	GoType           interface{}
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
+}
+
 // DeclareField creates a date field for the given models.FieldsCollection with the given name.
 func (df Date) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
 	fInfo := models.CreateFieldFromStruct(fc, &df, name, fieldtype.Date, new(dates.Date))
@@ -176,30 +229,32 @@ func (df Date) DeclareField(fc *models.FieldsCollection, name string) *models.Fi
 //
 // Clients are expected to handle DateTime fields with a date and time picker.
 type DateTime struct {
-	JSON            string
-	String          string
-	Help            string
-	Stored          bool
-	Required        bool
-	ReadOnly        bool
-	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
-	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
-	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
-	Index           bool
-	Compute         models.Methoder
-	Depends         []string
-	Related         string
-	GroupOperator   string
-	NoCopy          bool
-	GoType          interface{}
-	OnChange        models.Methoder
-	OnChangeWarning models.Methoder
-	OnChangeFilters models.Methoder
-	Constraint      models.Methoder
-	Inverse         models.Methoder
-	Contexts        models.FieldContexts
-	Default         func(models.Environment) interface{}
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	GoType           interface{}
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
 }
 
 // DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
@@ -209,9 +264,12 @@ func (df DateTime) DeclareField(fc *models.FieldsCollection, name string) *model
 	return fInfo
 }
 
-// A Float is a field for storing decimal numbers.
-type Float struct {
+// A Duration is a field for storing durations, such as worked hours.
+//
+// Clients are expected to handle Duration fields as a float number of hours.
+type Duration struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -227,7 +285,6 @@ type Float struct {
 	Related         string
 	GroupOperator   string
 	NoCopy          bool
-	Digits          nbutils.Digits
 	GoType          interface{}
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
@@ -238,6 +295,47 @@ type Float struct {
 	Default         func(models.Environment) interface{}
 }
 
+// DeclareField creates a duration field for the given models.FieldsCollection with the given name.
+func (df Duration) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	if df.Default == nil {
+		df.Default = models.DefaultValue(dates.Duration{})
+	}
+	fInfo := models.CreateFieldFromStruct(fc, &df, name, fieldtype.Duration, new(dates.Duration))
+	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(df.GroupOperator, "sum"))
+	return fInfo
+}
+
+// A Float is a field for storing decimal numbers.
+type Float struct {
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	Digits           nbutils.Digits
+	GoType           interface{}
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
+}
+
 // DeclareField adds this datetime field for the given models.FieldsCollection with the given name.
 func (ff Float) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
 	if ff.Default == nil {
@@ -253,31 +351,33 @@ func (ff Float) DeclareField(fc *models.FieldsCollection, name string) *models.F
 //
 // Clients are expected to handle HTML fields with multi-line HTML editors.
 type HTML struct {
-	JSON            string
-	String          string
-	Help            string
-	Stored          bool
-	Required        bool
-	ReadOnly        bool
-	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
-	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
-	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
-	Index           bool
-	Compute         models.Methoder
-	Depends         []string
-	Related         string
-	NoCopy          bool
-	Size            int
-	GoType          interface{}
-	Translate       bool
-	OnChange        models.Methoder
-	OnChangeWarning models.Methoder
-	OnChangeFilters models.Methoder
-	Constraint      models.Methoder
-	Inverse         models.Methoder
-	Contexts        models.FieldContexts
-	Default         func(models.Environment) interface{}
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Size             int
+	GoType           interface{}
+	Translate        bool
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
 }
 
 // DeclareField creates a html field for the given models.FieldsCollection with the given name.
@@ -289,7 +389,52 @@ func (tf HTML) DeclareField(fc *models.FieldsCollection, name string) *models.Fi
 
 // An Integer is a field for storing non decimal numbers.
 type Integer struct {
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	GroupOperator    string
+	NoCopy           bool
+	GoType           interface{}
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
+}
+
+// DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
+func (i Integer) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	if i.Default == nil {
+		i.Default = models.DefaultValue(0)
+	}
+	fInfo := models.CreateFieldFromStruct(fc, &i, name, fieldtype.Integer, new(int64))
+	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(i.GroupOperator, "sum"))
+	return fInfo
+}
+
+// An IntegerArray is a field for storing a list of non decimal numbers
+// in a single database column.
+//
+// If Index is set, a GIN index is created so that Contains and Overlaps
+// conditions can be efficiently searched.
+type IntegerArray struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -298,12 +443,10 @@ type Integer struct {
 	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
 	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
 	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
 	Index           bool
 	Compute         models.Methoder
 	Depends         []string
 	Related         string
-	GroupOperator   string
 	NoCopy          bool
 	GoType          interface{}
 	OnChange        models.Methoder
@@ -315,13 +458,9 @@ type Integer struct {
 	Default         func(models.Environment) interface{}
 }
 
-// DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
-func (i Integer) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
-	if i.Default == nil {
-		i.Default = models.DefaultValue(0)
-	}
-	fInfo := models.CreateFieldFromStruct(fc, &i, name, fieldtype.Integer, new(int64))
-	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(i.GroupOperator, "sum"))
+// DeclareField creates an integer array field for the given models.FieldsCollection with the given name.
+func (i IntegerArray) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	fInfo := models.CreateFieldFromStruct(fc, &i, name, fieldtype.IntegerArray, new([]int64))
 	return fInfo
 }
 
@@ -372,11 +511,9 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 			"model", fc.Model().Name(), "field", name, "ours", our, "theirs", their)
 	}
 
-	modelNames := []string{fc.Model().Name(), mf.RelationModel.Underlying().Name()}
-	sort.Strings(modelNames)
 	m2mRelModName := mf.M2MLinkModelName
 	if m2mRelModName == "" {
-		m2mRelModName = fmt.Sprintf("%s%sRel", modelNames[0], modelNames[1])
+		m2mRelModName = models.M2MDefaultLinkModelName(fc.Model().Name(), mf.RelationModel.Underlying().Name())
 	}
 	m2mRelModel, m2mOurField, m2mTheirField := models.CreateM2MRelModelInfo(m2mRelModName, fc.Model().Name(), mf.RelationModel.Underlying().Name(), our, their, fc.Model().IsMixin())
 
@@ -396,6 +533,7 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 // Clients are expected to handle many2one fields with a combo-box.
 type Many2One struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -496,6 +634,7 @@ func (of One2Many) DeclareField(fc *models.FieldsCollection, name string) *model
 // Clients are expected to handle one2one fields with a combo-box.
 type One2One struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -594,31 +733,38 @@ func (rf Rev2One) DeclareField(fc *models.FieldsCollection, name string) *models
 // A Selection is a field for storing a value from a preset list.
 //
 // Clients are expected to handle selection fields with a combo-box or radio buttons.
+//
+// If SelectionEnvFunc is set, it is called with the current Environment to get the
+// selection instead of the static Selection or of the result of SelectionFunc.
+// Written values must be keys of the current selection.
 type Selection struct {
-	JSON            string
-	String          string
-	Help            string
-	Stored          bool
-	Required        bool
-	ReadOnly        bool
-	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
-	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
-	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
-	Unique          bool
-	Index           bool
-	Compute         models.Methoder
-	Depends         []string
-	Related         string
-	NoCopy          bool
-	Selection       types.Selection
-	SelectionFunc   func() types.Selection
-	OnChange        models.Methoder
-	OnChangeWarning models.Methoder
-	OnChangeFilters models.Methoder
-	Constraint      models.Methoder
-	Inverse         models.Methoder
-	Contexts        models.FieldContexts
-	Default         func(models.Environment) interface{}
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Selection        types.Selection
+	SelectionFunc    func() types.Selection
+	SelectionEnvFunc func(models.Environment) types.Selection
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
 }
 
 // DeclareField creates a selection field for the given models.FieldsCollection with the given name.
@@ -626,6 +772,7 @@ func (sf Selection) DeclareField(fc *models.FieldsCollection, name string) *mode
 	fInfo := models.CreateFieldFromStruct(fc, &sf, name, fieldtype.Selection, new(string))
 	fInfo.SetProperty("selection", sf.Selection)
 	fInfo.SetProperty("selectionFunc", sf.SelectionFunc)
+	fInfo.SetProperty("selectionEnvFunc", sf.SelectionEnvFunc)
 	return fInfo
 }
 
@@ -633,8 +780,55 @@ func (sf Selection) DeclareField(fc *models.FieldsCollection, name string) *mode
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle text fields as multi-line inputs.
+//
+// If Encrypted is set, values are encrypted in the database with the
+// application keys (see models.SetEncryptionKeys). Encrypted fields can
+// only be searched by equality and only if Deterministic is also set.
 type Text struct {
+	JSON             string
+	OldNames         []string
+	String           string
+	Help             string
+	Stored           bool
+	Required         bool
+	ReadOnly         bool
+	RequiredFunc     func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc     func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc    func(models.Environment) (bool, models.Conditioner)
+	Unique           bool
+	Index            bool
+	Compute          models.Methoder
+	Depends          []string
+	Related          string
+	NoCopy           bool
+	Encrypted        bool
+	Deterministic    bool
+	Size             int
+	GoType           interface{}
+	Translate        bool
+	OnChange         models.Methoder
+	OnChangeWarning  models.Methoder
+	OnChangeFilters  models.Methoder
+	Constraint       models.Methoder
+	Inverse          models.Methoder
+	CompanyDependent bool
+	Contexts         models.FieldContexts
+	Default          func(models.Environment) interface{}
+}
+
+// DeclareField creates a text field for the given models.FieldsCollection with the given name.
+func (tf Text) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	fInfo := models.CreateFieldFromStruct(fc, &tf, name, fieldtype.Text, new(string))
+	fInfo.SetProperty("size", tf.Size)
+	return fInfo
+}
+
+// A Time is a field for storing a time of the day without date nor timezone.
+//
+// Clients are expected to handle Time fields with a time picker.
+type Time struct {
 	JSON            string
+	OldNames        []string
 	String          string
 	Help            string
 	Stored          bool
@@ -649,9 +843,7 @@ type Text struct {
 	Depends         []string
 	Related         string
 	NoCopy          bool
-	Size            int
 	GoType          interface{}
-	Translate       bool
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
@@ -661,9 +853,8 @@ type Text struct {
 	Default         func(models.Environment) interface{}
 }
 
-// DeclareField creates a text field for the given models.FieldsCollection with the given name.
-func (tf Text) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
-	fInfo := models.CreateFieldFromStruct(fc, &tf, name, fieldtype.Text, new(string))
-	fInfo.SetProperty("size", tf.Size)
+// DeclareField creates a time field for the given models.FieldsCollection with the given name.
+func (tf Time) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	fInfo := models.CreateFieldFromStruct(fc, &tf, name, fieldtype.Time, new(dates.Time))
 	return fInfo
 }
@@ -78,10 +78,27 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 			return res
 		}
 	}
+	if cd := val.FieldByName("CompanyDependent"); cd.IsValid() && cd.Bool() {
+		if contexts == nil {
+			contexts = make(FieldContexts)
+		}
+		contexts[companyContextName] = companyContext
+	}
 	var noCopy bool
 	if noc := val.FieldByName("NoCopy"); noc.IsValid() {
 		noCopy = noc.Bool()
 	}
+	var encrypted, deterministic bool
+	if enc := val.FieldByName("Encrypted"); enc.IsValid() {
+		encrypted = enc.Bool()
+	}
+	if det := val.FieldByName("Deterministic"); det.IsValid() {
+		deterministic = det.Bool()
+	}
+	var oldNames []string
+	if on := val.FieldByName("OldNames"); on.IsValid() {
+		oldNames = on.Interface().([]string)
+	}
 	fInfo := &Field{
 		model:           fc.model,
 		name:            name,
@@ -101,6 +118,9 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 		depends:         val.FieldByName("Depends").Interface().([]string),
 		relatedPathStr:  val.FieldByName("Related").String(),
 		noCopy:          noCopy,
+		encrypted:       encrypted,
+		deterministic:   deterministic,
+		oldNames:        oldNames,
 		structField:     structField,
 		fieldType:       fieldType,
 		defaultFunc:     val.FieldByName("Default").Interface().(func(Environment) interface{}),
@@ -194,6 +214,8 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.selection = value.(types.Selection)
 	case "selectionFunc":
 		f.selectionFunc = value.(func() types.Selection)
+	case "selectionEnvFunc":
+		f.selectionEnvFunc = value.(func(Environment) types.Selection)
 	case "groupOperator":
 		f.groupOperator = value.(string)
 	case "size":
@@ -206,6 +228,12 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.embed = value.(bool)
 	case "noCopy":
 		f.noCopy = value.(bool)
+	case "encrypted":
+		f.encrypted = value.(bool)
+	case "deterministic":
+		f.deterministic = value.(bool)
+	case "oldNames":
+		f.oldNames = value.([]string)
 	case "defaultFunc":
 		f.defaultFunc = value.(func(Environment) interface{})
 	case "onDelete":
@@ -248,6 +276,19 @@ func (f *Field) SetProperty(property string, value interface{}) {
 			}
 			delete(f.contexts, "lang")
 		}
+	case "companyDependent":
+		switch value.(bool) {
+		case true:
+			if f.contexts == nil {
+				f.contexts = make(FieldContexts)
+			}
+			f.contexts[companyContextName] = companyContext
+		case false:
+			if f.contexts == nil {
+				return
+			}
+			delete(f.contexts, companyContextName)
+		}
 	case "contexts":
 		f.contexts = value.(FieldContexts)
 	default:
@@ -381,12 +422,36 @@ func (f *Field) SetNoCopy(value bool) *Field {
 	return f
 }
 
+// SetEncrypted overrides the value of the Encrypted parameter of this Field
+func (f *Field) SetEncrypted(value bool) *Field {
+	f.addUpdate("encrypted", value)
+	return f
+}
+
+// SetDeterministic overrides the value of the Deterministic parameter of this Field
+func (f *Field) SetDeterministic(value bool) *Field {
+	f.addUpdate("deterministic", value)
+	return f
+}
+
+// SetOldNames overrides the value of the OldNames parameter of this Field
+func (f *Field) SetOldNames(value []string) *Field {
+	f.addUpdate("oldNames", value)
+	return f
+}
+
 // SetTranslate overrides the value of the Translate parameter of this Field
 func (f *Field) SetTranslate(value bool) *Field {
 	f.addUpdate("translate", value)
 	return f
 }
 
+// SetCompanyDependent overrides the value of the CompanyDependent parameter of this Field
+func (f *Field) SetCompanyDependent(value bool) *Field {
+	f.addUpdate("companyDependent", value)
+	return f
+}
+
 // SetContexts overrides the value of the Contexts parameter of this Field
 func (f *Field) SetContexts(value FieldContexts) *Field {
 	f.addUpdate("contexts", value)
@@ -510,3 +575,13 @@ func (f *Field) SetSelectionFunc(value func() types.Selection) *Field {
 	f.addUpdate("selectionFunc", value)
 	return f
 }
+
+// SetSelectionEnvFunc defines the function that will return the selection of this field.
+//
+// This function is called with the current Environment each time the selection is needed,
+// so that options can be read from the database or depend on the current user. It takes
+// precedence over the function set with SetSelectionFunc.
+func (f *Field) SetSelectionEnvFunc(value func(Environment) types.Selection) *Field {
+	f.addUpdate("selectionEnvFunc", value)
+	return f
+}
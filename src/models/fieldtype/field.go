@@ -14,23 +14,27 @@ type Type string
 
 // Types for model fields
 const (
-	NoType    Type = ""
-	Binary    Type = "binary"
-	Boolean   Type = "boolean"
-	Char      Type = "char"
-	Date      Type = "date"
-	DateTime  Type = "datetime"
-	Float     Type = "float"
-	HTML      Type = "html"
-	Integer   Type = "integer"
-	Many2Many Type = "many2many"
-	Many2One  Type = "many2one"
-	One2Many  Type = "one2many"
-	One2One   Type = "one2one"
-	Rev2One   Type = "rev2one"
-	Reference Type = "reference"
-	Selection Type = "selection"
-	Text      Type = "text"
+	NoType       Type = ""
+	Binary       Type = "binary"
+	Boolean      Type = "boolean"
+	Char         Type = "char"
+	CharArray    Type = "chararray"
+	Date         Type = "date"
+	DateTime     Type = "datetime"
+	Duration     Type = "duration"
+	Float        Type = "float"
+	HTML         Type = "html"
+	Integer      Type = "integer"
+	IntegerArray Type = "integerarray"
+	Many2Many    Type = "many2many"
+	Many2One     Type = "many2one"
+	One2Many     Type = "one2many"
+	One2One      Type = "one2one"
+	Rev2One      Type = "rev2one"
+	Reference    Type = "reference"
+	Selection    Type = "selection"
+	Text         Type = "text"
+	Time         Type = "time"
 )
 
 // IsRelationType returns true if this type is a relation.
@@ -68,10 +72,16 @@ func (t Type) Is2ManyRelationType() bool {
 	return t == Many2Many || t == One2Many
 }
 
+// IsArrayType returns true for types that are stored
+// as an array of values in a single column (i.e. IntegerArray and CharArray)
+func (t Type) IsArrayType() bool {
+	return t == IntegerArray || t == CharArray
+}
+
 // IsNullInDB returns true if this type's zero value is
 // saved as null in database.
 func (t Type) IsNullInDB() bool {
-	return t.IsFKRelationType() || t == Binary || t == Char || t == Text || t == HTML || t == Selection || t == Date || t == DateTime
+	return t.IsFKRelationType() || t == Binary || t == Char || t == Text || t == HTML || t == Selection || t == Date || t == DateTime || t == Time || t.IsArrayType()
 }
 
 // DefaultGoType returns this Type's default Go type
@@ -87,11 +97,17 @@ func (t Type) DefaultGoType() reflect.Type {
 		return reflect.TypeOf(*new(dates.Date))
 	case DateTime:
 		return reflect.TypeOf(*new(dates.DateTime))
+	case Duration:
+		return reflect.TypeOf(*new(dates.Duration))
+	case Time:
+		return reflect.TypeOf(*new(dates.Time))
 	case Float:
 		return reflect.TypeOf(*new(float64))
 	case Integer, Many2One, One2One, Rev2One:
 		return reflect.TypeOf(*new(int64))
-	case One2Many, Many2Many:
+	case CharArray:
+		return reflect.TypeOf(*new([]string))
+	case One2Many, Many2Many, IntegerArray:
 		return reflect.TypeOf(*new([]int64))
 	}
 	return reflect.TypeOf(nil)
@@ -0,0 +1,403 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/gleke/hexya/src/models/fieldtype"
+	"github.com/gleke/hexya/src/models/security"
+)
+
+const (
+	// groupModelName is the name of the system model of security groups
+	groupModelName = "HexyaGroup"
+	// groupMembershipModelName is the name of the system model of group memberships
+	groupMembershipModelName = "HexyaGroupMembership"
+	// groupsChannel is the database notification channel on which
+	// changes of group memberships are sent to the other processes.
+	groupsChannel = "hexya_groups"
+)
+
+// A groupsNotification is the payload of a notification sent
+// to the other processes when group memberships have changed.
+type groupsNotification struct {
+	Origin string `json:"origin"`
+	Tenant string `json:"tenant"`
+}
+
+var (
+	groupsMutex    sync.Mutex
+	groupsListener io.Closer
+	// persistedMemberships holds the group IDs of the memberships of
+	// each user of each tenant as loaded from the tenant's database
+	persistedMemberships = make(map[string]map[int64]map[string]bool)
+
+	tenantGroupsMutex sync.RWMutex
+	// tenantGroupCollections holds the group memberships of each tenant
+	tenantGroupCollections = make(map[string]*security.GroupCollection)
+)
+
+// tenantGroups returns the GroupCollection that holds the group memberships
+// of the users of the given tenant. It is security.Registry for the default
+// database.
+//
+// The groups themselves are shared by all tenants and must be looked up in
+// security.Registry. Until the memberships of a tenant are loaded, the
+// superuser is the only member of a group.
+func tenantGroups(tenant string) *security.GroupCollection {
+	if tenant == "" {
+		return security.Registry
+	}
+	tenantGroupsMutex.RLock()
+	gc, ok := tenantGroupCollections[tenant]
+	tenantGroupsMutex.RUnlock()
+	if ok {
+		return gc
+	}
+	tenantGroupsMutex.Lock()
+	defer tenantGroupsMutex.Unlock()
+	if gc, ok = tenantGroupCollections[tenant]; ok {
+		return gc
+	}
+	gc = security.NewGroupCollection()
+	gc.AddMembership(security.SuperUserID, security.GroupAdmin)
+	tenantGroupCollections[tenant] = gc
+	return gc
+}
+
+// forgetTenantGroups removes the group memberships of the given tenant
+func forgetTenantGroups(tenant string) {
+	tenantGroupsMutex.Lock()
+	delete(tenantGroupCollections, tenant)
+	tenantGroupsMutex.Unlock()
+	groupsMutex.Lock()
+	delete(persistedMemberships, tenant)
+	groupsMutex.Unlock()
+}
+
+// UserGroups returns the groups the user of this Environment belongs to
+// in the database of its tenant, including inherited groups.
+//
+// Use it instead of security.Registry, which only holds the memberships
+// of the default database.
+func (env Environment) UserGroups() map[*security.Group]security.InheritanceInfo {
+	return tenantGroups(env.tenant).UserGroups(env.uid)
+}
+
+// declareGroupModels declares the system models
+// of security groups and of their memberships.
+func declareGroupModels() {
+	group := CreateModel(groupModelName, SystemModel)
+	group.created = true
+	group.InheritModel(Registry.MustGet("CommonMixin"))
+	group.fields.add(&Field{
+		model:       group,
+		name:        "GroupID",
+		description: "Group ID",
+		json:        "group_id",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		required:    true,
+		unique:      true,
+		index:       true,
+	})
+	group.fields.add(&Field{
+		model:       group,
+		name:        "Name",
+		description: "Name",
+		json:        "name",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+
+	membership := CreateModel(groupMembershipModelName, SystemModel)
+	membership.created = true
+	membership.InheritModel(Registry.MustGet("CommonMixin"))
+	membership.fields.add(&Field{
+		model:       membership,
+		name:        "UserID",
+		description: "User ID",
+		json:        "user_id",
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		required:    true,
+		index:       true,
+	})
+	membership.fields.add(&Field{
+		model:            membership,
+		name:             "Group",
+		description:      "Group",
+		json:             "group_id",
+		fieldType:        fieldtype.Many2One,
+		structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		relatedModelName: groupModelName,
+		onDelete:         Cascade,
+		required:         true,
+		index:            true,
+	})
+
+	for _, model := range []*Model{group, membership} {
+		model.Events().AfterCommit(func(event Event) {
+			reloadGroupMemberships(event.Tenant)
+			notifyGroupsChanged(event.Tenant)
+		})
+	}
+}
+
+// groupRecord returns the record of the given group in the database
+// of the given Environment, creating it if it does not exist.
+func groupRecord(env Environment, group *security.Group) *RecordCollection {
+	groupModel := Registry.MustGet(groupModelName)
+	rec := env.Pool(groupModelName).Sudo().Search(groupModel.Field(groupModel.FieldName("GroupID")).Equals(group.ID()))
+	if !rec.IsEmpty() {
+		return rec
+	}
+	return env.Pool(groupModelName).Sudo().Call("Create", NewModelData(groupModel).
+		Set(groupModel.FieldName("GroupID"), group.ID()).
+		Set(groupModel.FieldName("Name"), group.Name())).(RecordSet).Collection()
+}
+
+// userMemberships returns the persisted memberships of the user
+// with the given uid in the database of the given Environment.
+func userMemberships(env Environment, uid int64) *RecordCollection {
+	membershipModel := Registry.MustGet(groupMembershipModelName)
+	return env.Pool(groupMembershipModelName).Sudo().Search(
+		membershipModel.Field(membershipModel.FieldName("UserID")).Equals(uid))
+}
+
+// AddGroupMembership persists the membership of the user with the given
+// uid in the given group in the transaction of the given Environment.
+//
+// The user is added to the group in the memberships of the tenant of the
+// Environment when the transaction is committed, both in this process and
+// in the other processes connected to the same database.
+func AddGroupMembership(env Environment, uid int64, group *security.Group) {
+	membershipModel := Registry.MustGet(groupMembershipModelName)
+	grpRec := groupRecord(env, group)
+	existing := env.Pool(groupMembershipModelName).Sudo().Search(
+		membershipModel.Field(membershipModel.FieldName("UserID")).Equals(uid).
+			And().Field(membershipModel.FieldName("Group")).Equals(grpRec))
+	if !existing.IsEmpty() {
+		return
+	}
+	env.Pool(groupMembershipModelName).Sudo().Call("Create", NewModelData(membershipModel).
+		Set(membershipModel.FieldName("UserID"), uid).
+		Set(membershipModel.FieldName("Group"), grpRec))
+}
+
+// RemoveGroupMembership removes the persisted membership of the user with
+// the given uid in the given group in the transaction of the given Environment.
+//
+// The user is removed from the group in the memberships of the tenant of
+// the Environment when the transaction is committed, both in this process
+// and in the other processes connected to the same database.
+func RemoveGroupMembership(env Environment, uid int64, group *security.Group) {
+	membershipModel := Registry.MustGet(groupMembershipModelName)
+	env.Pool(groupMembershipModelName).Sudo().Search(
+		membershipModel.Field(membershipModel.FieldName("UserID")).Equals(uid).
+			And().Field(membershipModel.FieldName("Group.GroupID")).Equals(group.ID())).
+		Call("Unlink")
+}
+
+// SetGroupMemberships replaces the persisted memberships of the user with
+// the given uid by the given groups in the transaction of the given Environment.
+//
+// The memberships of the tenant of the Environment are updated when the
+// transaction is committed, both in this process and in the other processes
+// connected to the same database.
+func SetGroupMemberships(env Environment, uid int64, groups ...*security.Group) {
+	membershipModel := Registry.MustGet(groupMembershipModelName)
+	groupModel := Registry.MustGet(groupModelName)
+	keep := make(map[string]bool)
+	for _, group := range groups {
+		keep[group.ID()] = true
+	}
+	memberships := userMemberships(env, uid)
+	for _, rec := range memberships.Records() {
+		groupID := rec.Get(membershipModel.FieldName("Group")).(RecordSet).Collection().Get(groupModel.FieldName("GroupID")).(string)
+		if keep[groupID] {
+			delete(keep, groupID)
+			continue
+		}
+		rec.Call("Unlink")
+	}
+	for _, group := range groups {
+		if keep[group.ID()] {
+			AddGroupMembership(env, uid, group)
+		}
+	}
+}
+
+// ReloadGroups registers the groups of security.Registry in the
+// database and loads the persisted group memberships into it.
+//
+// It is called automatically at bootstrap and after the database
+// has been synchronized, and it does nothing if the tables of the
+// group models have not been created yet.
+func ReloadGroups() {
+	if db == nil {
+		// Happens when bootstrapping models without DB for tests
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	dbTables := adapter.tables()
+	if !dbTables[Registry.MustGet(groupModelName).tableName] || !dbTables[Registry.MustGet(groupMembershipModelName).tableName] {
+		return
+	}
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		groupModel := Registry.MustGet(groupModelName)
+		for _, group := range security.Registry.AllGroups() {
+			rec := groupRecord(env, group)
+			if rec.Get(groupModel.FieldName("Name")) != group.Name() {
+				rec.Set(groupModel.FieldName("Name"), group.Name())
+			}
+		}
+	})
+	if err != nil {
+		log.Warn("Unable to register groups in database", "error", err)
+	}
+	reloadGroupMemberships("")
+	listenGroupsChanges()
+}
+
+// reloadTenantsGroupMemberships loads the group memberships persisted
+// in the databases of all the loaded tenants.
+func reloadTenantsGroupMemberships() {
+	for _, tenant := range loadedTenants() {
+		if tenant == "" {
+			continue
+		}
+		reloadGroupMemberships(tenant)
+	}
+}
+
+// reloadGroupMemberships loads the group memberships persisted in the
+// database of the given tenant into the memberships of this tenant
+// (see tenantGroups).
+//
+// Memberships that have been added directly to the memberships of the
+// tenant are kept, unless they have been persisted and removed from the
+// database since.
+func reloadGroupMemberships(tenant string) {
+	var rows []struct {
+		UserID  int64  `db:"user_id"`
+		GroupID string `db:"group_id"`
+	}
+	err := ExecuteInTenantEnvironment(tenant, security.SuperUserID, func(env Environment) {
+		adapter := adapters[db.DriverName()]
+		env.Cr().Select(&rows, fmt.Sprintf(`
+			SELECT m.user_id, g.group_id
+			FROM %s m
+			JOIN %s g ON g.id = m.group_id`,
+			adapter.quoteTableName(Registry.MustGet(groupMembershipModelName).tableName),
+			adapter.quoteTableName(Registry.MustGet(groupModelName).tableName)))
+	})
+	if err != nil {
+		log.Warn("Unable to load group memberships", "tenant", tenant, "error", err)
+		return
+	}
+	memberships := make(map[int64]map[string]bool)
+	for _, row := range rows {
+		if memberships[row.UserID] == nil {
+			memberships[row.UserID] = make(map[string]bool)
+		}
+		memberships[row.UserID][row.GroupID] = true
+	}
+	groups := tenantGroups(tenant)
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+	for uid, groupIDs := range persistedMemberships[tenant] {
+		for groupID := range groupIDs {
+			if memberships[uid][groupID] {
+				continue
+			}
+			if group := security.Registry.GetGroup(groupID); group != nil {
+				groups.RemoveMembership(uid, group)
+			}
+		}
+	}
+	for uid, groupIDs := range memberships {
+		for groupID := range groupIDs {
+			if persistedMemberships[tenant][uid][groupID] {
+				continue
+			}
+			group := security.Registry.GetGroup(groupID)
+			if group == nil {
+				log.Debug("Skipping membership of unknown group", "tenant", tenant, "uid", uid, "group", groupID)
+				continue
+			}
+			groups.AddMembership(uid, group)
+		}
+	}
+	persistedMemberships[tenant] = memberships
+}
+
+// notifyGroupsChanged notifies the other processes that the group
+// memberships of the given tenant have changed.
+//
+// Notifications are always sent on the default database, which
+// is the one the other processes listen to.
+func notifyGroupsChanged(tenant string) {
+	if groupsListener == nil {
+		return
+	}
+	payload, _ := json.Marshal(groupsNotification{Origin: sharedCacheOrigin, Tenant: tenant})
+	adapter := adapters[db.DriverName()]
+	if _, err := db.Exec(db.Rebind(adapter.notifySQL()), groupsChannel, string(payload)); err != nil {
+		log.Warn("Unable to notify group memberships change", "error", err)
+	}
+}
+
+// handleGroupsNotification reloads the group memberships when
+// they have been changed by another process.
+func handleGroupsNotification(payload string) {
+	if payload != "" {
+		var notif groupsNotification
+		if err := json.Unmarshal([]byte(payload), &notif); err != nil {
+			log.Warn("Invalid group memberships notification", "payload", payload, "error", err)
+			return
+		}
+		if notif.Origin == sharedCacheOrigin {
+			return
+		}
+		if notif.Tenant != "" && !TenantExists(notif.Tenant) {
+			return
+		}
+		reloadGroupMemberships(notif.Tenant)
+		return
+	}
+	// An empty payload means that notifications may have been lost
+	reloadGroupMemberships("")
+	reloadTenantsGroupMemberships()
+}
+
+// listenGroupsChanges starts listening to the changes
+// of group memberships made by the other processes.
+func listenGroupsChanges() {
+	if db == nil || groupsListener != nil {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	listener, err := adapter.listen(connParams, groupsChannel, handleGroupsNotification)
+	if err != nil {
+		log.Panic("Unable to listen to group memberships changes", "error", err)
+	}
+	groupsListener = listener
+	log.Info("Listening to group memberships changes", "channel", groupsChannel)
+}
+
+// stopGroupsListener stops listening to the changes of group memberships
+func stopGroupsListener() {
+	if groupsListener == nil {
+		return
+	}
+	err := groupsListener.Close()
+	log.Info("Stopped listening to group memberships changes", "error", err)
+	groupsListener = nil
+}
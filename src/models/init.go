@@ -44,4 +44,6 @@ func init() {
 	declareCommonMixin()
 	declareBaseMixin()
 	declareModelMixin()
+	declareWebhookModels()
+	declareGroupModels()
 }
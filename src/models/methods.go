@@ -33,6 +33,7 @@ var unauthorizedMethods = map[string]bool{
 type MethodsCollection struct {
 	model        *Model
 	registry     map[string]*Method
+	middlewares  []MethodMiddleware
 	bootstrapped bool
 }
 
@@ -139,6 +140,7 @@ type Method struct {
 	nextLayer     map[*methodLayer]*methodLayer
 	groups        map[*security.Group]bool
 	groupsCallers map[callerGroup]bool
+	middlewares   []MethodMiddleware
 }
 
 // MethodType returns the methodType of a Method
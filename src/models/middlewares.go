@@ -0,0 +1,116 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sync"
+)
+
+// A MethodCall is a call to a model method as seen by method middlewares.
+type MethodCall struct {
+	// Method is the called method
+	Method *Method
+	// RecordSet is the RecordSet on which the method is called
+	RecordSet RecordSet
+	// Args are the arguments of the call. Middlewares may change
+	// them before passing the call to the next handler.
+	Args []interface{}
+}
+
+// ModelName returns the name of the model of the called method
+func (mc *MethodCall) ModelName() string {
+	return mc.Method.model.name
+}
+
+// MethodName returns the name of the called method
+func (mc *MethodCall) MethodName() string {
+	return mc.Method.name
+}
+
+// A MethodHandler executes a method call and returns its results
+type MethodHandler func(call *MethodCall) []interface{}
+
+// A MethodMiddleware wraps the calls to model methods. It must call next
+// to execute the method, unless it returns results by itself (e.g. from a
+// cache), in which case the method is not executed.
+type MethodMiddleware func(call *MethodCall, next MethodHandler) []interface{}
+
+var (
+	methodMiddlewaresMutex sync.RWMutex
+	methodMiddlewares      []MethodMiddleware
+)
+
+// RegisterMethodMiddleware registers the given middleware to wrap the
+// calls to all methods of all models.
+//
+// Global middlewares wrap the middlewares of models and of methods, and
+// middlewares registered first wrap those registered after them.
+func RegisterMethodMiddleware(middleware MethodMiddleware) {
+	methodMiddlewaresMutex.Lock()
+	defer methodMiddlewaresMutex.Unlock()
+	methodMiddlewares = append(methodMiddlewares, middleware)
+}
+
+// AddMiddleware registers the given middleware to wrap the calls
+// to all the methods of this collection's model.
+func (mc *MethodsCollection) AddMiddleware(middleware MethodMiddleware) {
+	methodMiddlewaresMutex.Lock()
+	defer methodMiddlewaresMutex.Unlock()
+	mc.middlewares = append(mc.middlewares, middleware)
+}
+
+// AddMiddleware registers the given middleware to wrap the calls to this method.
+//
+// The middleware only applies to this method on this model and not to
+// the models that inherit it through a mixin.
+func (m *Method) AddMiddleware(middleware MethodMiddleware) *Method {
+	methodMiddlewaresMutex.Lock()
+	defer methodMiddlewaresMutex.Unlock()
+	m.middlewares = append(m.middlewares, middleware)
+	return m
+}
+
+// middlewaresFor returns the middlewares that wrap the calls
+// of the given method, from the outermost to the innermost.
+func middlewaresFor(method *Method) []MethodMiddleware {
+	methodMiddlewaresMutex.RLock()
+	defer methodMiddlewaresMutex.RUnlock()
+	modelMiddlewares := method.model.methods.middlewares
+	total := len(methodMiddlewares) + len(modelMiddlewares) + len(method.middlewares)
+	if total == 0 {
+		return nil
+	}
+	res := make([]MethodMiddleware, 0, total)
+	res = append(res, methodMiddlewares...)
+	res = append(res, modelMiddlewares...)
+	res = append(res, method.middlewares...)
+	return res
+}
+
+// callWithMiddlewares calls the given method layer on this RecordCollection
+// through the middlewares of the method.
+//
+// Execution permission is checked before calling the middlewares, so that
+// they cannot return results to users not allowed to execute the method.
+func (rc *RecordCollection) callWithMiddlewares(methLayer *methodLayer, args ...interface{}) []interface{} {
+	middlewares := middlewaresFor(methLayer.method)
+	if len(middlewares) == 0 {
+		return rc.callMulti(methLayer, args...)
+	}
+	rc.CheckExecutionPermission(methLayer.method)
+	handler := func(call *MethodCall) []interface{} {
+		return call.RecordSet.Collection().callMulti(methLayer, call.Args...)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middleware, next := middlewares[i], handler
+		handler = func(call *MethodCall) []interface{} {
+			return middleware(call, next)
+		}
+	}
+	return handler(&MethodCall{
+		Method:    methLayer.method,
+		RecordSet: rc,
+		Args:      args,
+	})
+}
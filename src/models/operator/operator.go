@@ -23,6 +23,7 @@ const (
 	In             Operator = "in"
 	NotIn          Operator = "not in"
 	ChildOf        Operator = "child_of"
+	Overlaps       Operator = "overlaps"
 )
 
 var allowedOperators = map[Operator]bool{
@@ -41,6 +42,7 @@ var allowedOperators = map[Operator]bool{
 	In:             true,
 	NotIn:          true,
 	ChildOf:        true,
+	Overlaps:       true,
 }
 
 var negativeOperators = map[Operator]bool{
@@ -57,6 +59,7 @@ var positiveOperators = map[Operator]bool{
 	Contains:  true,
 	Like:      true,
 	In:        true,
+	Overlaps:  true,
 }
 
 var multiOperator = map[Operator]bool{
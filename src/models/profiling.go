@@ -0,0 +1,258 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowCallSummarySize is the number of methods detailed
+// in the log line of a slow method call
+const slowCallSummarySize = 5
+
+var (
+	// profilingEnabled is true if model method calls are profiled
+	profilingEnabled bool
+	// slowCallThreshold is the duration above which method calls are logged
+	slowCallThreshold time.Duration
+	// globalProfile holds the statistics of all profiled method calls
+	globalProfile = NewProfile()
+)
+
+// MethodStats holds the profiling statistics of a model method
+type MethodStats struct {
+	Model  string `json:"model"`
+	Method string `json:"method"`
+	// Calls is the number of calls to the method
+	Calls int64 `json:"calls"`
+	// TotalTime is the cumulative duration of the calls,
+	// including the duration of the methods they call.
+	TotalTime time.Duration `json:"total_time"`
+	// ExclusiveTime is the duration of the calls excluding
+	// the duration of the methods they call.
+	ExclusiveTime time.Duration `json:"exclusive_time"`
+	// Queries is the number of SQL queries executed during
+	// the calls, including by the methods they call.
+	Queries int64 `json:"queries"`
+	// ExclusiveQueries is the number of SQL queries executed
+	// by the methods themselves.
+	ExclusiveQueries int64 `json:"exclusive_queries"`
+}
+
+// String returns a short summary of these MethodStats
+func (ms MethodStats) String() string {
+	return fmt.Sprintf("%s.%s(calls=%d, total=%s, exclusive=%s, queries=%d)",
+		ms.Model, ms.Method, ms.Calls, ms.TotalTime, ms.ExclusiveTime, ms.Queries)
+}
+
+// add adds the given call statistics to these MethodStats
+func (ms *MethodStats) add(total, exclusive time.Duration, queries, exclusiveQueries int64) {
+	ms.Calls++
+	ms.TotalTime += total
+	ms.ExclusiveTime += exclusive
+	ms.Queries += queries
+	ms.ExclusiveQueries += exclusiveQueries
+}
+
+// A methodKey identifies a model method in a Profile
+type methodKey struct {
+	model  string
+	method string
+}
+
+// A Profile collects the statistics of model method calls, for instance
+// those of the Environments of an HTTP request.
+type Profile struct {
+	sync.Mutex
+	stats map[methodKey]*MethodStats
+}
+
+// NewProfile returns a pointer to a new empty Profile
+func NewProfile() *Profile {
+	return &Profile{
+		stats: make(map[methodKey]*MethodStats),
+	}
+}
+
+// add adds the given call statistics of the given method to this Profile
+func (p *Profile) add(method *Method, total, exclusive time.Duration, queries, exclusiveQueries int64) {
+	p.Lock()
+	defer p.Unlock()
+	key := methodKey{model: method.model.name, method: method.name}
+	ms, ok := p.stats[key]
+	if !ok {
+		ms = &MethodStats{Model: key.model, Method: key.method}
+		p.stats[key] = ms
+	}
+	ms.add(total, exclusive, queries, exclusiveQueries)
+}
+
+// Stats returns the statistics of the methods of this Profile
+// sorted by decreasing exclusive time.
+func (p *Profile) Stats() []MethodStats {
+	p.Lock()
+	defer p.Unlock()
+	res := make([]MethodStats, 0, len(p.stats))
+	for _, ms := range p.stats {
+		res = append(res, *ms)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ExclusiveTime != res[j].ExclusiveTime {
+			return res[i].ExclusiveTime > res[j].ExclusiveTime
+		}
+		return res[i].Model+"."+res[i].Method < res[j].Model+"."+res[j].Method
+	})
+	return res
+}
+
+// Reset removes all statistics from this Profile
+func (p *Profile) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.stats = make(map[methodKey]*MethodStats)
+}
+
+// Summary returns a one line summary of the n methods
+// with the highest exclusive time of this Profile.
+func (p *Profile) Summary(n int) string {
+	stats := p.Stats()
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	parts := make([]string, len(stats))
+	for i, ms := range stats {
+		parts[i] = ms.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// profileContextKey is the key of the Profile in a context.Context
+type profileContextKey struct{}
+
+// ContextWithProfile returns a copy of the given context in which the method
+// calls of the Environments created with this context are collected in the
+// given Profile.
+func ContextWithProfile(ctx context.Context, profile *Profile) context.Context {
+	return context.WithValue(ctx, profileContextKey{}, profile)
+}
+
+// ProfileFromContext returns the Profile of the given context or nil
+func ProfileFromContext(ctx context.Context) *Profile {
+	profile, _ := ctx.Value(profileContextKey{}).(*Profile)
+	return profile
+}
+
+// EnableProfiling enables the profiling of model method calls.
+//
+// Calls that last longer than slowThreshold are logged with a summary
+// of the methods they called. Slow calls are not logged if slowThreshold
+// is 0.
+func EnableProfiling(slowThreshold time.Duration) {
+	profilingEnabled = true
+	slowCallThreshold = slowThreshold
+}
+
+// DisableProfiling disables the profiling of model method calls
+func DisableProfiling() {
+	profilingEnabled = false
+	slowCallThreshold = 0
+}
+
+// ProfilingEnabled returns true if model method calls are profiled
+func ProfilingEnabled() bool {
+	return profilingEnabled
+}
+
+// MethodStatistics returns the statistics of all the method calls profiled
+// since profiling has been enabled or ResetMethodStatistics was called,
+// sorted by decreasing exclusive time.
+func MethodStatistics() []MethodStats {
+	return globalProfile.Stats()
+}
+
+// ResetMethodStatistics removes all the method calls statistics
+func ResetMethodStatistics() {
+	globalProfile.Reset()
+}
+
+// A profileFrame holds the data of a profiled method call in progress
+type profileFrame struct {
+	method       *Method
+	start        time.Time
+	startQueries int64
+	childTime    time.Duration
+	childQueries int64
+}
+
+// An envProfiler profiles the method calls of an Environment
+type envProfiler struct {
+	stack []*profileFrame
+	// profile is the Profile of the context of the Environment, if any
+	profile *Profile
+	// current holds the statistics of the top level call in progress
+	current *Profile
+}
+
+// newEnvProfiler returns a new envProfiler for an Environment
+// created with the given context, or nil if profiling is disabled.
+func newEnvProfiler(ctx context.Context) *envProfiler {
+	if !profilingEnabled {
+		return nil
+	}
+	return &envProfiler{
+		profile: ProfileFromContext(ctx),
+	}
+}
+
+// startCall starts profiling a call to the given method in the given
+// Environment. It returns the function to call when the call returns.
+func (ep *envProfiler) startCall(env *Environment, method *Method) func() {
+	if len(ep.stack) == 0 && slowCallThreshold > 0 {
+		ep.current = NewProfile()
+	}
+	frame := &profileFrame{
+		method:       method,
+		start:        time.Now(),
+		startQueries: env.cr.queries,
+	}
+	ep.stack = append(ep.stack, frame)
+	return func() {
+		ep.endCall(env, frame)
+	}
+}
+
+// endCall ends profiling the call of the given frame
+func (ep *envProfiler) endCall(env *Environment, frame *profileFrame) {
+	total := time.Since(frame.start)
+	queries := env.cr.queries - frame.startQueries
+	exclusive := total - frame.childTime
+	exclusiveQueries := queries - frame.childQueries
+	ep.stack = ep.stack[:len(ep.stack)-1]
+	if len(ep.stack) > 0 {
+		parent := ep.stack[len(ep.stack)-1]
+		parent.childTime += total
+		parent.childQueries += queries
+	}
+	globalProfile.add(frame.method, total, exclusive, queries, exclusiveQueries)
+	if ep.profile != nil {
+		ep.profile.add(frame.method, total, exclusive, queries, exclusiveQueries)
+	}
+	if ep.current == nil {
+		return
+	}
+	ep.current.add(frame.method, total, exclusive, queries, exclusiveQueries)
+	if len(ep.stack) > 0 {
+		return
+	}
+	if total >= slowCallThreshold {
+		log.Warn("Slow method call", "model", frame.method.model.name, "method", frame.method.name,
+			"uid", env.uid, "duration", total, "queries", queries, "summary", ep.current.Summary(slowCallSummarySize))
+	}
+	ep.current = nil
+}
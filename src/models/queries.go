@@ -19,11 +19,14 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gleke/hexya/src/models/fieldtype"
 	"github.com/gleke/hexya/src/models/operator"
+	"github.com/gleke/hexya/src/models/types/dates"
 	"github.com/gleke/hexya/src/tools/nbutils"
 	"github.com/gleke/hexya/src/tools/strutils"
+	"github.com/gleke/hexya/src/tools/typesutils"
 )
 
 const maxSQLidentifierLength = 63
@@ -61,6 +64,17 @@ type Query struct {
 	ctxGroups []FieldName
 	orders    []orderPredicate
 	ctxOrders []orderPredicate
+	dateParts map[string]string
+}
+
+// datePartPeriods gives for each date part that can be used in
+// GroupByDatePart the years, months and days of its period.
+var datePartPeriods = map[string][3]int{
+	"day":     {0, 0, 1},
+	"week":    {0, 0, 7},
+	"month":   {0, 1, 0},
+	"quarter": {0, 3, 0},
+	"year":    {1, 0, 0},
 }
 
 // clone returns a pointer to a deep copy of this Query
@@ -162,6 +176,15 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 
 	adapter := adapters[db.DriverName()]
 	arg := q.evaluateConditionArgFunctions(p)
+	if p.operator == operator.Overlaps && !fi.fieldType.IsArrayType() {
+		log.Panic("Overlaps operator can only be used on array fields", "model", fi.model.name, "field", fi.name, "type", fi.fieldType)
+	}
+	if fi.fieldType.IsArrayType() && arg != nil {
+		return arrayPredicateSQLClause(field, p.operator, arg, fi)
+	}
+	if fi.encrypted && !typesutils.IsZero(arg) {
+		return encryptedPredicateSQLClause(field, p.operator, arg, fi)
+	}
 	opSql, arg := adapter.operatorSQL(p.operator, arg)
 
 	var isNull bool
@@ -176,6 +199,10 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		if !v {
 			isNull = true
 		}
+	case dates.Time:
+		if v.IsZero() {
+			isNull = true
+		}
 	}
 	if isNull {
 		return nullSQLClause(field, p.operator, fi)
@@ -190,24 +217,76 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 	return sql, args
 }
 
+// arrayPredicateSQLClause returns the sql string and arguments for searching
+// the given array field with the given operator and non nil argument.
+//
+// arg can be either a single value or a slice of values.
+func arrayPredicateSQLClause(field string, op operator.Operator, arg interface{}, fi *Field) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	val := reflect.ValueOf(arg)
+	if val.Kind() != reflect.Slice {
+		val = reflect.Append(reflect.MakeSlice(reflect.SliceOf(val.Type()), 0, 1), val)
+	}
+	values := reflect.New(fi.fieldType.DefaultGoType())
+	if err := typesutils.Convert(val.Interface(), values.Interface(), false); err != nil {
+		log.Panic("Unable to convert argument for array field", "field", fi.name, "arg", arg, "error", err)
+	}
+	if values.Elem().Len() == 0 && (op == operator.Equals || op == operator.NotEquals) {
+		return nullSQLClause(field, op, fi)
+	}
+	opSql, sqlArg := adapter.arrayOperatorSQL(op, values.Elem().Interface())
+	sql := fmt.Sprintf(`%s %s`, field, opSql)
+	if op.IsNegative() {
+		sql = fmt.Sprintf(`(%s IS NULL OR %s)`, field, sql)
+	}
+	return sql, SQLParams{sqlArg}
+}
+
+// encryptedPredicateSQLClause returns the sql string and arguments for searching
+// the given encrypted field with the given operator and non empty argument.
+//
+// Only equality operators are supported, since values are compared encrypted.
+func encryptedPredicateSQLClause(field string, op operator.Operator, arg interface{}, fi *Field) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	var sqlOp operator.Operator
+	switch op {
+	case operator.Equals, operator.In:
+		sqlOp = operator.In
+	case operator.NotEquals, operator.NotIn:
+		sqlOp = operator.NotIn
+	default:
+		log.Panic("Encrypted fields can only be searched by equality", "model", fi.model.name, "field", fi.name, "operator", op)
+	}
+	opSql, sqlArg := adapter.operatorSQL(sqlOp, encryptedSearchValues(fi, arg))
+	sql := fmt.Sprintf(`%s %s`, field, opSql)
+	if sqlOp.IsNegative() {
+		sql = fmt.Sprintf(`(%s IS NULL OR %s)`, field, sql)
+	}
+	return sql, SQLParams{sqlArg}
+}
+
 //nullSQLClause returns the sql string and arguments for searching the given field with an empty argument
 func nullSQLClause(field string, op operator.Operator, fi *Field) (string, SQLParams) {
 	var (
 		sql  string
 		args SQLParams
 	)
+	zero := reflect.Zero(fi.fieldType.DefaultGoType()).Interface()
+	if fi.fieldType.IsArrayType() {
+		zero = adapters[db.DriverName()].arrayArg(reflect.MakeSlice(fi.fieldType.DefaultGoType(), 0, 0).Interface())
+	}
 	switch op {
 	case operator.Equals, operator.Like, operator.ILike, operator.Contains, operator.IContains:
 		sql = fmt.Sprintf(`%s IS NULL`, field)
 		if !fi.isRelationField() {
 			sql = fmt.Sprintf(`(%s OR %s = ?)`, sql, field)
-			args = SQLParams{reflect.Zero(fi.fieldType.DefaultGoType()).Interface()}
+			args = SQLParams{zero}
 		}
 	case operator.NotEquals, operator.NotContains, operator.NotIContains:
 		sql = fmt.Sprintf(`%s IS NOT NULL`, field)
 		if !fi.isRelationField() {
 			sql = fmt.Sprintf(`(%s AND %s != ?)`, sql, field)
-			args = SQLParams{reflect.Zero(fi.fieldType.DefaultGoType()).Interface()}
+			args = SQLParams{zero}
 		}
 	default:
 		log.Panic("Null argument can only be used with = and != operators", "operator", op)
@@ -297,6 +376,7 @@ func (q *Query) sqlGroupByClause() string {
 	resSlice := make([]string, len(q.groups))
 	for i, field := range fExprs {
 		_, _, resSlice[i] = q.joinedFieldExpression(field, true, i)
+		resSlice[i] = q.groupDatePartSQL(q.groups[i], resSlice[i])
 	}
 	res := strings.Join(resSlice, ", ")
 	ctxStr := strings.TrimSpace(q.sqlCtxGroupByClause())
@@ -351,6 +431,12 @@ func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
 				continue
 			}
 		}
+		switch {
+		case fi.fieldType.IsArrayType():
+			v = adapter.arrayArg(v)
+		case fi.encrypted:
+			v = encryptFieldValue(fi, v)
+		}
 		cols = append(cols, fi.json)
 		vals = append(vals, v)
 		i++
@@ -503,6 +589,12 @@ func (q *Query) updateQuery(data FieldMap) (string, SQLParams) {
 	)
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
+		switch {
+		case fi.fieldType.IsArrayType():
+			v = adapter.arrayArg(v)
+		case fi.encrypted:
+			v = encryptFieldValue(fi, v)
+		}
 		cols[i] = fmt.Sprintf("%s = ?", fi.json)
 		vals[i] = v
 		i++
@@ -542,6 +634,9 @@ func (q *Query) fieldsGroupSQL(fieldExprs [][]FieldName, aggFncts map[string]str
 		aggFnct := aggFncts[joinFieldNames(exprs, ExprSep).JSON()]
 		if aggFnct == "" {
 			fStr[i] = joinFieldNames(exprs, sqlSep).JSON()
+			if truncSQL := q.groupDatePartSQL(joinFieldNames(exprs, ExprSep), fStr[i]); truncSQL != fStr[i] {
+				fStr[i] = fmt.Sprintf("%s AS %s", truncSQL, fStr[i])
+			}
 			continue
 		}
 		fStr[i] = fmt.Sprintf("%s(%s) AS %s", aggFnct, joinFieldNames(exprs, sqlSep).JSON(), joinFieldNames(exprs, sqlSep).JSON())
@@ -549,6 +644,53 @@ func (q *Query) fieldsGroupSQL(fieldExprs [][]FieldName, aggFncts map[string]str
 	return strings.Join(fStr, ", ")
 }
 
+// groupCondition returns the condition to retrieve the individual aggregated rows in vals
+// knowing that they were grouped by groups and that we had the given initial condition.
+//
+// Groups by date part are given as a range from the start to the end of their period.
+func (q *Query) groupCondition(groups []FieldName, vals map[string]interface{}, initialCondition *Condition) *Condition {
+	res := initialCondition
+	for _, group := range groups {
+		part, ok := q.dateParts[group.JSON()]
+		start, isTime := vals[group.JSON()].(time.Time)
+		if !ok || !isTime {
+			res = res.And().Field(group).Equals(vals[group.JSON()])
+			continue
+		}
+		period := datePartPeriods[part]
+		var startVal, endVal interface{}
+		if q.recordSet.model.getRelatedFieldInfo(group).fieldType == fieldtype.DateTime {
+			var startDT dates.DateTime
+			startDT.Scan(start)
+			startVal = startDT
+			endVal = startDT.In(q.recordSet.env.Timezone()).AddDate(period[0], period[1], period[2]).UTC()
+		} else {
+			var startD dates.Date
+			startD.Scan(start)
+			startVal = startD
+			endVal = startD.AddDate(period[0], period[1], period[2])
+		}
+		res = res.And().Field(group).GreaterOrEqual(startVal).And().Field(group).Lower(endVal)
+	}
+	return res
+}
+
+// groupDatePartSQL returns the SQL expression to group by the given column of the
+// given field. If the field is grouped by date part, the column is truncated to
+// this date part in the timezone of the environment. Otherwise, column is returned
+// unchanged.
+func (q *Query) groupDatePartSQL(field FieldName, column string) string {
+	part, ok := q.dateParts[field.JSON()]
+	if !ok {
+		return column
+	}
+	var tz string
+	if q.recordSet.model.getRelatedFieldInfo(field).fieldType == fieldtype.DateTime {
+		tz = q.recordSet.env.Timezone().String()
+	}
+	return adapters[db.DriverName()].dateTruncSQL(column, part, tz)
+}
+
 // joinedFieldExpression joins the given expressions into a fields sql string
 //     ['profile_id' 'user_id' 'name'] => "profiles__users".name
 //     ['age'] => "mytable".age
@@ -733,6 +875,7 @@ func (q *Query) substituteConditionExprs(substMap map[FieldName][]FieldName) {
 			}
 		}
 	}
+	dateParts := make(map[string]string)
 	for i, group := range q.groups {
 		for k, v := range substMap {
 			if group.JSON() == k.JSON() {
@@ -740,7 +883,11 @@ func (q *Query) substituteConditionExprs(substMap map[FieldName][]FieldName) {
 				break
 			}
 		}
+		if part, ok := q.dateParts[group.JSON()]; ok {
+			dateParts[q.groups[i].JSON()] = part
+		}
 	}
+	q.dateParts = dateParts
 }
 
 // evaluateConditionArgFunctions evaluates all args in the queries that are functions and
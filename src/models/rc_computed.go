@@ -112,9 +112,12 @@ func (rc *RecordCollection) updateStoredFields(compPairs []recomputePair) {
 }
 
 // applyMethod calls the method on this recordset.
+//
+// The method is called as superuser so that stored fields do not depend
+// on the fields that the user who triggered the computation can read.
 func (rc *RecordCollection) applyMethod(methodName string) {
 	for _, rec := range rc.Records() {
-		retVal := rec.Call(methodName)
+		retVal := rec.Sudo().Call(methodName)
 		data := retVal.(RecordData).Underlying()
 		// Check if the values actually changed
 		var doUpdate bool
@@ -19,7 +19,6 @@ import (
 	"reflect"
 	"time"
 
-	"github.com/gleke/hexya/src/models/security"
 	"github.com/gleke/hexya/src/tools/strutils"
 )
 
@@ -63,7 +62,16 @@ func (rc *RecordCollection) CallMulti(methName string, args ...interface{}) []in
 	if rc.env.currentLayer != nil && rc.env.currentLayer.method != methInfo {
 		rSet.env.previousMethod = rc.env.currentLayer.method
 	}
-	res := rSet.callMulti(methLayer, args...)
+	if rc.env.profiler != nil && !rc.env.super {
+		defer rc.env.profiler.startCall(rc.env, methInfo)()
+	}
+	var res []interface{}
+	if rc.env.super {
+		// Calls to Super are part of the call being wrapped by middlewares
+		res = rSet.callMulti(methLayer, args...)
+	} else {
+		res = rSet.callWithMiddlewares(methLayer, args...)
+	}
 	for i, r := range res {
 		switch r.(type) {
 		case RecordSet:
@@ -146,7 +154,7 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 		// We are calling Super on the same method, so it's ok
 		return true
 	}
-	userGroups := security.Registry.UserGroups(rc.env.uid)
+	userGroups := rc.env.UserGroups()
 	for group := range userGroups {
 		if method.groups[group] {
 			return true
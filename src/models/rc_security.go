@@ -23,24 +23,10 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 		return rc
 	}
 	rSet := rc
-	// Add global rules
-	for _, rule := range rSet.model.rulesRegistry.globalRules {
-		if perm&rule.Perms > 0 {
-			rSet = rSet.Search(rule.Condition)
-		}
-	}
-	// Add groups rules
-	userGroups := security.Registry.UserGroups(uid)
-	groupCondition := newCondition()
-	for group := range userGroups {
-		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[group.ID()] {
-			if perm&rule.Perms > 0 {
-				groupCondition = groupCondition.OrCond(rule.Condition)
-			}
-		}
-	}
-	if !groupCondition.IsEmpty() {
-		rSet = rSet.Search(groupCondition)
+	env := *rc.env
+	env.uid = uid
+	if cond := rc.model.recordRulesCondition(env, perm); !cond.IsEmpty() {
+		rSet = rSet.Search(cond)
 	}
 	rSet.filtered = true
 	*rc = *rSet
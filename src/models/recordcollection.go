@@ -154,6 +154,7 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 		}
 	}()
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	rc.checkWriteAccess(data.Underlying().FieldMap)
 	// process create data for FK relations if any
 	data = rc.createFKRelationRecords(data)
 
@@ -164,6 +165,7 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	rc.addAccessFieldsCreateData(&fMap)
 	fMap = rc.addEmbeddedfields(fMap)
 	rc.model.convertValuesToFieldType(&fMap, true)
+	rc.checkSelectionValues(fMap)
 	fMap = rc.addContextsFieldsValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
@@ -185,6 +187,8 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	rSet.processInverseMethods(data)
 	rSet.processTriggers(fMap.FieldNames(rSet.model))
 	rSet.CheckConstraints(data.Underlying().FieldNames())
+	rSet.checkCompanies(data.Underlying().FieldNames())
+	rSet.publishCreateEvent(data.Underlying().FieldNames())
 	return rSet
 }
 
@@ -348,8 +352,10 @@ func (rc *RecordCollection) CheckConstraints(fields FieldNames) {
 			methods[fi.constraint] = true
 		}
 	}
+	// Constraints are checked as superuser so that they see
+	// the fields that the current user cannot read.
 	for method := range methods {
-		for _, rec := range rc.Records() {
+		for _, rec := range rc.Sudo().Records() {
 			rec.Call(method)
 		}
 	}
@@ -373,6 +379,7 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	if !rc.hasNegIds && rc.ForceLoad(ID).IsEmpty() {
 		return true
 	}
+	rc.checkWriteAccess(data.Underlying().FieldMap)
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write)
 	// process create data for FK relations if any
 	data = rc.createFKRelationRecords(data)
@@ -383,6 +390,7 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	// We process inverse method before we convert RecordSets to ids
 	rSet.processInverseMethods(data)
 	rSet.model.convertValuesToFieldType(&fMap, true)
+	rSet.checkSelectionValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePK()
 	storedFieldMap := rSet.filterMapOnStoredFields(fMap)
@@ -398,9 +406,29 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	// compute stored fields
 	rSet.processTriggers(fMap.FieldNames(rSet.model))
 	rSet.CheckConstraints(data.Underlying().FieldNames())
+	rSet.checkCompanies(data.Underlying().FieldNames())
+	rSet.publishWriteEvent(data.Underlying().FieldNames())
 	return true
 }
 
+// checkSelectionValues panics if a value of a selection field in fMap
+// is not a key of the current selection of this field.
+func (rc *RecordCollection) checkSelectionValues(fMap FieldMap) {
+	for f, value := range fMap {
+		fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(f))
+		if fi.fieldType != fieldtype.Selection {
+			continue
+		}
+		val := reflect.ValueOf(value)
+		if val.Kind() != reflect.String || val.String() == "" {
+			continue
+		}
+		if _, ok := fi.currentSelection(rc.Env())[val.String()]; !ok {
+			log.Panic("Invalid value for selection field", "model", rc.model.name, "field", fi.name, "value", val.String())
+		}
+	}
+}
+
 // addAccessFieldsUpdateData adds appropriate WriteDate and WriteUID fields to
 // the given FieldMap.
 func (rc *RecordCollection) addAccessFieldsUpdateData(fMap *FieldMap) {
@@ -457,6 +485,7 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 		if num, _ := res.RowsAffected(); num == 0 {
 			log.Panic("Unexpected noop on update (num = 0)", "model", rc.ModelName(), "values", fMap, "query", query, "args", args)
 		}
+		rc.env.invalidateSharedCache(rc.model, rc.ids)
 	}
 	for _, rec := range rc.Records() {
 		for k, v := range fMap {
@@ -671,6 +700,7 @@ func (rc *RecordCollection) unlink() int64 {
 	if rSet.IsEmpty() {
 		return 0
 	}
+	rSet.publishUnlinkEvent()
 	// get recomputate data to update after unlinking
 	compData := rc.retrieveComputeData(rc.model.fields.allFieldNames())
 	var num int64
@@ -678,6 +708,7 @@ func (rc *RecordCollection) unlink() int64 {
 		query, args := rSet.query.deleteQuery()
 		res := rSet.env.cr.Execute(query, args...)
 		num, _ = res.RowsAffected()
+		rc.env.invalidateSharedCache(rc.model, ids)
 	}
 	for _, id := range ids {
 		rc.env.cache.invalidateRecord(rc.model, id)
@@ -732,6 +763,29 @@ func (rc *RecordCollection) GroupBy(fields ...FieldName) *RecordCollection {
 	return &rSet
 }
 
+// GroupByDatePart returns a new RecordSet grouped by the given Date or DateTime field
+// truncated to the given date part, which must be one of "day", "week", "month",
+// "quarter" or "year".
+//
+// DateTime fields are truncated in the timezone of the Environment, so that
+// records are grouped by the user's days and not by UTC days.
+func (rc *RecordCollection) GroupByDatePart(field FieldName, part string) *RecordCollection {
+	fi := rc.model.getRelatedFieldInfo(field)
+	if fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime {
+		log.Panic("Only Date and DateTime fields can be grouped by date part", "model", rc.model.name, "field", field)
+	}
+	if _, ok := datePartPeriods[part]; !ok {
+		log.Panic("Unknown date part", "model", rc.model.name, "field", field, "part", part)
+	}
+	rSet := rc.GroupBy(field)
+	dateParts := map[string]string{field.JSON(): part}
+	for f, p := range rc.query.dateParts {
+		dateParts[f] = p
+	}
+	rSet.query.dateParts = dateParts
+	return rSet
+}
+
 // Fetch query the database with the current filter and returns a RecordSet
 // with the queries ids.
 //
@@ -777,6 +831,7 @@ func (rc *RecordCollection) Load(fields ...FieldName) *RecordCollection {
 	if len(fields) == 0 {
 		fields = rc.model.fields.storedFieldNames()
 	}
+	fields = rc.filterReadableFields(fields)
 	cacheFields := make([]string, len(fields))
 	for i, v := range fields {
 		cacheFields[i] = v.JSON()
@@ -784,6 +839,9 @@ func (rc *RecordCollection) Load(fields ...FieldName) *RecordCollection {
 	if rc.env.cache.checkIfInCache(rc.model, rc.ids, cacheFields, rc.query.ctxArgsSlug(), true) {
 		return rc
 	}
+	if rc.loadFromSharedCache(cacheFields) {
+		return rc
+	}
 	return rc.ForceLoad(fields...)
 }
 
@@ -821,15 +879,22 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 	if len(fields) == 0 {
 		fields = rSet.model.fields.storedFieldNames()
 	}
+	fields = rSet.filterReadableFields(fields)
+	if len(fields) == 0 {
+		fields = []FieldName{ID}
+	}
 	addNameSearchesToCondition(rSet.model, rSet.query.cond)
 	rSet.applyContexts()
 	subFields, _ := rSet.substituteRelatedFields(fields)
 	rSet = rSet.substituteRelatedInQuery()
 	dbFields := filterOnDBFields(rSet.model, subFields)
 	query, args, substs := rSet.query.selectQuery(dbFields)
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := rSet.env.cr.query(query, args...)
 	defer rows.Close()
-	var ids []int64
+	var (
+		ids   []int64
+		lines []FieldMap
+	)
 	for rows.Next() {
 		line := make(FieldMap)
 		err := rSet.model.scanToFieldMap(rows, &line, substs)
@@ -838,7 +903,11 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 		}
 		rSet.env.cache.addRecord(rSet.model, line["id"].(int64), line, rc.query.ctxArgsSlug())
 		ids = append(ids, line["id"].(int64))
+		if rSet.model.HasSharedCache() {
+			lines = append(lines, line)
+		}
 	}
+	rSet.storeInSharedCache(lines)
 
 	rSet = rSet.withIds(ids)
 	rSet.loadRelationFields(subFields)
@@ -914,7 +983,7 @@ func (rc *RecordCollection) loadRelationFields(fields FieldNames) {
 // It returns the type's zero value if the RecordCollection is empty.
 func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 	fi := rc.model.getRelatedFieldInfo(fieldName)
-	if !rc.IsValid() {
+	if !rc.IsValid() || !rc.model.canReadPath(*rc.env, fieldName) {
 		res := reflect.Zero(fi.structField.Type).Interface()
 		if fi.isRelationField() {
 			res = rc.convertToRecordSet(res, fi.relatedModelName)
@@ -1055,6 +1124,8 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 	}
 	groups := make([]FieldName, len(rc.query.groups))
 	copy(groups, rc.query.groups)
+	rc.checkFieldsReadAccess("You are not allowed to group on this field", groups...)
+	rc.checkFieldsReadAccess("You are not allowed to aggregate this field", fieldNames...)
 
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
 	rSet.applyContexts()
@@ -1067,7 +1138,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 
 	query, args := rSet.query.selectGroupQuery(rSet.fieldsGroupOperators(dbFields))
 	var res []GroupAggregateRow
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := rSet.env.cr.query(query, args...)
 	defer rows.Close()
 
 	for rows.Next() {
@@ -1082,7 +1153,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 		line := GroupAggregateRow{
 			Values:    NewModelDataFromRS(rc, vals),
 			Count:     int(cnt),
-			Condition: getGroupCondition(groups, vals, rc.query.cond),
+			Condition: rSet.query.groupCondition(groups, vals, rc.query.cond),
 		}
 		res = append(res, line)
 	}
@@ -1144,7 +1215,7 @@ func (rc *RecordCollection) fieldsGroupOperators(fields []FieldName) ([]FieldNam
 			continue
 		}
 		fi := rc.model.getRelatedFieldInfo(dbf)
-		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Integer {
+		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Integer && fi.fieldType != fieldtype.Duration {
 			continue
 		}
 		res[dbf.JSON()] = fi.groupOperator
@@ -131,10 +131,16 @@ type Model struct {
 	methods         *MethodsCollection
 	mixins          []*Model
 	sqlConstraints  map[string]sqlConstraint
+	sqlIndexes      map[string]sqlIndex
 	sqlErrors       map[string]string
 	defaultOrderStr []string
 	defaultOrder    []orderPredicate
+	oldNames        []string
+	view            *ViewDefinition
+	sharedCacheSize int
+	events          *ModelEvents
 	created         bool
+	companyField    string
 }
 
 // An sqlConstraint holds the data needed to create a table constraint in the database
@@ -144,6 +150,15 @@ type sqlConstraint struct {
 	errorString string
 }
 
+// An sqlIndex holds the data needed to create an index declared on a model
+type sqlIndex struct {
+	name   string
+	exprs  []string
+	where  string
+	method string
+	unique bool
+}
+
 // Name returns the name of this model
 func (m *Model) Name() string {
 	return m.name
@@ -224,6 +239,9 @@ func (m *Model) scanToFieldMap(r sqlx.ColScanner, dest *FieldMap, substs map[str
 		}
 		colName = strings.Replace(colName, sqlSep, ExprSep, -1)
 		dbVal := reflect.ValueOf(dbValue).Elem().Interface()
+		if fi := m.getRelatedFieldInfo(m.FieldName(colName)); fi.encrypted {
+			dbVal = decryptFieldValue(fi, dbVal)
+		}
 		(*dest)[colName] = dbVal
 	}
 
@@ -248,7 +266,13 @@ func (m *Model) convertValuesToFieldType(fMap *FieldMap, writeDB bool) {
 		fi := m.getRelatedFieldInfo(m.FieldName(colName))
 		fType := fi.structField.Type
 		typedValue := reflect.New(fType).Interface()
-		err := typesutils.Convert(fMapValue, typedValue, fi.isRelationField())
+		var err error
+		if dbValue, ok := fMapValue.([]byte); ok && fi.fieldType.IsArrayType() {
+			// Array columns are returned by the DB as a single value
+			err = adapters[db.DriverName()].scanArray(dbValue, typedValue)
+		} else {
+			err = typesutils.Convert(fMapValue, typedValue, fi.isRelationField())
+		}
 		if err != nil {
 			log.Panic(err.Error(), "model", m.name, "field", colName, "type", fType, "value", fMapValue)
 		}
@@ -355,6 +379,13 @@ func (m *Model) SetDefaultOrder(orders ...string) {
 	m.defaultOrderStr = orders
 }
 
+// SetOldNames sets the previous names of this model, so that its
+// table is renamed instead of being recreated when synchronizing
+// the database.
+func (m *Model) SetOldNames(names ...string) {
+	m.oldNames = names
+}
+
 // ordersFromStrings returns the given order by exprs as a slice of order structs
 func (m *Model) ordersFromStrings(exprs []string) []orderPredicate {
 	res := make([]orderPredicate, len(exprs))
@@ -420,30 +451,32 @@ func (m *Model) FieldsGet(fields ...FieldName) map[string]*FieldInfo {
 			filter = fInfo.filter.Serialize()
 		}
 		_, translate := fInfo.contexts["lang"]
+		_, companyDependent := fInfo.contexts[companyContextName]
 		res[fInfo.json] = &FieldInfo{
-			Name:          fInfo.name,
-			JSON:          fInfo.json,
-			Help:          fInfo.help,
-			Searchable:    true,
-			Depends:       fInfo.depends,
-			Sortable:      true,
-			Type:          fInfo.fieldType,
-			Store:         fInfo.isSettable(),
-			String:        fInfo.description,
-			Relation:      relation,
-			Selection:     fInfo.selection,
-			Domain:        filter,
-			ReverseFK:     fInfo.jsonReverseFK,
-			OnChange:      fInfo.onChange != "",
-			Translate:     translate,
-			InvisibleFunc: fInfo.invisibleFunc,
-			ReadOnly:      fInfo.isReadOnly(),
-			ReadOnlyFunc:  fInfo.readOnlyFunc,
-			Required:      fInfo.required,
-			RequiredFunc:  fInfo.requiredFunc,
-			DefaultFunc:   fInfo.defaultFunc,
-			GoType:        fInfo.structField.Type,
-			Index:         fInfo.index,
+			Name:             fInfo.name,
+			JSON:             fInfo.json,
+			Help:             fInfo.help,
+			Searchable:       true,
+			Depends:          fInfo.depends,
+			Sortable:         true,
+			Type:             fInfo.fieldType,
+			Store:            fInfo.isSettable(),
+			String:           fInfo.description,
+			Relation:         relation,
+			Selection:        fInfo.selection,
+			Domain:           filter,
+			ReverseFK:        fInfo.jsonReverseFK,
+			OnChange:         fInfo.onChange != "",
+			Translate:        translate,
+			CompanyDependent: companyDependent,
+			InvisibleFunc:    fInfo.invisibleFunc,
+			ReadOnly:         fInfo.isReadOnly(),
+			ReadOnlyFunc:     fInfo.readOnlyFunc,
+			Required:         fInfo.required,
+			RequiredFunc:     fInfo.requiredFunc,
+			DefaultFunc:      fInfo.defaultFunc,
+			GoType:           fInfo.structField.Type,
+			Index:            fInfo.index,
 		}
 	}
 	return res
@@ -499,6 +532,43 @@ func (m *Model) AddSQLConstraint(name, sql, errorString string) {
 	}
 }
 
+// AddIndex adds an index to this model. name is an arbitrary name
+// to reference this index. It will be appended by the table name in
+// the database, so there is only need to ensure that it is unique in this model.
+//
+// fields are the indexed field names or SQL expressions such as "lower(name)".
+// where is an optional SQL predicate to create a partial index and method is
+// the optional index method such as "gin" or "brin".
+func (m *Model) AddIndex(name string, fields []string, where, method string) {
+	m.addSQLIndex(name, fields, where, method, false)
+}
+
+// AddUniqueIndex adds a unique index to this model.
+// See AddIndex for the meaning of the parameters.
+func (m *Model) AddUniqueIndex(name string, fields []string, where, method string) {
+	m.addSQLIndex(name, fields, where, method, true)
+}
+
+// addSQLIndex adds an index with the given parameters to this model
+func (m *Model) addSQLIndex(name string, fields []string, where, method string, unique bool) {
+	if len(fields) == 0 {
+		log.Panic("An index must have at least one field", "model", m.name, "index", name)
+	}
+	indexName := fmt.Sprintf("%s_%s_manidx", name, m.tableName)
+	m.sqlIndexes[indexName] = sqlIndex{
+		name:   indexName,
+		exprs:  fields,
+		where:  where,
+		method: strings.ToLower(method),
+		unique: unique,
+	}
+}
+
+// RemoveIndex removes the index previously created with the given name.
+func (m *Model) RemoveIndex(name string) {
+	delete(m.sqlIndexes, fmt.Sprintf("%s_%s_manidx", name, m.tableName))
+}
+
 // RemoveSQLConstraint removes the sql constraint with the given name from the database.
 func (m *Model) RemoveSQLConstraint(name string) {
 	delete(m.sqlConstraints, fmt.Sprintf("%s_mancon", name))
@@ -576,9 +646,11 @@ func CreateModel(name string, options Option) *Model {
 		fields:          newFieldsCollection(),
 		methods:         newMethodsCollection(),
 		sqlConstraints:  make(map[string]sqlConstraint),
+		sqlIndexes:      make(map[string]sqlIndex),
 		sqlErrors:       make(map[string]string),
 		defaultOrderStr: []string{"ID"},
 	}
+	mi.events = newModelEvents(mi)
 	pk := &Field{
 		name:      "ID",
 		json:      "id",
@@ -678,13 +750,18 @@ func (s *Sequence) NextValue() int64 {
 
 // FreeTransientModels remove transient models records from database which are
 // older than the given timeout.
+//
+// In multi-tenant mode, the records are removed from the databases of all
+// the loaded tenants.
 func FreeTransientModels() {
-	for _, model := range Registry.registryByName {
-		if model.IsTransient() {
-			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
-				createDate := model.FieldName("CreateDate")
-				model.Search(env, model.Field(createDate).Lower(dates.Now().Add(-transientModelTimeout))).Call("Unlink")
-			})
+	for _, tenant := range loadedTenants() {
+		for _, model := range Registry.registryByName {
+			if model.IsTransient() {
+				ExecuteInTenantEnvironment(tenant, security.SuperUserID, func(env Environment) {
+					createDate := model.FieldName("CreateDate")
+					model.Search(env, model.Field(createDate).Lower(dates.Now().Add(-transientModelTimeout))).Call("Unlink")
+				})
+			}
 		}
 	}
 }
@@ -0,0 +1,151 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// defaultMaxReplicaLag is the maximum replication lag of a replica
+	// for it to be used if none is set in the connection parameters.
+	defaultMaxReplicaLag = 10 * time.Second
+	// replicaLagCheckPeriod is the minimum duration between two
+	// checks of the replication lag of the replicas.
+	replicaLagCheckPeriod = 5 * time.Second
+)
+
+// A dbReplica is a connection to a read replica of the database
+type dbReplica struct {
+	db      *sqlx.DB
+	params  ConnectionParams
+	healthy bool
+}
+
+var (
+	replicas      []*dbReplica
+	replicasMutex sync.Mutex
+	lastLagCheck  time.Time
+	nextReplica   int
+)
+
+// writeQueryRegex matches the keywords of queries that modify the database
+var writeQueryRegex = regexp.MustCompile(`(?i)\b(insert|update|delete|nextval|setval)\b`)
+
+// isReadQuery returns true if the given query only reads
+// the database and can be run on a replica.
+func isReadQuery(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(q, "SELECT") && !strings.HasPrefix(q, "WITH") {
+		return false
+	}
+	return !writeQueryRegex.MatchString(q)
+}
+
+// withDefaults returns a copy of these replica connection params
+// with empty values set to those of the given primary params.
+func (cp ConnectionParams) withDefaults(primary ConnectionParams) ConnectionParams {
+	defaults := map[*string]string{
+		&cp.Driver:   primary.Driver,
+		&cp.Host:     primary.Host,
+		&cp.Port:     primary.Port,
+		&cp.User:     primary.User,
+		&cp.Password: primary.Password,
+		&cp.DBName:   primary.DBName,
+		&cp.SSLMode:  primary.SSLMode,
+		&cp.SSLCert:  primary.SSLCert,
+		&cp.SSLKey:   primary.SSLKey,
+		&cp.SSLCA:    primary.SSLCA,
+	}
+	for field, value := range defaults {
+		if *field == "" {
+			*field = value
+		}
+	}
+	cp.Replicas = nil
+	return cp
+}
+
+// connectReplicas connects to the replicas of the given connection params.
+// Replicas that cannot be reached are logged and ignored.
+func connectReplicas(params ConnectionParams) {
+	replicasMutex.Lock()
+	defer replicasMutex.Unlock()
+	replicas = nil
+	lastLagCheck = time.Time{}
+	for _, rParams := range params.Replicas {
+		rParams = rParams.withDefaults(params)
+		rdb, err := sqlx.Connect(rParams.Driver, rParams.ConnectionString())
+		if err != nil {
+			log.Warn("Unable to connect to database replica", "host", rParams.Host, "port", rParams.Port, "error", err)
+			continue
+		}
+		configurePool(rdb, params)
+		replicas = append(replicas, &dbReplica{db: rdb, params: rParams})
+		log.Info("Connected to database replica", "host", rParams.Host, "port", rParams.Port)
+	}
+}
+
+// closeReplicas closes the connections to the replicas
+func closeReplicas() {
+	replicasMutex.Lock()
+	defer replicasMutex.Unlock()
+	for _, replica := range replicas {
+		err := replica.db.Close()
+		log.Info("Closed database replica", "host", replica.params.Host, "port", replica.params.Port, "error", err)
+	}
+	replicas = nil
+}
+
+// pickReplica returns the connection to a replica whose replication lag
+// is below the maximum replica lag, or nil if there is none.
+//
+// Replicas are picked in turn.
+func pickReplica() *sqlx.DB {
+	replicasMutex.Lock()
+	defer replicasMutex.Unlock()
+	if len(replicas) == 0 {
+		return nil
+	}
+	if time.Since(lastLagCheck) > replicaLagCheckPeriod {
+		checkReplicasLag()
+		lastLagCheck = time.Now()
+	}
+	for i := range replicas {
+		replica := replicas[(nextReplica+i)%len(replicas)]
+		if replica.healthy {
+			nextReplica = (nextReplica + i + 1) % len(replicas)
+			return replica.db
+		}
+	}
+	return nil
+}
+
+// checkReplicasLag updates the health of the replicas
+// according to their replication lag.
+func checkReplicasLag() {
+	adapter := adapters[db.DriverName()]
+	maxLag := connParams.MaxReplicaLag
+	if maxLag == 0 {
+		maxLag = defaultMaxReplicaLag
+	}
+	for _, replica := range replicas {
+		lag, err := adapter.replicaLag(replica.db)
+		switch {
+		case err != nil:
+			log.Warn("Unable to get database replica lag", "host", replica.params.Host, "port", replica.params.Port, "error", err)
+			replica.healthy = false
+		case lag > maxLag:
+			log.Warn("Database replica lag is too high", "host", replica.params.Host, "port", replica.params.Port, "lag", lag)
+			replica.healthy = false
+		default:
+			replica.healthy = true
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A SchemaChangeType is the kind of modification of a SchemaChange
+type SchemaChangeType string
+
+// Types of schema changes
+const (
+	SchemaCreateSequence   SchemaChangeType = "create sequence"
+	SchemaAlterSequence    SchemaChangeType = "alter sequence"
+	SchemaRenameSequence   SchemaChangeType = "rename sequence"
+	SchemaDropSequence     SchemaChangeType = "drop sequence"
+	SchemaCreateTable      SchemaChangeType = "create table"
+	SchemaRenameTable      SchemaChangeType = "rename table"
+	SchemaDropTable        SchemaChangeType = "drop table"
+	SchemaAddColumn        SchemaChangeType = "add column"
+	SchemaRenameColumn     SchemaChangeType = "rename column"
+	SchemaSetDefault       SchemaChangeType = "set default values"
+	SchemaAlterColumnType  SchemaChangeType = "alter column type"
+	SchemaSetNotNull       SchemaChangeType = "set not null"
+	SchemaDropNotNull      SchemaChangeType = "drop not null"
+	SchemaDropColumn       SchemaChangeType = "drop column"
+	SchemaCreateForeignKey SchemaChangeType = "create foreign key"
+	SchemaDropForeignKey   SchemaChangeType = "drop foreign key"
+	SchemaCreateConstraint SchemaChangeType = "create constraint"
+	SchemaRenameConstraint SchemaChangeType = "rename constraint"
+	SchemaDropConstraint   SchemaChangeType = "drop constraint"
+	SchemaCreateIndex      SchemaChangeType = "create index"
+	SchemaRenameIndex      SchemaChangeType = "rename index"
+	SchemaDropIndex        SchemaChangeType = "drop index"
+	SchemaSetComment       SchemaChangeType = "set comment"
+	SchemaCreateView       SchemaChangeType = "create view"
+	SchemaDropView         SchemaChangeType = "drop view"
+	// The following changes are reported in safe mode instead of dropping data
+	SchemaKeepSequence     SchemaChangeType = "keep unused sequence"
+	SchemaKeepTable        SchemaChangeType = "keep unused table"
+	SchemaKeepColumn       SchemaChangeType = "keep unused column"
+	SchemaKeepView         SchemaChangeType = "keep unused view"
+	SchemaRefuseColumnType SchemaChangeType = "refuse column type change"
+)
+
+// sign returns "+" for changes that add an object to the schema,
+// "-" for changes that remove one, "!" for changes that are not
+// applied in safe mode and "~" for modifications.
+func (sct SchemaChangeType) sign() string {
+	switch sct {
+	case SchemaCreateSequence, SchemaCreateTable, SchemaAddColumn, SchemaCreateForeignKey,
+		SchemaCreateConstraint, SchemaCreateIndex, SchemaCreateView:
+		return "+"
+	case SchemaDropSequence, SchemaDropTable, SchemaDropColumn, SchemaDropForeignKey,
+		SchemaDropConstraint, SchemaDropIndex, SchemaDropView:
+		return "-"
+	case SchemaKeepSequence, SchemaKeepTable, SchemaKeepColumn, SchemaKeepView, SchemaRefuseColumnType:
+		return "!"
+	}
+	return "~"
+}
+
+// verbatim returns true for changes whose SQL holds user
+// queries that must not be reformatted.
+func (sct SchemaChangeType) verbatim() bool {
+	return sct == SchemaCreateView || sct == SchemaSetComment
+}
+
+// A SchemaChange is a single modification of the database schema
+// needed to synchronize it with the models.
+type SchemaChange struct {
+	Type SchemaChangeType
+	// Table is the name of the table impacted by this change, if any
+	Table string
+	// Name is the name of the column, constraint, index or sequence of this change
+	Name string
+	// From is the description of the object in the database before the change
+	From string
+	// To is the description of the object in the database after the change
+	To string
+	// SQL is the query that executes this change. It is empty if
+	// this change is executed by another change (e.g. columns of
+	// a new table).
+	SQL  string
+	Args []interface{}
+}
+
+// String method for SchemaChange
+func (sc SchemaChange) String() string {
+	target := sc.Name
+	switch {
+	case sc.Table != "" && sc.Name != "":
+		target = fmt.Sprintf("%s.%s", sc.Table, sc.Name)
+	case sc.Table != "":
+		target = sc.Table
+	}
+	res := fmt.Sprintf("%s %s %s", sc.Type.sign(), sc.Type, target)
+	switch {
+	case sc.From != "" && sc.To != "":
+		res += fmt.Sprintf(" (%s -> %s)", sc.From, sc.To)
+	case sc.From != "":
+		res += fmt.Sprintf(" (%s)", sc.From)
+	case sc.To != "":
+		res += fmt.Sprintf(" (%s)", sc.To)
+	}
+	return res
+}
+
+// A SchemaPlan is the list of changes to apply to the
+// database schema to synchronize it with the models.
+type SchemaPlan []SchemaChange
+
+// IsEmpty returns true if there are no changes in this plan
+func (sp SchemaPlan) IsEmpty() bool {
+	return len(sp) == 0
+}
+
+// String returns a human readable diff of this plan with one change per line.
+func (sp SchemaPlan) String() string {
+	lines := make([]string, len(sp))
+	for i, change := range sp {
+		lines[i] = change.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// multiSpaceRegex matches indentation and line breaks in queries
+var multiSpaceRegex = regexp.MustCompile(`\s*\n\s*`)
+
+// SQL returns the SQL script that applies this plan, with one
+// statement per line and query arguments rendered as literals.
+func (sp SchemaPlan) SQL() string {
+	adapter := adapters[db.DriverName()]
+	var res strings.Builder
+	for _, change := range sp {
+		if change.SQL == "" {
+			continue
+		}
+		query := strings.TrimSpace(change.SQL)
+		if !change.Type.verbatim() {
+			query = multiSpaceRegex.ReplaceAllString(query, " ")
+		}
+		res.WriteString(inlineSQLArgs(adapter, query, change.Args))
+		res.WriteString(";\n")
+	}
+	return res.String()
+}
+
+// inlineSQLArgs returns the given query with its placeholders
+// replaced by the given arguments rendered as SQL literals.
+func inlineSQLArgs(adapter dbAdapter, query string, args []interface{}) string {
+	for _, arg := range args {
+		query = strings.Replace(query, "?", adapter.literalSQL(arg), 1)
+	}
+	return query
+}
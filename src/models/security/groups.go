@@ -152,6 +152,8 @@ func (gc *GroupCollection) UnregisterGroup(group *Group) {
 
 // GetGroup returns the group with the given groupID or nil if not found
 func (gc *GroupCollection) GetGroup(groupID string) *Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	return gc.groups[groupID]
 }
 
@@ -228,6 +230,8 @@ func (gc *GroupCollection) HasMembership(uid int64, group *Group) bool {
 	if group == GroupEveryone {
 		return true
 	}
+	gc.RLock()
+	defer gc.RUnlock()
 	_, ok := gc.memberships[uid][group]
 	return ok
 }
@@ -235,6 +239,8 @@ func (gc *GroupCollection) HasMembership(uid int64, group *Group) bool {
 // UserGroups returns the slice of groups the user with the given
 // uid belongs to, including inherited groups.
 func (gc *GroupCollection) UserGroups(uid int64) map[*Group]InheritanceInfo {
+	gc.RLock()
+	defer gc.RUnlock()
 	res := make(map[*Group]InheritanceInfo, len(gc.memberships[uid])+1)
 	for k, v := range gc.memberships[uid] {
 		res[k] = v
@@ -245,6 +251,8 @@ func (gc *GroupCollection) UserGroups(uid int64) map[*Group]InheritanceInfo {
 
 // AllGroups returns a slice with all the groups of the collection
 func (gc *GroupCollection) AllGroups() []*Group {
+	gc.RLock()
+	defer gc.RUnlock()
 	res := make([]*Group, len(gc.groups))
 	i := 0
 	for _, group := range gc.groups {
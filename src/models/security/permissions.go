@@ -14,6 +14,8 @@
 
 package security
 
+import "strings"
+
 // A Permission defines which of the read, write or unlink rights apply.
 type Permission uint8
 
@@ -24,3 +26,45 @@ const (
 	Unlink
 	All = Read | Write | Unlink
 )
+
+// permissionNames are the names of the rights of a Permission
+var permissionNames = []struct {
+	perm Permission
+	name string
+}{
+	{Read, "read"},
+	{Write, "write"},
+	{Unlink, "unlink"},
+}
+
+// String returns the comma separated names of the rights of this Permission
+func (p Permission) String() string {
+	var names []string
+	for _, pn := range permissionNames {
+		if p&pn.perm > 0 {
+			names = append(names, pn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParsePermission returns the Permission with the rights of the given comma
+// separated names among "read", "write" and "unlink". The second return value
+// is false if a name is unknown.
+func ParsePermission(names string) (Permission, bool) {
+	var res Permission
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		found := false
+		for _, pn := range permissionNames {
+			if name == pn.name {
+				res |= pn.perm
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return res, true
+}
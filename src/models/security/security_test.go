@@ -189,3 +189,19 @@ func TestAuthBackend(t *testing.T) {
 		So(id, ShouldEqual, 0)
 	})
 }
+
+func TestPermissions(t *testing.T) {
+	Convey("Testing permissions", t, func() {
+		So(Permission(Read).String(), ShouldEqual, "read")
+		So(Permission(Read|Unlink).String(), ShouldEqual, "read,unlink")
+		So(Permission(All).String(), ShouldEqual, "read,write,unlink")
+		perm, ok := ParsePermission("write")
+		So(ok, ShouldBeTrue)
+		So(perm, ShouldEqual, Write)
+		perm, ok = ParsePermission("Read, unlink")
+		So(ok, ShouldBeTrue)
+		So(perm, ShouldEqual, Read|Unlink)
+		_, ok = ParsePermission("delete")
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gleke/hexya/src/models/security"
+)
+
+// fieldACLMutex protects the read and write groups of all fields
+var fieldACLMutex sync.RWMutex
+
+// AllowRead restricts the read access of this field to the given group
+// and to the other groups given by previous calls to AllowRead.
+//
+// Fields without any group are readable by everyone. Users who cannot
+// read a field get its zero value and cannot search on it, and the field
+// is hidden from FieldsGet. The admin group can read all fields.
+func (f *Field) AllowRead(group *security.Group) *Field {
+	fieldACLMutex.Lock()
+	defer fieldACLMutex.Unlock()
+	if f.readGroups == nil {
+		f.readGroups = make(map[*security.Group]bool)
+	}
+	f.readGroups[group] = true
+	return f
+}
+
+// AllowWrite restricts the write access of this field to the given group
+// and to the other groups given by previous calls to AllowWrite.
+//
+// Fields without any group are writable by everyone. Writing a field
+// that the user is not allowed to write panics. The admin group can
+// write all fields.
+func (f *Field) AllowWrite(group *security.Group) *Field {
+	fieldACLMutex.Lock()
+	defer fieldACLMutex.Unlock()
+	if f.writeGroups == nil {
+		f.writeGroups = make(map[*security.Group]bool)
+	}
+	f.writeGroups[group] = true
+	return f
+}
+
+// isAllowed returns true if the user of the given Environment belongs to
+// one of the given groups or to the admin group, or if groups is nil.
+func isAllowed(env Environment, groups map[*security.Group]bool) bool {
+	if groups == nil {
+		return true
+	}
+	for group := range env.UserGroups() {
+		if group == security.GroupAdmin || groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead returns true if the user of the given Environment can read this field
+func (f *Field) canRead(env Environment) bool {
+	fieldACLMutex.RLock()
+	defer fieldACLMutex.RUnlock()
+	return isAllowed(env, f.readGroups)
+}
+
+// canWrite returns true if the user of the given Environment can write this field
+func (f *Field) canWrite(env Environment) bool {
+	fieldACLMutex.RLock()
+	defer fieldACLMutex.RUnlock()
+	return isAllowed(env, f.writeGroups)
+}
+
+// canReadPath returns true if the user of the given Environment can
+// read all the fields of the given field path from this model.
+func (m *Model) canReadPath(env Environment, path FieldName) bool {
+	return m.canReadExprs(env, splitFieldNames(path, ExprSep))
+}
+
+// canReadExprs returns true if the user of the given Environment can
+// read all the fields of the given exprs from this model.
+func (m *Model) canReadExprs(env Environment, exprs []FieldName) bool {
+	model := m
+	for _, expr := range exprs {
+		if model == nil {
+			return true
+		}
+		fi, ok := model.fields.Get(expr.JSON())
+		if !ok {
+			return true
+		}
+		if !fi.canRead(env) {
+			return false
+		}
+		model = fi.relatedModel
+	}
+	return true
+}
+
+// filterReadableFields returns the given fields that the
+// user of this RecordCollection's Environment can read.
+func (rc *RecordCollection) filterReadableFields(fields FieldNames) FieldNames {
+	res := make(FieldNames, 0, len(fields))
+	for _, f := range fields {
+		if rc.model.canReadPath(*rc.env, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// checkConditionReadAccess panics if the given condition is on a field
+// that the user of this RecordCollection's Environment cannot read.
+func (rc *RecordCollection) checkConditionReadAccess(cond *Condition) {
+	for _, exprs := range cond.getAllExpressions(rc.model) {
+		if !rc.model.canReadExprs(*rc.env, exprs) {
+			log.Panic("You are not allowed to search on this field", "model", rc.model.name,
+				"field", joinFieldNames(exprs, ExprSep).Name(), "uid", rc.env.uid)
+		}
+	}
+}
+
+// nonWritableFields returns the fields of the given data that the user
+// of this RecordCollection's Environment cannot write. Computed fields are
+// never returned, since they are written with the values of their compute
+// method.
+func (rc *RecordCollection) nonWritableFields(data FieldMap) []string {
+	var res []string
+	for f := range data {
+		fi, ok := rc.model.fields.Get(f)
+		if strings.Contains(f, ExprSep) {
+			fi, ok = rc.model.getRelatedFieldInfo(rc.model.FieldName(f)), true
+		}
+		if !ok || fi.isComputedField() {
+			continue
+		}
+		if !fi.canWrite(*rc.env) {
+			res = append(res, f)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// checkWriteAccess panics if the given data holds a value for a field that
+// the user of this RecordCollection's Environment cannot write.
+func (rc *RecordCollection) checkWriteAccess(data FieldMap) {
+	if fields := rc.nonWritableFields(data); len(fields) > 0 {
+		log.Panic("You are not allowed to write these fields", "model", rc.model.name, "fields", fields, "uid", rc.env.uid)
+	}
+}
+
+// checkFieldsReadAccess panics with the given message if one of the given
+// fields cannot be read by the user of this RecordCollection's Environment.
+func (rc *RecordCollection) checkFieldsReadAccess(msg string, fields ...FieldName) {
+	for _, f := range fields {
+		if !rc.model.canReadPath(*rc.env, f) {
+			log.Panic(msg, "model", rc.model.name, "field", f.Name(), "uid", rc.env.uid)
+		}
+	}
+}
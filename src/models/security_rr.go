@@ -15,6 +15,8 @@
 package models
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/gleke/hexya/src/models/security"
@@ -25,12 +27,44 @@ import (
 // - If Global is true, then the RecordRule applies to all groups
 // - Condition is the filter to apply on the model to retrieve
 // the records on which to allow the Perms permission.
+// - If ConditionFunc is set, it is used instead of Condition to
+// build the filter from the Environment of each query, for instance
+// to filter on the current user.
 type RecordRule struct {
-	Name      string
-	Global    bool
-	Group     *security.Group
-	Condition *Condition
-	Perms     security.Permission
+	Name          string
+	Global        bool
+	Group         *security.Group
+	Condition     *Condition
+	ConditionFunc func(Environment) *Condition
+	Perms         security.Permission
+}
+
+// A ruleConditionKey identifies the condition of a RecordRule built
+// for a user and its allowed companies in the cache of an Environment
+type ruleConditionKey struct {
+	rule      *RecordRule
+	uid       int64
+	companies string
+}
+
+// condition returns the filter of this RecordRule for the queries
+// of the given Environment.
+//
+// If the condition is built by ConditionFunc, it is built once per
+// user and allowed companies in the Environment and cached. The queries made by
+// ConditionFunc itself are not filtered by this RecordRule.
+func (rr *RecordRule) condition(env Environment) *Condition {
+	if rr.ConditionFunc == nil {
+		return rr.Condition
+	}
+	key := ruleConditionKey{rule: rr, uid: env.uid, companies: fmt.Sprint(env.AllowedCompanyIDs())}
+	if cond, ok := env.ruleConditions[key]; ok {
+		return cond
+	}
+	env.ruleConditions[key] = nil
+	cond := rr.ConditionFunc(env)
+	env.ruleConditions[key] = cond
+	return cond
 }
 
 // A RecordRuleRegistry keeps a list of RecordRule. It is meant
@@ -101,3 +135,69 @@ func (m *Model) AddRecordRule(rule *RecordRule) {
 func (m *Model) RemoveRecordRule(name string) {
 	m.rulesRegistry.removeRule(name)
 }
+
+// hasRecordRules returns true if record rules apply to the user of
+// the given Environment for the given permission on this model.
+func (m *Model) hasRecordRules(env Environment, perm security.Permission) bool {
+	m.rulesRegistry.RLock()
+	defer m.rulesRegistry.RUnlock()
+	for _, rule := range m.rulesRegistry.globalRules {
+		if perm&rule.Perms > 0 {
+			return true
+		}
+	}
+	for group := range env.UserGroups() {
+		for _, rule := range m.rulesRegistry.rulesByGroup[group.ID()] {
+			if perm&rule.Perms > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applicableRecordRules returns the global rules and the rules of the groups
+// of the user of the given Environment that apply to the given permission
+// on this model, sorted by name.
+func (m *Model) applicableRecordRules(env Environment, perm security.Permission) (globalRules, groupRules []*RecordRule) {
+	m.rulesRegistry.RLock()
+	defer m.rulesRegistry.RUnlock()
+	for _, rule := range m.rulesRegistry.globalRules {
+		if perm&rule.Perms > 0 {
+			globalRules = append(globalRules, rule)
+		}
+	}
+	for group := range env.UserGroups() {
+		for _, rule := range m.rulesRegistry.rulesByGroup[group.ID()] {
+			if perm&rule.Perms > 0 {
+				groupRules = append(groupRules, rule)
+			}
+		}
+	}
+	sort.Slice(globalRules, func(i, j int) bool {
+		return globalRules[i].Name < globalRules[j].Name
+	})
+	sort.Slice(groupRules, func(i, j int) bool {
+		return groupRules[i].Name < groupRules[j].Name
+	})
+	return
+}
+
+// recordRulesCondition returns the condition that the records of this model
+// must match for the user of the given Environment to have the given permission.
+//
+// Records must match all the global rules and at least one of the rules of
+// the groups of the user, if any. The returned condition is empty if no
+// rule applies.
+func (m *Model) recordRulesCondition(env Environment, perm security.Permission) *Condition {
+	globalRules, groupRules := m.applicableRecordRules(env, perm)
+	cond := newCondition()
+	for _, rule := range globalRules {
+		cond = cond.AndCond(rule.condition(env))
+	}
+	groupCondition := newCondition()
+	for _, rule := range groupRules {
+		groupCondition = groupCondition.OrCond(rule.condition(env))
+	}
+	return cond.AndCond(groupCondition)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/gleke/hexya/src/models/security"
+)
+
+// A RecordRulesExplanation describes how record rules restrict the
+// permission of a user on the records of a model.
+type RecordRulesExplanation struct {
+	Model      string
+	UserID     int64
+	Permission security.Permission
+	// GlobalRules are the global rules that apply to the permission.
+	// Records must match all of them.
+	GlobalRules []*RecordRule
+	// GroupRules are the rules of the groups of the user that apply to
+	// the permission. Records must match at least one of them, if any.
+	GroupRules []*RecordRule
+	// Condition is the condition added to the queries of the user
+	Condition *Condition
+	// Records hold the explanations of the records asked for
+	Records []RecordRulesVerdict
+}
+
+// A RecordRulesVerdict tells whether the record rules
+// allow the permission of a user on a record.
+type RecordRulesVerdict struct {
+	ID int64
+	// Exists is false if there is no record with this ID
+	Exists bool
+	// Allowed is true if the user has the permission on this record
+	Allowed bool
+	// AllowingRules are the names of the rules whose condition
+	// the record matches.
+	AllowingRules []string
+	// DenyingRules are the names of the rules whose condition
+	// the record does not match.
+	DenyingRules []string
+}
+
+// ExplainRecordRules returns the record rules that apply to the user with the
+// given uid for the given permission on this model, and the condition they add
+// to the queries of this user.
+//
+// If ids are given, the explanation also tells for each record whether the
+// user has the permission and which rules allow or deny it. Records are
+// evaluated in the given Environment regardless of its user.
+//
+// The conditions of the rules with a ConditionFunc are built with a copy of
+// the given Environment for the user with the given uid.
+func (m *Model) ExplainRecordRules(env Environment, uid int64, perm security.Permission, ids ...int64) RecordRulesExplanation {
+	env.uid = uid
+	globalRules, groupRules := m.applicableRecordRules(env, perm)
+	res := RecordRulesExplanation{
+		Model:       m.name,
+		UserID:      uid,
+		Permission:  perm,
+		GlobalRules: globalRules,
+		GroupRules:  groupRules,
+		Condition:   m.recordRulesCondition(env, perm),
+	}
+	if len(ids) == 0 {
+		return res
+	}
+	existing := m.matchingIds(env, ids, nil)
+	matches := make(map[*RecordRule]map[int64]bool)
+	for _, rule := range append(globalRules, groupRules...) {
+		matches[rule] = m.matchingIds(env, ids, rule.condition(env))
+	}
+	for _, id := range ids {
+		verdict := RecordRulesVerdict{
+			ID:      id,
+			Exists:  existing[id],
+			Allowed: existing[id],
+		}
+		for _, rule := range globalRules {
+			if !matches[rule][id] {
+				verdict.DenyingRules = append(verdict.DenyingRules, rule.Name)
+				verdict.Allowed = false
+				continue
+			}
+			verdict.AllowingRules = append(verdict.AllowingRules, rule.Name)
+		}
+		var groupAllowed bool
+		for _, rule := range groupRules {
+			if !matches[rule][id] {
+				verdict.DenyingRules = append(verdict.DenyingRules, rule.Name)
+				continue
+			}
+			verdict.AllowingRules = append(verdict.AllowingRules, rule.Name)
+			groupAllowed = true
+		}
+		if len(groupRules) > 0 && !groupAllowed {
+			verdict.Allowed = false
+		}
+		res.Records = append(res.Records, verdict)
+	}
+	return res
+}
+
+// matchingIds returns the given ids of the records of this model that
+// match the given condition, without applying record rules.
+func (m *Model) matchingIds(env Environment, ids []int64, cond *Condition) map[int64]bool {
+	rSet := env.Pool(m.name).Sudo().Search(m.Field(ID).In(ids).AndCond(cond))
+	rSet.filtered = true
+	res := make(map[int64]bool)
+	for _, id := range rSet.Fetch().Ids() {
+		res[id] = true
+	}
+	return res
+}
@@ -0,0 +1,357 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gleke/hexya/src/models/security"
+)
+
+const (
+	// sharedCacheChannel is the database notification channel on which
+	// shared cache invalidations are sent to the other processes.
+	sharedCacheChannel = "hexya_shared_cache"
+	// sharedCacheMaxNotifiedIds is the maximum number of ids sent in an
+	// invalidation notification. The whole model is invalidated beyond.
+	sharedCacheMaxNotifiedIds = 500
+)
+
+// A sharedCacheKey identifies the shared cache of a model
+// on the database of a tenant.
+type sharedCacheKey struct {
+	tenant string
+	model  string
+}
+
+// A sharedCacheEntry holds the cached field values of a record
+type sharedCacheEntry struct {
+	id     int64
+	values FieldMap
+}
+
+// A sharedModelCache is the size bounded shared cache of a model.
+// The least recently used records are evicted first.
+type sharedModelCache struct {
+	entries map[int64]*list.Element
+	lru     *list.List
+	// invalidatedAt is the sharedCacheVersion of the last invalidation
+	invalidatedAt uint64
+}
+
+// A sharedCacheInvalidation is the payload of an invalidation
+// notification sent to the other processes.
+type sharedCacheInvalidation struct {
+	Origin string  `json:"origin"`
+	Tenant string  `json:"tenant"`
+	Model  string  `json:"model"`
+	IDs    []int64 `json:"ids,omitempty"`
+}
+
+var (
+	sharedCaches        map[sharedCacheKey]*sharedModelCache
+	sharedCacheMutex    sync.Mutex
+	sharedCacheListener io.Closer
+	// sharedCacheVersion is incremented at each invalidation of the shared cache.
+	// Environments only store records in the shared cache if no invalidation
+	// occurred on the model since they were created, so that records read in a
+	// transaction that started before a commit are never cached after it.
+	sharedCacheVersion uint64
+	// sharedCacheOrigin identifies this process in invalidation notifications
+	sharedCacheOrigin string
+)
+
+// SetSharedCache enables the process-wide shared cache for the records
+// of this model, holding at most size records per database. The shared
+// cache is disabled if size is 0.
+//
+// The shared cache is meant for read-mostly models such as configuration
+// data. Records are invalidated when they are written or unlinked in any
+// Environment and in the other processes connected to the same database.
+// It is not used for users to whom record rules apply on this model.
+func (m *Model) SetSharedCache(size int) {
+	if size < 0 {
+		log.Panic("Shared cache size must be positive", "model", m.name, "size", size)
+	}
+	m.sharedCacheSize = size
+}
+
+// HasSharedCache returns true if the shared cache is enabled for this model
+func (m *Model) HasSharedCache() bool {
+	return m.sharedCacheSize > 0
+}
+
+// isSharedCacheable returns true if the values of the given field
+// can be stored in the shared cache.
+func isSharedCacheable(fi *Field) bool {
+	return fi.isStored() && !fi.isRelatedField() && !fi.isContextedField()
+}
+
+// currentSharedCacheVersion returns the current sharedCacheVersion.
+func currentSharedCacheVersion() uint64 {
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+	return sharedCacheVersion
+}
+
+// getSharedModelCache returns the shared cache of the given model on the
+// database of the given tenant, creating it if it does not exist.
+//
+// sharedCacheMutex must be held by the caller.
+func getSharedModelCache(tenant string, model string) *sharedModelCache {
+	key := sharedCacheKey{tenant: tenant, model: model}
+	smc, ok := sharedCaches[key]
+	if !ok {
+		smc = &sharedModelCache{
+			entries: make(map[int64]*list.Element),
+			lru:     list.New(),
+		}
+		sharedCaches[key] = smc
+	}
+	return smc
+}
+
+// loadFromSharedCache adds the given fields of the records of this
+// RecordCollection to the Environment's cache from the shared cache.
+//
+// It returns false and does nothing if one of the fields of one of the
+// records is not in the shared cache.
+func (rc *RecordCollection) loadFromSharedCache(fields []string) bool {
+	if !rc.model.HasSharedCache() || len(rc.ids) == 0 || rc.hasNegIds {
+		return false
+	}
+	if rc.model.hasRecordRules(*rc.env, security.Read) {
+		return false
+	}
+	for _, field := range fields {
+		fi, ok := rc.model.fields.Get(field)
+		if !ok || !isSharedCacheable(fi) {
+			return false
+		}
+	}
+	records := make([]FieldMap, len(rc.ids))
+	sharedCacheMutex.Lock()
+	smc := getSharedModelCache(rc.env.tenant, rc.model.name)
+	for i, id := range rc.ids {
+		elem, ok := smc.entries[id]
+		if !ok {
+			sharedCacheMutex.Unlock()
+			return false
+		}
+		values := elem.Value.(*sharedCacheEntry).values
+		for _, field := range fields {
+			if _, exists := values[rc.model.fields.MustGet(field).json]; !exists {
+				sharedCacheMutex.Unlock()
+				return false
+			}
+		}
+		records[i] = values.Copy()
+	}
+	for _, id := range rc.ids {
+		smc.lru.MoveToFront(smc.entries[id])
+	}
+	sharedCacheMutex.Unlock()
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	for i, id := range rc.ids {
+		rc.env.cache.addRecord(rc.model, id, records[i], rc.query.ctxArgsSlug())
+	}
+	return true
+}
+
+// storeInSharedCache stores the given records, as loaded from the
+// database by this RecordCollection's Environment, in the shared cache.
+func (rc *RecordCollection) storeInSharedCache(lines []FieldMap) {
+	if !rc.model.HasSharedCache() || len(lines) == 0 {
+		return
+	}
+	if rc.env.cr.onReplica {
+		// Replicas may lag behind the primary database, so that
+		// their data may be older than the last invalidation.
+		return
+	}
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+	smc := getSharedModelCache(rc.env.tenant, rc.model.name)
+	if smc.invalidatedAt > rc.env.sharedCacheVersion {
+		// The model has been modified since this Environment has been
+		// created, possibly by itself, so that its data may be outdated.
+		return
+	}
+	for _, line := range lines {
+		id := line["id"].(int64)
+		values := make(FieldMap)
+		for field, value := range line {
+			if strings.Contains(field, ExprSep) {
+				continue
+			}
+			fi, ok := rc.model.fields.Get(field)
+			if !ok || !isSharedCacheable(fi) {
+				continue
+			}
+			values[fi.json] = value
+		}
+		if elem, ok := smc.entries[id]; ok {
+			entry := elem.Value.(*sharedCacheEntry)
+			for field, value := range values {
+				entry.values[field] = value
+			}
+			smc.lru.MoveToFront(elem)
+			continue
+		}
+		smc.entries[id] = smc.lru.PushFront(&sharedCacheEntry{id: id, values: values})
+	}
+	for smc.lru.Len() > rc.model.sharedCacheSize {
+		oldest := smc.lru.Back()
+		smc.lru.Remove(oldest)
+		delete(smc.entries, oldest.Value.(*sharedCacheEntry).id)
+	}
+}
+
+// invalidateSharedCache removes the records with the given ids of the given
+// model from the shared cache of the database of the given tenant. All the
+// records of the model are removed if ids is nil.
+func invalidateSharedCache(tenant string, model string, ids []int64) {
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+	sharedCacheVersion++
+	smc := getSharedModelCache(tenant, model)
+	smc.invalidatedAt = sharedCacheVersion
+	if ids == nil {
+		smc.entries = make(map[int64]*list.Element)
+		smc.lru.Init()
+		return
+	}
+	for _, id := range ids {
+		if elem, ok := smc.entries[id]; ok {
+			smc.lru.Remove(elem)
+			delete(smc.entries, id)
+		}
+	}
+}
+
+// clearSharedCache removes all records from the shared cache
+func clearSharedCache() {
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+	sharedCacheVersion++
+	for _, smc := range sharedCaches {
+		smc.entries = make(map[int64]*list.Element)
+		smc.lru.Init()
+		smc.invalidatedAt = sharedCacheVersion
+	}
+}
+
+// invalidateSharedCache removes the records with the given ids of the given
+// model from the shared cache, or all the records of the model if ids is nil.
+//
+// The invalidation is notified to the other processes when this
+// Environment's transaction is committed.
+func (env Environment) invalidateSharedCache(model *Model, ids []int64) {
+	if !model.HasSharedCache() {
+		return
+	}
+	invalidateSharedCache(env.tenant, model.name, ids)
+	invalidated, exists := env.sharedCacheInvalidations[model.name]
+	switch {
+	case exists && invalidated == nil:
+	case ids == nil:
+		env.sharedCacheInvalidations[model.name] = nil
+	default:
+		env.sharedCacheInvalidations[model.name] = append(invalidated, ids...)
+	}
+}
+
+// notifySharedCacheInvalidations invalidates again the shared cache records
+// that have been modified in this Environment and notifies the other
+// processes. It must be called after the transaction has been committed.
+func (env Environment) notifySharedCacheInvalidations() {
+	for modelName, ids := range env.sharedCacheInvalidations {
+		// Records may have been cached by transactions that started before
+		// the commit, but after the first invalidation.
+		invalidateSharedCache(env.tenant, modelName, ids)
+		if sharedCacheListener == nil {
+			continue
+		}
+		if len(ids) > sharedCacheMaxNotifiedIds {
+			ids = nil
+		}
+		payload, _ := json.Marshal(sharedCacheInvalidation{
+			Origin: sharedCacheOrigin,
+			Tenant: env.tenant,
+			Model:  modelName,
+			IDs:    ids,
+		})
+		adapter := adapters[db.DriverName()]
+		if _, err := db.Exec(db.Rebind(adapter.notifySQL()), sharedCacheChannel, string(payload)); err != nil {
+			log.Warn("Unable to notify shared cache invalidation", "model", modelName, "error", err)
+		}
+	}
+}
+
+// handleSharedCacheNotification invalidates the shared cache
+// according to the given notification payload.
+func handleSharedCacheNotification(payload string) {
+	if payload == "" {
+		// Notifications may have been lost
+		clearSharedCache()
+		return
+	}
+	var inv sharedCacheInvalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		log.Warn("Invalid shared cache notification", "payload", payload, "error", err)
+		return
+	}
+	if inv.Origin == sharedCacheOrigin {
+		return
+	}
+	invalidateSharedCache(inv.Tenant, inv.Model, inv.IDs)
+}
+
+// listenSharedCacheInvalidations starts listening to the shared cache
+// invalidations of the other processes if a model has a shared cache.
+func listenSharedCacheInvalidations() {
+	if db == nil || sharedCacheListener != nil {
+		return
+	}
+	var hasSharedCache bool
+	for _, model := range Registry.registryByName {
+		if model.HasSharedCache() {
+			hasSharedCache = true
+			break
+		}
+	}
+	if !hasSharedCache {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	listener, err := adapter.listen(connParams, sharedCacheChannel, handleSharedCacheNotification)
+	if err != nil {
+		log.Panic("Unable to listen to shared cache invalidations", "error", err)
+	}
+	sharedCacheListener = listener
+	log.Info("Listening to shared cache invalidations", "channel", sharedCacheChannel)
+}
+
+// stopSharedCacheListener stops listening to the shared cache invalidations
+func stopSharedCacheListener() {
+	if sharedCacheListener == nil {
+		return
+	}
+	err := sharedCacheListener.Close()
+	log.Info("Stopped listening to shared cache invalidations", "error", err)
+	sharedCacheListener = nil
+}
+
+func init() {
+	sharedCaches = make(map[sharedCacheKey]*sharedModelCache)
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	sharedCacheOrigin = hex.EncodeToString(origin)
+}
@@ -84,6 +84,7 @@ func initializeTests() {
 		Password: dbArgs.Password,
 		SSLMode:  "disable",
 	})
+	SetEncryptionKeys("hexya tests secret")
 	TestAdapter = adapters[db.DriverName()]
 }
 
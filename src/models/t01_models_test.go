@@ -45,7 +45,9 @@ func TestModelDeclaration(t *testing.T) {
 		addressMI := NewMixinModel("AddressMixIn")
 		activeMI := NewMixinModel("ActiveMixIn")
 		viewModel := NewManualModel("UserView")
+		cityView := NewManualModel("CityView")
 		wizard := NewTransientModel("Wizard")
+		companyModel := NewModel("Company")
 
 		userModel.NewMethod("PrefixedUser", testPrefixdUser)
 
@@ -553,6 +555,8 @@ func TestModelDeclaration(t *testing.T) {
 		})
 		userModel.AddSQLConstraint("nums_premium", "CHECK((is_premium = TRUE AND nums IS NOT NULL AND nums > 0) OR (IS_PREMIUM = false))",
 			"Premium users must have positive nums")
+		userModel.AddIndex("lower_name", []string{"lower(name)"}, "", "")
+		userModel.AddIndex("active_email", []string{"Email", "Name desc"}, "is_active", "btree")
 
 		profileModel.fields.add(&Field{
 			model:       profileModel,
@@ -751,7 +755,16 @@ func TestModelDeclaration(t *testing.T) {
 			relatedPathStr: "User.PMoney",
 			defaultFunc:    DefaultValue(0),
 		})
+		post.fields.add(&Field{
+			model:            post,
+			name:             "Company",
+			json:             "company_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Company",
+		})
 		post.SetDefaultOrder("Title")
+		post.SetCompanyField("Company")
 
 		comment.fields.add(&Field{
 			model:            comment,
@@ -788,6 +801,31 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
+		comment.fields.add(&Field{
+			model:            comment,
+			name:             "Company",
+			json:             "company_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Company",
+		})
+		comment.fields.add(&Field{
+			model:       comment,
+			name:        "Priority",
+			json:        "priority",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			contexts:    FieldContexts{"company": companyContext},
+		})
+		comment.SetCompanyField("Company")
+
+		companyModel.fields.add(&Field{
+			model:       companyModel,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
 
 		tag.fields.add(&Field{
 			model:       tag,
@@ -860,6 +898,54 @@ func TestModelDeclaration(t *testing.T) {
 			constraint:  "CheckRate",
 			defaultFunc: DefaultValue(0),
 		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "Keywords",
+			json:        "keywords",
+			fieldType:   fieldtype.CharArray,
+			structField: reflect.StructField{Type: reflect.TypeOf([]string{})},
+			index:       true,
+		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "Scores",
+			json:        "scores",
+			fieldType:   fieldtype.IntegerArray,
+			structField: reflect.StructField{Type: reflect.TypeOf([]int64{})},
+		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "Secret",
+			json:        "secret",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			size:        10,
+			encrypted:   true,
+		})
+		tag.fields.add(&Field{
+			model:         tag,
+			name:          "Code",
+			json:          "code",
+			fieldType:     fieldtype.Char,
+			structField:   reflect.StructField{Type: reflect.TypeOf("")},
+			encrypted:     true,
+			deterministic: true,
+		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "ReadingTime",
+			json:        "reading_time",
+			fieldType:   fieldtype.Duration,
+			structField: reflect.StructField{Type: reflect.TypeOf(dates.Duration{})},
+			defaultFunc: DefaultValue(dates.Duration{}),
+		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "PublishTime",
+			json:        "publish_time",
+			fieldType:   fieldtype.Time,
+			structField: reflect.StructField{Type: reflect.TypeOf(dates.Time{})},
+		})
 		tag.SetDefaultOrder("Name DESC", "ID ASC")
 
 		cv.fields.add(&Field{
@@ -943,6 +1029,25 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
+		viewModel.SetView(ViewDefinition{
+			Query: func(env Environment) RecordSet {
+				return env.Pool("User").SearchAll()
+			},
+			Fields: map[string]string{"City": "Profile.City"},
+		})
+
+		cityView.fields.add(&Field{
+			model:       cityView,
+			name:        "City",
+			json:        "city",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		cityView.SetView(ViewDefinition{
+			SQL:          `SELECT min(id) AS id, city FROM user_view WHERE city <> '' GROUP BY city`,
+			DependsOn:    []string{"UserView"},
+			Materialized: true,
+		})
 
 		wizard.fields.add(&Field{
 			model:       wizard,
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/gleke/hexya/src/models/fieldtype"
+	"github.com/gleke/hexya/src/models/security"
 	"github.com/gleke/hexya/src/models/types"
 	"github.com/gleke/hexya/src/models/types/dates"
 	"github.com/gleke/hexya/src/tools/nbutils"
@@ -199,6 +200,10 @@ func TestFieldModification(t *testing.T) {
 			return types.Selection{"1": "Yes", "2": "No"}
 		})
 		visibilityField.SetSelectionFunc(nil)
+		visibilityField.SetSelectionEnvFunc(func(Environment) types.Selection {
+			return types.Selection{"1": "Yes", "2": "No"}
+		})
+		visibilityField.SetSelectionEnvFunc(nil)
 	})
 }
 
@@ -373,7 +378,13 @@ func TestBootStrap(t *testing.T) {
 		})
 		Convey("Bootstrap should not panic", func() {
 			BootStrap()
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(SchemaCreated(env), ShouldBeFalse)
+			}), ShouldBeNil)
 			SyncDatabase()
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(SchemaCreated(env), ShouldBeTrue)
+			}), ShouldBeNil)
 		})
 		Convey("Boostrapping twice should panic", func() {
 			So(BootStrapped(), ShouldBeTrue)
@@ -384,15 +395,18 @@ func TestBootStrap(t *testing.T) {
 				Registry.MustGet("User").NewMethod("NewMethod", func(rc *RecordCollection) {})
 			}, ShouldPanic)
 		})
-		Convey("Creating SQL view should run fine", func() {
-			So(func() {
-				dbExecuteNoTx(`DROP VIEW IF EXISTS user_view;
-					CREATE VIEW user_view AS (
-						SELECT u.id, u.name, p.city, u.active
-						FROM "user" u
-							LEFT JOIN "profile" p ON p.id = u.profile_id
-					)`)
-			}, ShouldNotPanic)
+		Convey("SQL views should have been created", func() {
+			views := TestAdapter.views()
+			So(views, ShouldContainKey, "user_view")
+			So(views["user_view"].Materialized, ShouldBeFalse)
+			So(views, ShouldContainKey, "city_view")
+			So(views["city_view"].Materialized, ShouldBeTrue)
+			So(TestAdapter.indexExists("city_view", "city_view_id_viewidx"), ShouldBeTrue)
+			var viewNames []string
+			for _, model := range viewModels() {
+				viewNames = append(viewNames, model.name)
+			}
+			So(viewNames, ShouldResemble, []string{"UserView", "CityView"})
 		})
 		Convey("All models should have a DB table", func() {
 			dbTables := TestAdapter.tables()
@@ -412,10 +426,41 @@ func TestBootStrap(t *testing.T) {
 			So(TestAdapter.constraints("%_mancon"), ShouldHaveLength, 1)
 			So(TestAdapter.constraints("%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
 		})
+		Convey("Model indexes should have been created", func() {
+			indexes := TestAdapter.modelIndexes("user")
+			So(indexes, ShouldHaveLength, 2)
+			So(indexes, ShouldContainKey, "lower_name_user_manidx")
+			So(indexes["lower_name_user_manidx"], ShouldEqual, "((lower(name)))")
+			So(indexes, ShouldContainKey, "active_email_user_manidx")
+			So(indexes["active_email_user_manidx"], ShouldEqual, "USING btree (email, name DESC) WHERE is_active")
+		})
 		Convey("Boot Sequence should be created", func() {
 			So(TestAdapter.sequences("%_bootseq"), ShouldHaveLength, 1)
 			So(TestAdapter.sequences("%_bootseq")[0].Name, ShouldEqual, "test_sequence_bootseq")
 		})
+		Convey("Planning database sync after sync should give an empty plan", func() {
+			plan := PlanDatabaseSync()
+			So(plan.IsEmpty(), ShouldBeTrue)
+			So(plan.SQL(), ShouldBeEmpty)
+		})
+		Convey("Safe mode should keep unused tables until pruned", func() {
+			dbExecuteNoTx("CREATE TABLE IF NOT EXISTS shouldbekept (id serial NOT NULL PRIMARY KEY)")
+			SetSyncOptions(SyncOptions{SafeMode: true})
+			plan := PlanDatabaseSync()
+			So(plan, ShouldHaveLength, 1)
+			So(plan[0].Type, ShouldEqual, SchemaKeepTable)
+			So(plan[0].Table, ShouldEqual, "shouldbekept")
+			So(plan.SQL(), ShouldBeEmpty)
+			SyncDatabase()
+			So(TestAdapter.tables(), ShouldContainKey, "shouldbekept")
+			SetSyncOptions(SyncOptions{SafeMode: true, Prune: true})
+			plan = PlanDatabaseSync()
+			So(plan, ShouldHaveLength, 1)
+			So(plan[0].Type, ShouldEqual, SchemaDropTable)
+			SyncDatabase()
+			So(TestAdapter.tables(), ShouldNotContainKey, "shouldbekept")
+			SetSyncOptions(SyncOptions{})
+		})
 		Convey("Manual sequences should be loaded in registry", func() {
 			So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 1)
 			So(TestAdapter.sequences("%_manseq")[0].Name, ShouldEqual, "test_manseq")
@@ -445,11 +490,124 @@ func TestBootStrap(t *testing.T) {
 			})
 			textField := Registry.MustGet("Comment").Fields().MustGet("Text")
 			textField.SetFieldType(fieldtype.Text)
+			dbExecuteNoTx(`ALTER TABLE comment ADD COLUMN old_rating integer`)
+			dbExecuteNoTx(`CREATE INDEX comment_old_rating_index ON comment (old_rating)`)
+			Registry.MustGet("Comment").fields.add(&Field{
+				model:       Registry.MustGet("Comment"),
+				name:        "Rating",
+				json:        "rating",
+				fieldType:   fieldtype.Integer,
+				structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+				index:       true,
+				oldNames:    []string{"OldRating"},
+			})
+			dbExecuteNoTx(`CREATE TABLE old_label (id serial NOT NULL PRIMARY KEY, name varchar)`)
+			dbExecuteNoTx(`INSERT INTO old_label (name) VALUES ('Golang')`)
+			dbExecuteNoTx(`CREATE TABLE old_label_post_rel (id serial NOT NULL PRIMARY KEY, old_label_id integer, post_id integer)`)
+			label := NewModel("Label")
+			label.SetOldNames("OldLabel")
+			label.fields.add(&Field{
+				model:       label,
+				name:        "Name",
+				json:        "name",
+				fieldType:   fieldtype.Char,
+				structField: reflect.StructField{Type: reflect.TypeOf("")},
+			})
+			m2mRelModel, m2mOurField, m2mTheirField := CreateM2MRelModelInfo("LabelPostRel", "Label", "Post", "Label", "Post", false)
+			label.fields.add(&Field{
+				model:            label,
+				name:             "Posts",
+				json:             "posts_ids",
+				fieldType:        fieldtype.Many2Many,
+				structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+				relatedModelName: "Post",
+				m2mRelModel:      m2mRelModel,
+				m2mOurField:      m2mOurField,
+				m2mTheirField:    m2mTheirField,
+			})
+			dbExecuteNoTx(`COMMENT ON VIEW user_view IS 'SELECT 1'`)
+			cityField := Registry.MustGet("Profile").Fields().MustGet("City")
+			cityField.SetFieldType(fieldtype.Text)
+			villageView := NewManualModel("VillageView")
+			villageView.fields.add(&Field{
+				model:       villageView,
+				name:        "City",
+				json:        "city",
+				fieldType:   fieldtype.Char,
+				structField: reflect.StructField{Type: reflect.TypeOf("")},
+			})
+			villageView.SetView(ViewDefinition{
+				SQL: `SELECT id, city FROM city_view`,
+			})
+			dbExecuteNoTx(`CREATE VIEW village_view AS SELECT id, city FROM city_view`)
+			dbExecuteNoTx(`COMMENT ON VIEW village_view IS 'SELECT id, city FROM city_view'`)
+			dbExecuteNoTx(`CREATE VIEW orphan_view AS SELECT 1 AS id`)
+			userModel := Registry.MustGet("User")
+			userModel.RemoveIndex("lower_name")
+			userModel.AddIndex("active_email", []string{"Email"}, "is_active AND email <> '?'", "btree")
 			So(BootStrap, ShouldNotPanic)
+			plan := PlanDatabaseSync()
+			var indexChanges []SchemaChangeType
+			for _, change := range plan {
+				if change.Table == "user" && strings.HasSuffix(change.Name, "_manidx") {
+					indexChanges = append(indexChanges, change.Type)
+				}
+			}
+			So(indexChanges, ShouldResemble, []SchemaChangeType{SchemaDropIndex, SchemaCreateIndex, SchemaSetComment, SchemaDropIndex})
+			var viewChanges []string
+			for _, change := range plan {
+				if strings.HasSuffix(change.Table, "_view") || change.Type == SchemaAlterColumnType && change.Table == "profile" {
+					viewChanges = append(viewChanges, fmt.Sprintf("%s %s", change.Type, change.Table))
+				}
+			}
+			So(viewChanges, ShouldResemble, []string{
+				"drop view city_view", "drop view user_view", "alter column type profile",
+				"create view user_view", "set comment user_view",
+				"create view city_view", "set comment city_view", "create index city_view",
+				"create view village_view", "set comment village_view",
+				"drop view orphan_view",
+			})
+			So(plan.SQL(), ShouldContainSubstring, `COMMENT ON INDEX active_email_user_manidx IS 'USING btree (email) WHERE is_active AND email <> ''?'''`)
 			So(contentField.required, ShouldBeFalse)
 			So(profileField.required, ShouldBeFalse)
 			So(numsField.index, ShouldBeFalse)
 			So(SyncDatabase, ShouldNotPanic)
+			commentColumns := TestAdapter.columns("comment")
+			So(commentColumns, ShouldContainKey, "rating")
+			So(commentColumns, ShouldNotContainKey, "old_rating")
+			So(TestAdapter.indexExists("comment", "comment_rating_index"), ShouldBeTrue)
+			So(TestAdapter.indexExists("comment", "comment_old_rating_index"), ShouldBeFalse)
+			views := TestAdapter.views()
+			So(views["user_view"].Comment, ShouldEqual, Registry.MustGet("UserView").viewSQL())
+			So(views["city_view"].Comment, ShouldEqual, Registry.MustGet("CityView").viewSQL())
+			So(views["village_view"].Comment, ShouldEqual, "SELECT id, city FROM city_view")
+			So(views, ShouldNotContainKey, "orphan_view")
+			So(TestAdapter.indexExists("city_view", "city_view_id_viewidx"), ShouldBeTrue)
+			So(TestAdapter.columns("profile")["city"].DataType, ShouldEqual, "text")
+			userIndexes := TestAdapter.modelIndexes("user")
+			So(userIndexes, ShouldHaveLength, 1)
+			So(userIndexes, ShouldNotContainKey, "lower_name_user_manidx")
+			So(userIndexes["active_email_user_manidx"], ShouldEqual, "USING btree (email) WHERE is_active AND email <> '?'")
+			tables := TestAdapter.tables()
+			So(tables, ShouldContainKey, "label")
+			So(tables, ShouldNotContainKey, "old_label")
+			So(TestAdapter.sequenceExists("label_id_seq"), ShouldBeTrue)
+			So(TestAdapter.sequenceExists("old_label_id_seq"), ShouldBeFalse)
+			So(TestAdapter.constraintExists("label_pkey"), ShouldBeTrue)
+			So(TestAdapter.constraintExists("old_label_pkey"), ShouldBeFalse)
+			var name string
+			dbGetNoTx(&name, `SELECT name FROM label`)
+			So(name, ShouldEqual, "Golang")
+			var nextID int64
+			dbGetNoTx(&nextID, `SELECT nextval('label_id_seq')`)
+			So(nextID, ShouldEqual, 2)
+			So(tables, ShouldContainKey, "label_post_rel")
+			So(tables, ShouldNotContainKey, "old_label_post_rel")
+			So(TestAdapter.sequenceExists("label_post_rel_id_seq"), ShouldBeTrue)
+			linkColumns := TestAdapter.columns("label_post_rel")
+			So(linkColumns, ShouldContainKey, "label_id")
+			So(linkColumns, ShouldContainKey, "post_id")
+			So(linkColumns, ShouldNotContainKey, "old_label_id")
 		})
 	})
 
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/gleke/hexya/src/models/security"
+	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -62,6 +63,13 @@ var (
 	tagsName                 = fieldName{name: "Tags.Name", json: "tags_ids.name"}
 	description              = fieldName{name: "Description", json: "description"}
 	rate                     = fieldName{name: "Rate", json: "rate"}
+	keywords                 = fieldName{name: "Keywords", json: "keywords"}
+	scores                   = fieldName{name: "Scores", json: "scores"}
+	secret                   = fieldName{name: "Secret", json: "secret"}
+	code                     = fieldName{name: "Code", json: "code"}
+	readingTime              = fieldName{name: "ReadingTime", json: "reading_time"}
+	gender                   = fieldName{name: "Gender", json: "gender"}
+	publishTime              = fieldName{name: "PublishTime", json: "publish_time"}
 	comments                 = fieldName{name: "Comments", json: "comments_ids"}
 	experience               = fieldName{name: "Experience", json: "experience"}
 	leisure                  = fieldName{name: "Leisure", json: "leisure"}
@@ -69,6 +77,9 @@ var (
 	lastPost                 = fieldName{name: "LastPost", json: "last_post_id"}
 	lastTagName              = fieldName{name: "LastTagName", json: "last_tag_name"}
 	lastCommentText          = fieldName{name: "LastCommentText", json: "last_comment_text"}
+	company                  = fieldName{name: "Company", json: "company_id"}
+	post                     = fieldName{name: "Post", json: "post_id"}
+	priority                 = fieldName{name: "Priority", json: "priority"}
 	postsTitle               = fieldName{name: "Posts.Title", json: "posts_ids.title"}
 	postsTags                = fieldName{name: "Posts.Tags", json: "posts_ids.tags_ids"}
 	bestPostTitle            = fieldName{name: "BestPost.Title", json: "best_post_id.title"}
@@ -167,6 +178,26 @@ func TestConditions(t *testing.T) {
 					sql, _, _ := rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user"   ORDER BY "user".id ) foo  `)
 				})
+				Convey("Testing conditions on array fields", func() {
+					tagModel := env.Pool("Tag").Model()
+					rsTags := env.Pool("Tag").Search(tagModel.Field(keywords).Contains("go").
+						And().Field(scores).Overlaps([]int64{1, 2}).
+						And().Field(keywords).In([]string{"go", "sql"}))
+					sql, args := rsTags.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "tag".keywords @> ? AND "tag".scores && ? AND "tag".keywords <@ ?`)
+					So(args, ShouldHaveLength, 3)
+					So(args[0], ShouldResemble, pq.Array([]string{"go"}))
+					So(args[1], ShouldResemble, pq.Array([]int64{1, 2}))
+					So(args[2], ShouldResemble, pq.Array([]string{"go", "sql"}))
+					rsTags = env.Pool("Tag").Search(tagModel.Field(keywords).NotContains("go"))
+					sql, _ = rsTags.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("tag".keywords IS NULL OR "tag".keywords @> ? IS NOT TRUE)`)
+					rsTags = env.Pool("Tag").Search(tagModel.Field(keywords).IsNull())
+					sql, _ = rsTags.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("tag".keywords IS NULL OR "tag".keywords = ?)`)
+					rsTags = env.Pool("Tag").Search(tagModel.Field(Name).Overlaps([]string{"go"}))
+					So(func() { rsTags.query.sqlWhereClause(true) }, ShouldPanic)
+				})
 				Convey("Testing query with LIMIT clause", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).Call("Limit", 1).(RecordSet).Collection().Load()
 					fields = []FieldName{Name}
@@ -16,8 +16,11 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gleke/hexya/src/models/security"
+	"github.com/gleke/hexya/src/models/types"
+	"github.com/gleke/hexya/src/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -341,6 +344,15 @@ func TestSearchRecordSet(t *testing.T) {
 				So(recs[1].Get(city), ShouldEqual, "")
 				So(recs[2].Get(city), ShouldEqual, "")
 			})
+			Convey("Testing materialized view refresh", func() {
+				cityViews := env.Pool("CityView").SearchAll()
+				So(cityViews.Len(), ShouldEqual, 0)
+				cityViews.RefreshMaterializedView(false)
+				cityViews = env.Pool("CityView").SearchAll()
+				So(cityViews.Len(), ShouldEqual, 1)
+				So(cityViews.Get(city), ShouldEqual, "New York")
+				So(func() { env.Pool("UserView").RefreshMaterializedView(false) }, ShouldPanic)
+			})
 			Convey("Testing browse with empty ids", func() {
 				var ids []int64
 				users := env.Pool("User").Model().Browse(env, ids)
@@ -595,6 +607,218 @@ func TestGroupedQueries(t *testing.T) {
 	})
 }
 
+func TestArrayFields(t *testing.T) {
+	Convey("Testing array fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tagGo := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Go").
+				Set(keywords, []string{"go", "golang"}).
+				Set(scores, []int64{1, 5})).(RecordSet).Collection()
+			tagSQL := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "SQL").
+				Set(keywords, []string{"sql", "postgres"}).
+				Set(scores, []int64{3})).(RecordSet).Collection()
+			Convey("Reading array fields", func() {
+				tagGo.InvalidateCache()
+				So(tagGo.Get(keywords), ShouldResemble, []string{"go", "golang"})
+				So(tagGo.Get(scores), ShouldResemble, []int64{1, 5})
+			})
+			Convey("Searching array fields", func() {
+				So(env.Pool("Tag").Search(tagModel.Field(keywords).Contains("golang")).Ids(), ShouldResemble, tagGo.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(scores).Overlaps([]int64{3, 4})).Ids(), ShouldResemble, tagSQL.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(keywords).In([]string{"sql", "postgres", "mysql"})).Ids(), ShouldResemble, tagSQL.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(keywords).NotContains("go").And().Field(scores).IsNotNull()).Ids(), ShouldResemble, tagSQL.Ids())
+			})
+			Convey("Updating array fields", func() {
+				tagSQL.Set(scores, []int64{3, 4})
+				tagSQL.InvalidateCache()
+				So(tagSQL.Get(scores), ShouldResemble, []int64{3, 4})
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestEncryptedFields(t *testing.T) {
+	Convey("Testing encrypted fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tag := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Encrypted").
+				Set(secret, "my very long secret").
+				Set(code, "FR7630006000011234567890189")).(RecordSet).Collection()
+			Convey("Values should be encrypted in database", func() {
+				var dbSecret, dbCode string
+				env.Cr().Get(&dbSecret, `SELECT secret FROM tag WHERE id = ?`, tag.Ids()[0])
+				env.Cr().Get(&dbCode, `SELECT code FROM tag WHERE id = ?`, tag.Ids()[0])
+				So(dbSecret, ShouldStartWith, "$aes-gcm$")
+				So(dbSecret, ShouldNotContainSubstring, "secret")
+				So(dbCode, ShouldStartWith, "$aes-gcm$")
+			})
+			Convey("Values should be decrypted when read", func() {
+				tag.InvalidateCache()
+				So(tag.Get(secret), ShouldEqual, "my very long secret")
+				So(tag.Get(code), ShouldEqual, "FR7630006000011234567890189")
+			})
+			Convey("Deterministic fields can be searched by equality", func() {
+				So(env.Pool("Tag").Search(tagModel.Field(code).Equals("FR7630006000011234567890189")).Ids(), ShouldResemble, tag.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(code).In([]string{"FR7630006000011234567890189", "other"})).Ids(), ShouldResemble, tag.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(code).Equals("other")).IsEmpty(), ShouldBeTrue)
+				So(func() { env.Pool("Tag").Search(tagModel.Field(code).Contains("FR76")).Load() }, ShouldPanic)
+			})
+			Convey("Non deterministic fields cannot be searched", func() {
+				So(func() { env.Pool("Tag").Search(tagModel.Field(secret).Equals("my very long secret")).Load() }, ShouldPanic)
+				So(env.Pool("Tag").Search(tagModel.Field(secret).IsNotNull()).Ids(), ShouldResemble, tag.Ids())
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestDurationAndTimeFields(t *testing.T) {
+	Convey("Testing duration and time fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tag1 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Short Read").
+				Set(description, "Timed").
+				Set(readingTime, dates.NewDuration(0, 45, 0)).
+				Set(publishTime, dates.NewTime(8, 30, 0))).(RecordSet).Collection()
+			tag2 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Long Read").
+				Set(description, "Timed").
+				Set(readingTime, dates.NewDuration(26, 15, 30))).(RecordSet).Collection()
+			Convey("Values should be read back from the database", func() {
+				tag1.InvalidateCache()
+				tag2.InvalidateCache()
+				So(tag1.Get(readingTime).(dates.Duration).Equal(dates.NewDuration(0, 45, 0)), ShouldBeTrue)
+				So(tag2.Get(readingTime).(dates.Duration).Equal(dates.NewDuration(26, 15, 30)), ShouldBeTrue)
+				So(tag1.Get(publishTime).(dates.Time).Equal(dates.NewTime(8, 30, 0)), ShouldBeTrue)
+				So(tag2.Get(publishTime).(dates.Time).IsZero(), ShouldBeTrue)
+			})
+			Convey("Conditions on duration and time fields", func() {
+				So(env.Pool("Tag").Search(tagModel.Field(readingTime).Greater(dates.NewDuration(1, 0, 0))).Ids(), ShouldResemble, tag2.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(publishTime).Lower(dates.NewTime(9, 0, 0))).Ids(), ShouldResemble, tag1.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(description).Equals("Timed").
+					And().Field(publishTime).IsNull()).Ids(), ShouldResemble, tag2.Ids())
+			})
+			Convey("Midnight should be stored and searched as a valid time", func() {
+				tag3 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, "Midnight Read").
+					Set(description, "Midnight").
+					Set(publishTime, dates.NewTime(0, 0, 0))).(RecordSet).Collection()
+				tag3.InvalidateCache()
+				So(tag3.Get(publishTime).(dates.Time).IsZero(), ShouldBeFalse)
+				So(tag3.Get(publishTime).(dates.Time).Equal(dates.NewTime(0, 0, 0)), ShouldBeTrue)
+				So(env.Pool("Tag").Search(tagModel.Field(description).Equals("Midnight").
+					And().Field(publishTime).Equals(dates.NewTime(0, 0, 0))).Ids(), ShouldResemble, tag3.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(description).Equals("Midnight").
+					And().Field(publishTime).IsNull()).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Durations should be summed in aggregates", func() {
+				groups := env.Pool("Tag").Search(tagModel.Field(description).Equals("Timed")).
+					GroupBy(description).Aggregates(description, readingTime)
+				So(groups, ShouldHaveLength, 1)
+				So(groups[0].Count, ShouldEqual, 2)
+				So(groups[0].Values.Get(readingTime).(dates.Duration).Equal(dates.NewDuration(27, 0, 30)), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTimezones(t *testing.T) {
+	Convey("Testing timezone handling", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			Convey("Environment timezone should come from context", func() {
+				So(env.Timezone(), ShouldEqual, time.UTC)
+				paris, _ := time.LoadLocation("Europe/Paris")
+				parisEnv := env.Pool("Tag").WithContext("tz", "Europe/Paris").Env()
+				So(parisEnv.Timezone().String(), ShouldEqual, "Europe/Paris")
+				So(parisEnv.Today().Equal(dates.TodayIn(paris)), ShouldBeTrue)
+				So(env.Pool("Tag").WithContext("tz", "Nowhere/Unknown").Env().Timezone(), ShouldEqual, time.UTC)
+			})
+			Convey("DateTime values should be stored in UTC", func() {
+				paris, _ := time.LoadLocation("Europe/Paris")
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, "TZ Tag")).(RecordSet).Collection()
+				tag.Set(createDate, dates.ParseDateTime("2020-03-01 00:30:00").In(paris))
+				var dbDate time.Time
+				env.Cr().Get(&dbDate, `SELECT create_date FROM tag WHERE id = ?`, tag.Ids()[0])
+				So(dbDate.Format(dates.DefaultServerDateTimeFormat), ShouldEqual, "2020-02-29 23:30:00")
+				tag.InvalidateCache()
+				So(tag.Get(createDate).(dates.DateTime).Location(), ShouldEqual, time.UTC)
+				So(tag.Get(createDate).(dates.DateTime).ToUserTZ(tag.WithContext("tz", "Europe/Paris").Env()).String(),
+					ShouldEqual, "2020-03-01 00:30:00")
+			})
+			Convey("Grouping by date part should use the user's timezone", func() {
+				tag1 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, "Late Tag").
+					Set(description, "TZ")).(RecordSet).Collection()
+				tag2 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, "Early Tag").
+					Set(description, "TZ")).(RecordSet).Collection()
+				env.Cr().Execute(`UPDATE tag SET create_date = ? WHERE id = ?`, "2020-02-29 23:30:00", tag1.Ids()[0])
+				env.Cr().Execute(`UPDATE tag SET create_date = ? WHERE id = ?`, "2020-02-29 22:30:00", tag2.Ids()[0])
+				cond := tagModel.Field(description).Equals("TZ")
+				utcGroups := env.Pool("Tag").Search(cond).GroupByDatePart(createDate, "month").Aggregates(createDate)
+				So(utcGroups, ShouldHaveLength, 1)
+				So(utcGroups[0].Count, ShouldEqual, 2)
+				parisGroups := env.Pool("Tag").WithContext("tz", "Europe/Paris").Search(cond).
+					GroupByDatePart(createDate, "month").Aggregates(createDate)
+				So(parisGroups, ShouldHaveLength, 2)
+				So(parisGroups[0].Values.Get(createDate).(dates.DateTime).String(), ShouldEqual, "2020-01-31 23:00:00")
+				So(parisGroups[1].Values.Get(createDate).(dates.DateTime).String(), ShouldEqual, "2020-02-29 23:00:00")
+				So(env.Pool("Tag").Search(parisGroups[1].Condition).Ids(), ShouldResemble, tag1.Ids())
+				So(func() { env.Pool("Tag").GroupByDatePart(Name, "month") }, ShouldPanic)
+				So(func() { env.Pool("Tag").GroupByDatePart(createDate, "century") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestSelectionFields(t *testing.T) {
+	Convey("Testing selection fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profileModel := Registry.MustGet("Profile")
+			genderField := profileModel.Fields().MustGet("Gender")
+			Convey("Written values should be checked against the selection", func() {
+				So(func() {
+					env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "m"))
+				}, ShouldNotPanic)
+				So(func() {
+					env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "x"))
+				}, ShouldPanic)
+				profile := env.Pool("Profile").Call("Create", NewModelData(profileModel)).(RecordSet).Collection()
+				So(func() { profile.Set(gender, "f") }, ShouldNotPanic)
+				So(func() { profile.Set(gender, "female") }, ShouldPanic)
+				So(func() { profile.Set(gender, "") }, ShouldNotPanic)
+			})
+			Convey("Selection functions should receive the environment", func() {
+				genderField.selectionEnvFunc = func(env Environment) types.Selection {
+					if env.Context().GetString("lang") == "fr_FR" {
+						return types.Selection{"h": "Homme", "f": "Femme"}
+					}
+					return types.Selection{"m": "Male", "f": "Female"}
+				}
+				defer func() {
+					genderField.selectionEnvFunc = nil
+				}()
+				frProfiles := env.Pool("Profile").WithContext("lang", "fr_FR")
+				fInfos := frProfiles.Call("FieldsGet", FieldsGetArgs{Fields: []FieldName{gender}}).(map[string]*FieldInfo)
+				So(fInfos["gender"].Selection, ShouldResemble, types.Selection{"h": "Homme", "f": "Femme"})
+				fInfos = env.Pool("Profile").Call("FieldsGet", FieldsGetArgs{Fields: []FieldName{gender}}).(map[string]*FieldInfo)
+				So(fInfos["gender"].Selection, ShouldResemble, types.Selection{"m": "Male", "f": "Female"})
+				So(func() {
+					frProfiles.Call("Create", NewModelData(profileModel).Set(gender, "h"))
+				}, ShouldNotPanic)
+				So(func() {
+					env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "h"))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestUpdateRecordSet(t *testing.T) {
 	Convey("Testing updates through RecordSets", t, func() {
 		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -836,6 +1060,329 @@ func TestUpdateRecordSet(t *testing.T) {
 	security.Registry.UnregisterGroup(group1)
 }
 
+func TestFieldAccessControl(t *testing.T) {
+	group1 := security.Registry.NewGroup("group1", "Group 1")
+	Convey("Testing field access control", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			descField := tagModel.fields.MustGet("Description")
+			descField.AllowRead(group1).AllowWrite(group1)
+			defer func() {
+				descField.readGroups = nil
+				descField.writeGroups = nil
+			}()
+			tag := env.Pool("Tag").Sudo().Call("Create", NewModelData(tagModel).
+				Set(Name, "Restricted").
+				Set(description, "Restricted description")).(RecordSet).Collection().WithEnv(env)
+			Convey("Restricted fields cannot be read", func() {
+				tag.InvalidateCache()
+				So(tag.Get(Name), ShouldEqual, "Restricted")
+				So(tag.Get(description), ShouldEqual, "")
+				res := tag.Call("Read", []FieldName{Name, description}).([]RecordData)
+				So(res[0].Underlying().FieldMap, ShouldContainKey, "name")
+				So(res[0].Underlying().FieldMap, ShouldNotContainKey, "description")
+				So(tag.Sudo().Get(description), ShouldEqual, "Restricted description")
+			})
+			Convey("Restricted fields cannot be searched", func() {
+				So(func() { env.Pool("Tag").Call("Search", tagModel.Field(description).Equals("Restricted description")) }, ShouldPanic)
+				So(env.Pool("Tag").Sudo().Call("Search", tagModel.Field(description).Equals("Restricted description")).(RecordSet).Collection().Ids(), ShouldResemble, tag.Ids())
+			})
+			Convey("Restricted fields cannot be grouped, ordered or aggregated", func() {
+				So(func() { env.Pool("Tag").SearchAll().Call("GroupBy", []FieldName{description}) }, ShouldPanic)
+				So(func() { env.Pool("Tag").SearchAll().Call("OrderBy", []string{"Description desc"}) }, ShouldPanic)
+				So(func() { env.Pool("Tag").SearchAll().GroupBy(description).Aggregates(description) }, ShouldPanic)
+				So(func() { env.Pool("Tag").SearchAll().GroupBy(Name).Aggregates(Name, description) }, ShouldPanic)
+				So(func() { env.Pool("Tag").SearchAll().Call("GroupBy", []FieldName{Name}) }, ShouldNotPanic)
+				So(func() { env.Pool("Tag").SearchAll().Call("OrderBy", []string{"Name desc"}) }, ShouldNotPanic)
+				So(func() { env.Pool("Tag").SearchAll().GroupBy(Name).Aggregates(Name) }, ShouldNotPanic)
+				So(func() { env.Pool("Tag").Sudo().SearchAll().GroupBy(description).Aggregates(description) }, ShouldNotPanic)
+			})
+			Convey("Restricted fields are hidden from FieldsGet", func() {
+				fInfos := env.Pool("Tag").Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+				So(fInfos, ShouldContainKey, "name")
+				So(fInfos, ShouldNotContainKey, "description")
+			})
+			Convey("Restricted fields cannot be written", func() {
+				So(func() { tag.Set(description, "New description") }, ShouldPanic)
+				So(func() { tag.Set(Name, "Still Restricted") }, ShouldNotPanic)
+				So(func() { tag.Sudo().Set(description, "New description") }, ShouldNotPanic)
+			})
+			Convey("Restricted fields cannot be set at creation", func() {
+				So(func() {
+					env.Pool("Tag").Call("Create", NewModelData(tagModel).
+						Set(Name, "Restricted 2").
+						Set(description, "Restricted description 2"))
+				}, ShouldPanic)
+				So(func() {
+					env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Restricted 2"))
+				}, ShouldNotPanic)
+			})
+			Convey("Constraints are checked with restricted fields", func() {
+				So(func() { tag.Set(Name, "Restricted description") }, ShouldPanic)
+			})
+			Convey("Stored fields are computed with restricted fields", func() {
+				profileModel := Registry.MustGet("Profile")
+				userModel := Registry.MustGet("User")
+				profileModel.Methods().AllowAllToGroup(security.GroupEveryone)
+				userModel.Methods().AllowAllToGroup(security.GroupEveryone)
+				ageField := profileModel.fields.MustGet("Age")
+				ageField.AllowRead(group1)
+				defer func() {
+					profileModel.Methods().RevokeAllFromGroup(security.GroupEveryone)
+					userModel.Methods().RevokeAllFromGroup(security.GroupEveryone)
+					ageField.readGroups = nil
+				}()
+				jane := env.Pool("User").Sudo().Search(userModel.Field(Name).Equals("Jane Smith"))
+				janeProfile := jane.Get(profile).(RecordSet).Collection().WithEnv(env)
+				janeProfile.Set(age, int16(41))
+				So(janeProfile.Get(age), ShouldEqual, int16(0))
+				So(jane.Get(age), ShouldEqual, int16(41))
+			})
+			Convey("Members of the group can read and write restricted fields", func() {
+				security.Registry.AddMembership(2, group1)
+				defer security.Registry.RemoveMembership(2, group1)
+				tag.InvalidateCache()
+				So(tag.Get(description), ShouldEqual, "Restricted description")
+				So(func() { tag.Set(description, "New description") }, ShouldNotPanic)
+				So(tag.Get(description), ShouldEqual, "New description")
+				fInfos := env.Pool("Tag").Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+				So(fInfos, ShouldContainKey, "description")
+			})
+		}), ShouldBeNil)
+	})
+	security.Registry.UnregisterGroup(group1)
+}
+
+func TestDynamicRecordRules(t *testing.T) {
+	group1 := security.Registry.NewGroup("group1", "Group 1")
+	security.Registry.AddMembership(2, group1)
+	security.Registry.AddMembership(3, group1)
+	Convey("Testing record rules with a condition function", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			userModel.methods.MustGet("Load").AllowGroup(group1)
+			var calls int
+			userModel.AddRecordRule(&RecordRule{
+				Name:  "ownUser",
+				Group: group1,
+				ConditionFunc: func(env Environment) *Condition {
+					calls++
+					So(env.Pool("User").SearchAll().Len(), ShouldBeGreaterThan, 1)
+					if env.Uid() == 2 {
+						return userModel.Field(Name).Equals("Jane Smith")
+					}
+					return userModel.Field(Name).Equals("Will Smith")
+				},
+				Perms: security.Read,
+			})
+			defer func() {
+				userModel.RemoveRecordRule("ownUser")
+				userModel.methods.MustGet("Load").RevokeGroup(group1)
+			}()
+			users := env.Pool("User").SearchAll()
+			So(users.Len(), ShouldEqual, 1)
+			So(users.Get(Name), ShouldEqual, "Jane Smith")
+			So(calls, ShouldEqual, 1)
+			users = env.Pool("User").SearchAll()
+			So(users.Len(), ShouldEqual, 1)
+			So(calls, ShouldEqual, 1)
+			users = env.Pool("User").Sudo(3).SearchAll()
+			So(users.Len(), ShouldEqual, 1)
+			So(users.Get(Name), ShouldEqual, "Will Smith")
+			So(calls, ShouldEqual, 2)
+			explanation := userModel.ExplainRecordRules(env, 2, security.Read)
+			So(explanation.Condition.String(), ShouldContainSubstring, "Jane Smith")
+			So(calls, ShouldEqual, 2)
+		}), ShouldBeNil)
+	})
+	security.Registry.UnregisterGroup(group1)
+}
+
+func TestExplainRecordRules(t *testing.T) {
+	group1 := security.Registry.NewGroup("group1", "Group 1")
+	security.Registry.AddMembership(2, group1)
+	Convey("Testing record rules explanation", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith")).Fetch()
+			will := env.Pool("User").Search(userModel.Field(Name).Equals("Will Smith")).Fetch()
+			janeActive := jane.Get(isActive).(bool)
+			userModel.AddRecordRule(&RecordRule{
+				Name:      "jOnly",
+				Group:     group1,
+				Condition: userModel.Field(Name).IContains("j"),
+				Perms:     security.Read,
+			})
+			userModel.AddRecordRule(&RecordRule{
+				Name:      "activeOnly",
+				Global:    true,
+				Condition: userModel.Field(isActive).Equals(true),
+				Perms:     security.Read | security.Write,
+			})
+			userModel.AddRecordRule(&RecordRule{
+				Name:      "unlinkRule",
+				Group:     group1,
+				Condition: userModel.Field(Name).Equals("Nobody"),
+				Perms:     security.Unlink,
+			})
+			defer func() {
+				userModel.RemoveRecordRule("jOnly")
+				userModel.RemoveRecordRule("activeOnly")
+				userModel.RemoveRecordRule("unlinkRule")
+			}()
+			Convey("Applicable rules and condition", func() {
+				explanation := userModel.ExplainRecordRules(env, 2, security.Read)
+				So(explanation.GlobalRules, ShouldHaveLength, 1)
+				So(explanation.GlobalRules[0].Name, ShouldEqual, "activeOnly")
+				So(explanation.GroupRules, ShouldHaveLength, 1)
+				So(explanation.GroupRules[0].Name, ShouldEqual, "jOnly")
+				So(explanation.Condition.String(), ShouldContainSubstring, "Name")
+				So(explanation.Condition.String(), ShouldContainSubstring, "IsActive")
+				So(explanation.Records, ShouldBeEmpty)
+				explanation = userModel.ExplainRecordRules(env, 3, security.Read)
+				So(explanation.GroupRules, ShouldBeEmpty)
+				explanation = userModel.ExplainRecordRules(env, 2, security.Unlink)
+				So(explanation.GlobalRules, ShouldBeEmpty)
+				So(explanation.GroupRules[0].Name, ShouldEqual, "unlinkRule")
+			})
+			Convey("Records verdicts", func() {
+				explanation := userModel.ExplainRecordRules(env, 2, security.Read, jane.Ids()[0], will.Ids()[0], 999999)
+				So(explanation.Records, ShouldHaveLength, 3)
+				janeVerdict := explanation.Records[0]
+				So(janeVerdict.Exists, ShouldBeTrue)
+				So(janeVerdict.AllowingRules, ShouldContain, "jOnly")
+				So(janeVerdict.Allowed, ShouldEqual, janeActive)
+				willVerdict := explanation.Records[1]
+				So(willVerdict.Exists, ShouldBeTrue)
+				So(willVerdict.Allowed, ShouldBeFalse)
+				So(willVerdict.DenyingRules, ShouldContain, "jOnly")
+				So(explanation.Records[2].Exists, ShouldBeFalse)
+				So(explanation.Records[2].Allowed, ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+	security.Registry.UnregisterGroup(group1)
+}
+
+func TestMultiCompany(t *testing.T) {
+	Convey("Testing multi-company support", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			companyModel := Registry.MustGet("Company")
+			postModel := Registry.MustGet("Post")
+			commentModel := Registry.MustGet("Comment")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			company1 := env.Pool("Company").Call("Create", NewModelData(companyModel).
+				Set(Name, "Company 1")).(RecordSet).Collection()
+			company2 := env.Pool("Company").Call("Create", NewModelData(companyModel).
+				Set(Name, "Company 2")).(RecordSet).Collection()
+			newPost := func(postTitle string, postCompany *RecordCollection) *RecordCollection {
+				data := NewModelData(postModel).
+					Set(user, jane).
+					Set(title, postTitle).
+					Set(content, "Multi-company content")
+				if postCompany != nil {
+					data.Set(company, postCompany)
+				}
+				return env.Pool("Post").Call("Create", data).(RecordSet).Collection()
+			}
+			post1 := newPost("Company 1 Post", company1)
+			post2 := newPost("Company 2 Post", company2)
+			post3 := newPost("Shared Post", nil)
+			Convey("Environment companies", func() {
+				So(env.CompanyID(), ShouldEqual, 0)
+				So(env.AllowedCompanyIDs(), ShouldBeNil)
+				posts := env.Pool("Post").WithContext(CompanyContextKey, company1.Ids()[0])
+				So(posts.Env().CompanyID(), ShouldEqual, company1.Ids()[0])
+				So(posts.Env().AllowedCompanyIDs(), ShouldResemble, []int64{company1.Ids()[0]})
+				posts = posts.WithContext(AllowedCompaniesContextKey, []int64{company1.Ids()[0], company2.Ids()[0]})
+				So(posts.Env().CompanyID(), ShouldEqual, company1.Ids()[0])
+				So(posts.Env().AllowedCompanyIDs(), ShouldHaveLength, 2)
+			})
+			Convey("Records should be filtered by allowed companies", func() {
+				cond := postModel.Field(ID).In(post1.Union(post2).Union(post3).Ids())
+				So(env.Pool("Post").Search(cond).Len(), ShouldEqual, 3)
+				posts := env.Pool("Post").WithContext(CompanyContextKey, company1.Ids()[0]).Search(cond)
+				So(posts.Len(), ShouldEqual, 2)
+				So(posts.Ids(), ShouldContain, post1.Ids()[0])
+				So(posts.Ids(), ShouldContain, post3.Ids()[0])
+				posts = env.Pool("Post").WithContext(CompanyContextKey, company2.Ids()[0]).Search(cond)
+				So(posts.Len(), ShouldEqual, 2)
+				So(posts.Ids(), ShouldContain, post2.Ids()[0])
+				posts = env.Pool("Post").
+					WithContext(AllowedCompaniesContextKey, []int64{company1.Ids()[0], company2.Ids()[0]}).
+					Search(cond)
+				So(posts.Len(), ShouldEqual, 3)
+				explanation := postModel.ExplainRecordRules(env, security.SuperUserID, security.Read)
+				So(explanation.GlobalRules, ShouldHaveLength, 1)
+				So(explanation.GlobalRules[0].Name, ShouldEqual, "hexya_company")
+				So(explanation.Condition.IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Allowed companies should be checked against the companies of the user", func() {
+				ids := post1.Union(post2).Union(post3).Ids()
+				allowedPosts := func(rs *RecordCollection) []int64 {
+					var res []int64
+					for _, verdict := range postModel.ExplainRecordRules(rs.Env(), 2, security.Read, ids...).Records {
+						if verdict.Allowed {
+							res = append(res, verdict.ID)
+						}
+					}
+					return res
+				}
+				So(allowedPosts(env.Pool("Post").WithContext(CompanyContextKey, company1.Ids()[0])), ShouldResemble, post3.Ids())
+				RegisterUserCompaniesFunc(func(env Environment, uid int64) []int64 {
+					So(env.Uid(), ShouldEqual, security.SuperUserID)
+					So(env.AllowedCompanyIDs(), ShouldBeNil)
+					if uid == 2 {
+						return company1.Ids()
+					}
+					return nil
+				})
+				defer RegisterUserCompaniesFunc(nil)
+				So(allowedPosts(env.Pool("Post")), ShouldResemble, []int64{post1.Ids()[0], post3.Ids()[0]})
+				So(allowedPosts(env.Pool("Post").
+					WithContext(AllowedCompaniesContextKey, []int64{company1.Ids()[0], company2.Ids()[0]})),
+					ShouldResemble, []int64{post1.Ids()[0], post3.Ids()[0]})
+				So(allowedPosts(env.Pool("Post").WithContext(CompanyContextKey, company2.Ids()[0])), ShouldResemble, post3.Ids())
+			})
+			Convey("Relations should not point across companies", func() {
+				comment := env.Pool("Comment").Call("Create", NewModelData(commentModel).
+					Set(post, post1).
+					Set(company, company1).
+					Set(text, "Company 1 Comment")).(RecordSet).Collection()
+				So(comment.Get(post).(RecordSet).Collection().Equals(post1), ShouldBeTrue)
+				So(func() {
+					env.Pool("Comment").Call("Create", NewModelData(commentModel).
+						Set(post, post2).
+						Set(company, company1))
+				}, ShouldPanic)
+				So(func() { comment.Set(post, post2) }, ShouldPanic)
+				So(func() { comment.Set(company, company2) }, ShouldPanic)
+				comment.Set(post, post3)
+				So(comment.Get(post).(RecordSet).Collection().Equals(post3), ShouldBeTrue)
+				comment.Set(company, company2)
+				So(comment.Get(company).(RecordSet).Collection().Equals(company2), ShouldBeTrue)
+				sharedComment := env.Pool("Comment").Call("Create", NewModelData(commentModel).
+					Set(post, post2).
+					Set(text, "Shared Comment")).(RecordSet).Collection()
+				So(sharedComment.Get(post).(RecordSet).Collection().Equals(post2), ShouldBeTrue)
+			})
+			Convey("Company dependent fields", func() {
+				comment := env.Pool("Comment").Call("Create", NewModelData(commentModel).
+					Set(text, "Prioritized Comment").
+					Set(priority, 1)).(RecordSet).Collection()
+				comment.WithContext(CompanyContextKey, company1.Ids()[0]).Set(priority, 5)
+				comment.WithContext(CompanyContextKey, company2.Ids()[0]).Set(priority, 3)
+				So(comment.Get(priority), ShouldEqual, 1)
+				So(comment.WithContext(CompanyContextKey, company1.Ids()[0]).Get(priority), ShouldEqual, 5)
+				So(comment.WithContext(CompanyContextKey, company2.Ids()[0]).Get(priority), ShouldEqual, 3)
+				So(commentModel.FieldsGet(priority)["priority"].CompanyDependent, ShouldBeTrue)
+				So(commentModel.FieldsGet(text)["text"].CompanyDependent, ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestDeleteRecordSet(t *testing.T) {
 	Convey("Checking unlink method", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
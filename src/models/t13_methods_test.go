@@ -15,6 +15,7 @@
 package models
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -59,6 +60,109 @@ func TestMethods(t *testing.T) {
 	})
 }
 
+func TestMethodMiddlewares(t *testing.T) {
+	Convey("Testing method middlewares", t, func() {
+		userModel := Registry.MustGet("User")
+		prefixedUser := userModel.Methods().MustGet("PrefixedUser")
+		defer func() {
+			methodMiddlewares = nil
+			userModel.methods.middlewares = nil
+			prefixedUser.middlewares = nil
+		}()
+		var calls []string
+		RegisterMethodMiddleware(func(call *MethodCall, next MethodHandler) []interface{} {
+			if call.ModelName() == "User" {
+				calls = append(calls, "global:"+call.MethodName())
+			}
+			return next(call)
+		})
+		userModel.Methods().AddMiddleware(func(call *MethodCall, next MethodHandler) []interface{} {
+			calls = append(calls, "model:"+call.MethodName())
+			return next(call)
+		})
+		prefixedUser.AddMiddleware(func(call *MethodCall, next MethodHandler) []interface{} {
+			calls = append(calls, "method:"+call.MethodName())
+			call.Args[0] = call.Args[0].(string) + "!"
+			res := next(call)
+			res[0] = append(res[0].([]string), "wrapped")
+			return res
+		})
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			users = users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			Convey("Middlewares should wrap calls from the outermost to the innermost", func() {
+				res := users.Call("PrefixedUser", "Prefix").([]string)
+				So(res, ShouldHaveLength, 2)
+				So(res[0], ShouldEqual, "Prefix!: Jane A. Smith [<jane.smith@example.com>]")
+				So(res[1], ShouldEqual, "wrapped")
+				So(calls[:3], ShouldResemble, []string{"global:PrefixedUser", "model:PrefixedUser", "method:PrefixedUser"})
+			})
+			Convey("Calls to Super should not be wrapped again", func() {
+				users.Call("PrefixedUser", "Prefix")
+				var count int
+				for _, call := range calls {
+					if call == "method:PrefixedUser" {
+						count++
+					}
+				}
+				So(count, ShouldEqual, 1)
+				So(calls, ShouldContain, "model:DecorateEmail")
+			})
+			Convey("Middlewares should be able to skip the method", func() {
+				subSetSuper := userModel.Methods().MustGet("SubSetSuper")
+				defer func() {
+					subSetSuper.middlewares = nil
+				}()
+				subSetSuper.AddMiddleware(func(call *MethodCall, next MethodHandler) []interface{} {
+					return []interface{}{"cached"}
+				})
+				So(users.Call("SubSetSuper"), ShouldEqual, "cached")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestMethodProfiling(t *testing.T) {
+	Convey("Testing method profiling", t, func() {
+		EnableProfiling(0)
+		ResetMethodStatistics()
+		defer DisableProfiling()
+		profile := NewProfile()
+		ctx := ContextWithProfile(context.Background(), profile)
+		So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			users = users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			users.Call("PrefixedUser", "Prefix")
+			users.Call("PrefixedUser", "Prefix")
+		}), ShouldBeNil)
+		findStats := func(stats []MethodStats, method string) MethodStats {
+			for _, ms := range stats {
+				if ms.Model == "User" && ms.Method == method {
+					return ms
+				}
+			}
+			return MethodStats{}
+		}
+		Convey("Calls should be counted once per call and not per layer", func() {
+			prefixed := findStats(profile.Stats(), "PrefixedUser")
+			So(prefixed.Calls, ShouldEqual, 2)
+			So(findStats(profile.Stats(), "DecorateEmail").Calls, ShouldEqual, 2)
+		})
+		Convey("Exclusive time and queries should exclude called methods", func() {
+			prefixed := findStats(profile.Stats(), "PrefixedUser")
+			decorate := findStats(profile.Stats(), "DecorateEmail")
+			So(prefixed.TotalTime, ShouldBeGreaterThanOrEqualTo, prefixed.ExclusiveTime+decorate.TotalTime)
+			So(prefixed.Queries, ShouldBeGreaterThan, 0)
+			So(prefixed.ExclusiveQueries, ShouldBeLessThanOrEqualTo, prefixed.Queries)
+		})
+		Convey("Global statistics should include the calls of all environments", func() {
+			So(findStats(MethodStatistics(), "PrefixedUser").Calls, ShouldEqual, 2)
+			ResetMethodStatistics()
+			So(MethodStatistics(), ShouldBeEmpty)
+		})
+	})
+}
+
 func TestComputedNonStoredFields(t *testing.T) {
 	Convey("Testing non stored computed fields", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -15,7 +15,16 @@
 package models
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gleke/hexya/src/models/security"
 	"github.com/gleke/hexya/src/models/types"
@@ -267,7 +276,7 @@ X2M Links
 	Convey("Testing db error retries", t, func() {
 		Convey("ExecuteInNewEnvironment should retry db errors up to max retries", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doExecuteInNewEnvironment(context.Background(), "", security.SuperUserID, 0, func(env Environment) {
 				retries++
 				panic(&pq.Error{Code: "40001"})
 			}), ShouldNotBeNil)
@@ -275,7 +284,7 @@ X2M Links
 		})
 		Convey("ExecuteInNewEnvironment should retry db errors and stop when ok", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doExecuteInNewEnvironment(context.Background(), "", security.SuperUserID, 0, func(env Environment) {
 				retries++
 				if retries < 3 {
 					panic(&pq.Error{Code: "40001"})
@@ -285,7 +294,7 @@ X2M Links
 		})
 		Convey("SimulateInNewEnvironment should retry db errors up to max retries", func() {
 			var retries uint8
-			So(doSimulateInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doSimulateInNewEnvironment(context.Background(), "", security.SuperUserID, 0, func(env Environment) {
 				retries++
 				panic(&pq.Error{Code: "40001"})
 			}), ShouldNotBeNil)
@@ -293,7 +302,7 @@ X2M Links
 		})
 		Convey("SimulateInNewEnvironment should retry db errors and stop when ok", func() {
 			var retries uint8
-			So(doSimulateInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(doSimulateInNewEnvironment(context.Background(), "", security.SuperUserID, 0, func(env Environment) {
 				retries++
 				if retries < 3 {
 					panic(&pq.Error{Code: "40001"})
@@ -303,3 +312,555 @@ X2M Links
 		})
 	})
 }
+
+func TestReadReplicas(t *testing.T) {
+	Convey("Testing read replicas routing", t, func() {
+		Convey("Read queries should be detected", func() {
+			So(isReadQuery(`SELECT id FROM "user"`), ShouldBeTrue)
+			So(isReadQuery(`  WITH t AS (SELECT 1 AS id) SELECT id FROM t`), ShouldBeTrue)
+			So(isReadQuery(`SELECT last_update FROM "user"`), ShouldBeTrue)
+			So(isReadQuery(`INSERT INTO "user" (name) VALUES (?) RETURNING id`), ShouldBeFalse)
+			So(isReadQuery(`UPDATE "user" SET name = ?`), ShouldBeFalse)
+			So(isReadQuery(`SELECT nextval('test_seq')`), ShouldBeFalse)
+			So(isReadQuery(`SELECT id FROM "user" FOR UPDATE`), ShouldBeFalse)
+		})
+		Convey("Replica params should default to primary params", func() {
+			primary := ConnectionParams{Driver: "postgres", Host: "primary", Port: "5432", User: "hexya", DBName: "hexya"}
+			replica := ConnectionParams{Host: "replica"}.withDefaults(primary)
+			So(replica.Host, ShouldEqual, "replica")
+			So(replica.Port, ShouldEqual, "5432")
+			So(replica.User, ShouldEqual, "hexya")
+			So(replica.DBName, ShouldEqual, "hexya")
+		})
+		Convey("Read only environments should run on primary without replicas", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.Cr().readOnly, ShouldBeTrue)
+				users := env.Pool("User").SearchAll()
+				So(users.Len(), ShouldBeGreaterThan, 0)
+				So(env.Cr().onReplica, ShouldBeFalse)
+				users.Records()[0].Set(Registry.MustGet("User").FieldName("Name"), "Replica Test")
+				So(env.Cr().readOnly, ShouldBeFalse)
+				So(env.Cr().written, ShouldBeTrue)
+				So(env.ReadOnly().Cr().readOnly, ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+	})
+}
+
+func TestTenants(t *testing.T) {
+	Convey("Testing tenants", t, func() {
+		Convey("Tenant names should be checked", func() {
+			So(func() { checkTenantName("acme_2") }, ShouldNotPanic)
+			So(func() { checkTenantName("Acme") }, ShouldPanic)
+			So(func() { checkTenantName("2acme") }, ShouldPanic)
+			So(func() { checkTenantName(`acme"; DROP DATABASE hexya; --`) }, ShouldPanic)
+		})
+		Convey("Tenants should be resolved from host names", func() {
+			tenants = map[string]Tenant{
+				"acme":   {Name: "acme"},
+				"globex": {Name: "globex", Host: "erp.globex.com"},
+			}
+			defer func() { tenants = nil }()
+			So(TenantExists("acme"), ShouldBeTrue)
+			So(TenantExists("initech"), ShouldBeFalse)
+			name, ok := ResolveTenant("acme.example.com:8080")
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "acme")
+			name, ok = ResolveTenant("erp.globex.com")
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "globex")
+			_, ok = ResolveTenant("initech.example.com")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Empty tenant should run on the default database", func() {
+			So(ExecuteInTenantEnvironment("", security.SuperUserID, func(env Environment) {
+				So(env.Tenant(), ShouldBeEmpty)
+				So(env.Cr().conn, ShouldEqual, db)
+				So(env.Pool("User").SearchAll().Len(), ShouldBeGreaterThan, 0)
+			}), ShouldBeNil)
+		})
+		Convey("Unknown tenant should return an error", func() {
+			So(ExecuteInTenantEnvironment("initech", security.SuperUserID, func(env Environment) {}), ShouldNotBeNil)
+			So(SimulateInTenantEnvironment("initech", security.SuperUserID, func(env Environment) {}), ShouldNotBeNil)
+		})
+		Convey("Unreachable tenant database should return an error", func() {
+			tenants = map[string]Tenant{"initech_missing": {Name: "initech_missing"}}
+			defer func() { tenants = nil }()
+			var called bool
+			So(ExecuteInTenantEnvironment("initech_missing", security.SuperUserID, func(env Environment) {
+				called = true
+			}), ShouldNotBeNil)
+			So(called, ShouldBeFalse)
+			So(tenantConns, ShouldNotContainKey, "initech_missing")
+		})
+		Convey("Tenant should be taken from the context", func() {
+			So(TenantFromContext(context.Background()), ShouldBeEmpty)
+			ctx := ContextWithTenant(context.Background(), "initech")
+			So(TenantFromContext(ctx), ShouldEqual, "initech")
+			So(ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {}), ShouldNotBeNil)
+			So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {}), ShouldNotBeNil)
+			So(ExecuteInNewEnvironmentContext(ContextWithTenant(context.Background(), ""), security.SuperUserID, func(env Environment) {
+				So(env.Tenant(), ShouldBeEmpty)
+				So(env.Cr().conn, ShouldEqual, db)
+			}), ShouldBeNil)
+		})
+		Convey("Group memberships should be isolated by tenant", func() {
+			group := security.Registry.NewGroup("tenant_test_group", "Tenant Test Group")
+			defer security.Registry.UnregisterGroup(group)
+			defer forgetTenantGroups("acme")
+			defer forgetTenantGroups("globex")
+			security.Registry.AddMembership(2, group)
+			tenantGroups("acme").AddMembership(3, group)
+			So(Environment{uid: 2}.UserGroups(), ShouldContainKey, group)
+			So(Environment{uid: 3}.UserGroups(), ShouldNotContainKey, group)
+			So(Environment{tenant: "acme", uid: 2}.UserGroups(), ShouldNotContainKey, group)
+			So(Environment{tenant: "acme", uid: 3}.UserGroups(), ShouldContainKey, group)
+			So(Environment{tenant: "acme", uid: security.SuperUserID}.UserGroups(), ShouldContainKey, security.GroupAdmin)
+			So(Environment{tenant: "acme", uid: 2}.UserGroups(), ShouldNotContainKey, security.GroupAdmin)
+			So(Environment{tenant: "globex", uid: 3}.UserGroups(), ShouldNotContainKey, group)
+		})
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	Convey("Testing statement timeouts", t, func() {
+		Convey("Default timeout should be the server setting", func() {
+			cr := newCursor(context.Background(), db)
+			So(cr.statementTimeout, ShouldEqual, noTimeout)
+			So(cr.lockTimeout, ShouldEqual, noTimeout)
+		})
+		Convey("WithTimeout should set the statement timeout of the transaction", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				var timeout string
+				env.WithTimeout(150 * time.Millisecond)
+				env.Cr().Get(&timeout, "SHOW statement_timeout")
+				So(timeout, ShouldEqual, "150ms")
+				env.WithTimeout(0)
+				env.Cr().Get(&timeout, "SHOW statement_timeout")
+				So(timeout, ShouldEqual, "0")
+			}), ShouldBeNil)
+		})
+		Convey("Queries longer than the timeout should be canceled", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.WithTimeout(100 * time.Millisecond).Cr().Execute("SELECT pg_sleep(1)")
+			}), ShouldNotBeNil)
+		})
+	})
+}
+
+type testContextKey string
+
+func TestEnvironmentContext(t *testing.T) {
+	Convey("Testing Environment context", t, func() {
+		Convey("Environments should carry the given context", func() {
+			ctx := context.WithValue(context.Background(), testContextKey("key"), "value")
+			So(ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				So(env.Ctx().Value(testContextKey("key")), ShouldEqual, "value")
+			}), ShouldBeNil)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.Ctx(), ShouldNotBeNil)
+			}), ShouldBeNil)
+		})
+		Convey("Canceling the context should abort running queries", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}()
+			start := time.Now()
+			So(ExecuteInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				env.Cr().Execute("SELECT pg_sleep(2)")
+			}), ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+		Convey("Queries should not be run with a canceled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(SimulateInNewEnvironmentContext(ctx, security.SuperUserID, func(env Environment) {
+				env.Pool("User").SearchAll().Load()
+			}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSharedCache(t *testing.T) {
+	Convey("Testing the shared cache", t, func() {
+		tagModel := Registry.MustGet("Tag")
+		tagModel.SetSharedCache(2)
+		defer tagModel.SetSharedCache(0)
+		sharedCacheEntries := func() map[int64]*list.Element {
+			sharedCacheMutex.Lock()
+			defer sharedCacheMutex.Unlock()
+			return getSharedModelCache("", "Tag").entries
+		}
+		var tagIds []int64
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			for _, name := range []string{"Shared 1", "Shared 2", "Shared 3"} {
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{"Name": name})).(RecordSet).Collection()
+				tagIds = append(tagIds, tag.Ids()[0])
+			}
+		}), ShouldBeNil)
+		Convey("Loaded records should be stored in the shared cache", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:2]).Load(Name)
+			}), ShouldBeNil)
+			So(sharedCacheEntries(), ShouldHaveLength, 2)
+			So(sharedCacheEntries(), ShouldContainKey, tagIds[0])
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tags := env.Pool("Tag").withIds(tagIds[:2])
+				So(tags.loadFromSharedCache([]string{"name"}), ShouldBeTrue)
+				So(tags.Records()[0].Get(Name), ShouldEqual, "Shared 1")
+			}), ShouldBeNil)
+		})
+		Convey("The least recently used records should be evicted", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds).Load(Name)
+			}), ShouldBeNil)
+			So(sharedCacheEntries(), ShouldHaveLength, 2)
+		})
+		Convey("Contexted fields should not be loaded from the shared cache", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tags := env.Pool("Tag").withIds(tagIds[:1])
+				tags.Load(Name)
+				So(tags.loadFromSharedCache([]string{"name", "description"}), ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+		Convey("Written records should be invalidated", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:1]).Load(Name)
+			}), ShouldBeNil)
+			So(sharedCacheEntries(), ShouldContainKey, tagIds[0])
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:1]).Set(Name, "Shared 1 bis")
+				So(sharedCacheEntries(), ShouldNotContainKey, tagIds[0])
+				env.Pool("Tag").withIds(tagIds[:1]).ForceLoad(Name)
+				So(sharedCacheEntries(), ShouldNotContainKey, tagIds[0])
+			}), ShouldBeNil)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.Pool("Tag").withIds(tagIds[:1]).Get(Name), ShouldEqual, "Shared 1 bis")
+			}), ShouldBeNil)
+		})
+		Convey("Records loaded by transactions older than an invalidation should not be cached", func() {
+			env := newEnvironment(security.SuperUserID)
+			invalidateSharedCache("", "Tag", tagIds[1:2])
+			env.Pool("Tag").withIds(tagIds[1:2]).Load(Name)
+			env.rollback()
+			So(sharedCacheEntries(), ShouldNotContainKey, tagIds[1])
+		})
+		Convey("Records loaded from a read replica should not be cached", func() {
+			env := newEnvironment(security.SuperUserID)
+			env.cr.onReplica = true
+			env.Pool("Tag").withIds(tagIds[1:2]).Load(Name)
+			env.rollback()
+			So(sharedCacheEntries(), ShouldNotContainKey, tagIds[1])
+		})
+		Convey("Notifications from other processes should invalidate the shared cache", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:2]).Load(Name)
+			}), ShouldBeNil)
+			handleSharedCacheNotification(fmt.Sprintf(`{"origin":"%s","model":"Tag","ids":[%d]}`, sharedCacheOrigin, tagIds[0]))
+			So(sharedCacheEntries(), ShouldContainKey, tagIds[0])
+			handleSharedCacheNotification(fmt.Sprintf(`{"origin":"other","model":"Tag","ids":[%d]}`, tagIds[0]))
+			So(sharedCacheEntries(), ShouldNotContainKey, tagIds[0])
+			So(sharedCacheEntries(), ShouldContainKey, tagIds[1])
+			handleSharedCacheNotification("")
+			So(sharedCacheEntries(), ShouldBeEmpty)
+		})
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.Pool("Tag").withIds(tagIds).Call("Unlink")
+		}), ShouldBeNil)
+		So(sharedCacheEntries(), ShouldBeEmpty)
+	})
+}
+
+func TestModelEvents(t *testing.T) {
+	Convey("Testing model lifecycle events", t, func() {
+		tagModel := Registry.MustGet("Tag")
+		previousEvents := tagModel.events
+		tagModel.events = newModelEvents(tagModel)
+		defer func() {
+			tagModel.events = previousEvents
+		}()
+		var (
+			created, written, unlinked []int64
+			writtenFields              FieldNames
+			committed                  []Event
+		)
+		tagModel.Events().AfterCreate(func(rs RecordSet) {
+			created = append(created, rs.Ids()...)
+		})
+		tagModel.Events().AfterWrite(func(rs RecordSet, fields FieldNames) {
+			written = append(written, rs.Ids()...)
+			writtenFields = fields
+		}, tagModel.FieldName("Rate"))
+		tagModel.Events().BeforeUnlink(func(rs RecordSet) {
+			So(rs.Collection().SearchCount(), ShouldEqual, 1)
+			unlinked = append(unlinked, rs.Ids()...)
+		})
+		tagModel.Events().AfterCommit(func(event Event) {
+			committed = append(committed, event)
+		})
+		Convey("Handlers should be called within the transaction", func() {
+			var tagID int64
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{"Name": "Evented"})).(RecordSet).Collection()
+				tagID = tag.Ids()[0]
+				So(created, ShouldResemble, []int64{tagID})
+				tag.Set(Name, "Evented bis")
+				So(written, ShouldBeEmpty)
+				tag.Set(tagModel.FieldName("Rate"), 5.0)
+				So(written, ShouldResemble, []int64{tagID})
+				So(writtenFields, ShouldHaveLength, 1)
+				So(writtenFields[0].JSON(), ShouldEqual, "rate")
+				So(committed, ShouldBeEmpty)
+				tag.Call("Unlink")
+				So(unlinked, ShouldResemble, []int64{tagID})
+				So(committed, ShouldBeEmpty)
+			}), ShouldBeNil)
+			So(len(committed), ShouldBeGreaterThanOrEqualTo, 4)
+			So(committed[0].Type, ShouldEqual, CreateEvent)
+			So(committed[0].IDs, ShouldResemble, []int64{tagID})
+			So(committed[0].UserID, ShouldEqual, security.SuperUserID)
+			So(committed[1].Type, ShouldEqual, WriteEvent)
+			last := committed[len(committed)-1]
+			So(last.Type, ShouldEqual, UnlinkEvent)
+			So(last.Model, ShouldEqual, "Tag")
+		})
+		Convey("Post-commit handlers should not be called on rollback", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{"Name": "Simulated"}))
+			}), ShouldBeNil)
+			So(created, ShouldHaveLength, 1)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{"Name": "Failed"}))
+				panic("failure")
+			}), ShouldNotBeNil)
+			So(created, ShouldHaveLength, 2)
+			So(committed, ShouldBeEmpty)
+		})
+		Convey("Panicking post-commit handlers should not fail the transaction", func() {
+			tagModel.Events().AfterCommit(func(event Event) {
+				panic("post-commit failure")
+			})
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{"Name": "Committed"})).(RecordSet).Collection()
+				tag.Call("Unlink")
+			}), ShouldBeNil)
+			So(committed[len(committed)-1].Type, ShouldEqual, UnlinkEvent)
+		})
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	Convey("Testing outgoing webhooks", t, func() {
+		tagModel := Registry.MustGet("Tag")
+		webhookModel := Registry.MustGet(webhookModelName)
+		deliveryModel := Registry.MustGet(webhookDeliveryModelName)
+		var (
+			mutex    sync.Mutex
+			status   = http.StatusOK
+			requests []*http.Request
+			bodies   [][]byte
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			body, _ := ioutil.ReadAll(r.Body)
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		var hookID int64
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			hook := env.Pool(webhookModelName).Call("Create", NewModelData(webhookModel, FieldMap{
+				"Name":       "Tags",
+				"URL":        server.URL,
+				"ModelName":  "Tag",
+				"Events":     "create, write",
+				"Fields":     "Name,Rate",
+				"Domain":     `[["Rate", ">", 2]]`,
+				"Secret":     "s3cr3t",
+				"MaxRetries": int64(1),
+			})).(RecordSet).Collection()
+			hookID = hook.Ids()[0]
+		}), ShouldBeNil)
+		deliveries := func(state string) []int64 {
+			var res []int64
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				res = env.Pool(webhookDeliveryModelName).Search(
+					deliveryModel.Field(deliveryModel.FieldName("State")).Equals(state)).Ids()
+			}), ShouldBeNil)
+			return res
+		}
+		var tagIds []int64
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			for i, rate := range []float32{5, 1} {
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{
+					"Name": fmt.Sprintf("Hooked %d", i),
+					"Rate": rate,
+				})).(RecordSet).Collection()
+				tagIds = append(tagIds, tag.Ids()[0])
+			}
+		}), ShouldBeNil)
+		Convey("Deliveries should only be created for matching records", func() {
+			So(deliveries(webhookPending), ShouldHaveLength, 1)
+		})
+		Convey("Deliveries should not be created for filtered out fields", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:1]).Set(tagModel.FieldName("Note"), "Not sent")
+			}), ShouldBeNil)
+			So(deliveries(webhookPending), ShouldHaveLength, 1)
+		})
+		Convey("Deliveries should not be created on rollback", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").withIds(tagIds[:1]).Set(tagModel.FieldName("Rate"), float32(6))
+			}), ShouldBeNil)
+			So(deliveries(webhookPending), ShouldHaveLength, 1)
+		})
+		Convey("Pending deliveries should be sent with a signed payload", func() {
+			deliverTenantWebhooks("")
+			So(requests, ShouldHaveLength, 1)
+			So(requests[0].Header.Get(WebhookEventHeader), ShouldEqual, "create")
+			So(requests[0].Header.Get(WebhookSignatureHeader), ShouldEqual, WebhookSignature("s3cr3t", bodies[0]))
+			var payload map[string]interface{}
+			So(json.Unmarshal(bodies[0], &payload), ShouldBeNil)
+			So(payload["model"], ShouldEqual, "Tag")
+			So(payload["event"], ShouldEqual, "create")
+			records := payload["records"].([]interface{})
+			So(records, ShouldHaveLength, 1)
+			record := records[0].(map[string]interface{})
+			So(record["id"], ShouldEqual, float64(tagIds[0]))
+			So(record["name"], ShouldEqual, "Hooked 0")
+			So(record, ShouldNotContainKey, "note")
+			So(deliveries(webhookPending), ShouldBeEmpty)
+			So(deliveries(webhookDone), ShouldHaveLength, 1)
+		})
+		Convey("Failed deliveries should be retried and eventually marked as failed", func() {
+			status = http.StatusInternalServerError
+			deliverTenantWebhooks("")
+			So(requests, ShouldHaveLength, 1)
+			So(deliveries(webhookPending), ShouldHaveLength, 1)
+			deliverTenantWebhooks("")
+			So(requests, ShouldHaveLength, 1)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				delivery := env.Pool(webhookDeliveryModelName).Search(
+					deliveryModel.Field(deliveryModel.FieldName("State")).Equals(webhookPending))
+				So(delivery.Get(deliveryModel.FieldName("Attempts")), ShouldEqual, 1)
+				So(delivery.Get(deliveryModel.FieldName("ResponseStatus")), ShouldEqual, http.StatusInternalServerError)
+				delivery.Set(deliveryModel.FieldName("NextAttempt"), delivery.Get(deliveryModel.FieldName("LastAttempt")))
+			}), ShouldBeNil)
+			deliverTenantWebhooks("")
+			So(requests, ShouldHaveLength, 2)
+			So(deliveries(webhookPending), ShouldBeEmpty)
+			So(deliveries(webhookFailed), ShouldHaveLength, 1)
+		})
+		Convey("Secrets should be stored encrypted", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				var secret string
+				env.Cr().Get(&secret, `SELECT secret FROM hexya_webhook WHERE id = ?`, hookID)
+				So(secret, ShouldNotEqual, "s3cr3t")
+				So(env.Pool(webhookModelName).withIds([]int64{hookID}).Get(webhookModel.FieldName("Secret")), ShouldEqual, "s3cr3t")
+			}), ShouldBeNil)
+		})
+		Convey("Invalid webhooks should be skipped", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				for _, data := range []FieldMap{
+					{"Fields": "Unknown"},
+					{"Domain": `[["Unknown", "=", 2]]`},
+					{"Domain": `[["Rate", "=",`},
+					{"Events": "delete"},
+				} {
+					data["Name"] = "Invalid"
+					data["URL"] = server.URL
+					data["ModelName"] = "Tag"
+					env.Pool(webhookModelName).Call("Create", NewModelData(webhookModel, data))
+				}
+				invalidateWebhookConfigs("")
+				hooks := activeWebhooks(env, "Tag")
+				So(hooks, ShouldHaveLength, 1)
+				So(hooks[0].id, ShouldEqual, hookID)
+				webhooksMutex.Lock()
+				defer webhooksMutex.Unlock()
+				So(webhooks, ShouldNotContainKey, "")
+			}), ShouldBeNil)
+		})
+		Convey("Retry delays should grow exponentially", func() {
+			So(webhookRetryBackoff(1), ShouldEqual, webhookRetryDelay)
+			So(webhookRetryBackoff(3), ShouldEqual, 4*webhookRetryDelay)
+			So(webhookRetryBackoff(100), ShouldEqual, webhookMaxRetryDelay)
+		})
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.Pool("Tag").withIds(tagIds).Call("Unlink")
+			env.Pool(webhookModelName).withIds([]int64{hookID}).Call("Unlink")
+		}), ShouldBeNil)
+		So(deliveries(webhookPending), ShouldBeEmpty)
+	})
+}
+
+func TestGroupStore(t *testing.T) {
+	Convey("Testing persisted group memberships", t, func() {
+		group1 := security.Registry.NewGroup("store_group1", "Store Group 1")
+		group2 := security.Registry.NewGroup("store_group2", "Store Group 2")
+		defer func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				SetGroupMemberships(env, 3)
+			}), ShouldBeNil)
+			security.Registry.RemoveMembership(4, group1)
+			security.Registry.UnregisterGroup(group1)
+			security.Registry.UnregisterGroup(group2)
+		}()
+		Convey("Memberships are applied when the transaction is committed", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				AddGroupMembership(env, 3, group1)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeFalse)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				AddGroupMembership(env, 3, group1)
+				AddGroupMembership(env, 3, group1)
+				So(security.Registry.HasMembership(3, group1), ShouldBeFalse)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeTrue)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				membershipModel := Registry.MustGet(groupMembershipModelName)
+				So(env.Pool(groupMembershipModelName).Search(
+					membershipModel.Field(membershipModel.FieldName("UserID")).Equals(3)).SearchCount(), ShouldEqual, 1)
+				RemoveGroupMembership(env, 3, group1)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeFalse)
+		})
+		Convey("Memberships can be replaced", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				SetGroupMemberships(env, 3, group1, group2)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeTrue)
+			So(security.Registry.HasMembership(3, group2), ShouldBeTrue)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				SetGroupMemberships(env, 3, group2)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeFalse)
+			So(security.Registry.HasMembership(3, group2), ShouldBeTrue)
+		})
+		Convey("Memberships that are not persisted are kept", func() {
+			security.Registry.AddMembership(4, group1)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				AddGroupMembership(env, 3, group1)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeTrue)
+			So(security.Registry.HasMembership(4, group1), ShouldBeTrue)
+		})
+		Convey("Memberships are reloaded on notification from other processes", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				AddGroupMembership(env, 3, group1)
+			}), ShouldBeNil)
+			So(security.Registry.HasMembership(3, group1), ShouldBeTrue)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Cr().Execute(fmt.Sprintf("DELETE FROM %s", Registry.MustGet(groupMembershipModelName).tableName))
+			}), ShouldBeNil)
+			handleGroupsNotification(fmt.Sprintf(`{"origin": "%s"}`, sharedCacheOrigin))
+			So(security.Registry.HasMembership(3, group1), ShouldBeTrue)
+			handleGroupsNotification(`{"origin": "other"}`)
+			So(security.Registry.HasMembership(3, group1), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// tenantTable is the system table of the default database
+// in which the tenants of a multi-tenant server are registered.
+const tenantTable = "hexya_tenant"
+
+// A Tenant is a database served by a multi-tenant server.
+//
+// The database of a tenant has the name of the tenant and the
+// same connection parameters as the default database.
+type Tenant struct {
+	Name string `db:"name"`
+	// Host is the host name of the requests that are served by this tenant
+	Host      string    `db:"host"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+var (
+	tenantsMutex sync.RWMutex
+	tenants      map[string]Tenant
+	tenantConns  = make(map[string]*sqlx.DB)
+)
+
+// tenantNameRegex matches valid tenant names
+var tenantNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// checkTenantName panics if the given tenant name is not valid
+func checkTenantName(name string) {
+	if !tenantNameRegex.MatchString(name) {
+		log.Panic("Invalid tenant name. Tenant names must start with a lowercase letter and contain only lowercase letters, digits and underscores", "tenant", name)
+	}
+}
+
+// createTenantTable creates the tenant table in the default database if needed
+func createTenantTable() {
+	dbExecuteNoTx(`
+		CREATE TABLE IF NOT EXISTS ` + tenantTable + ` (
+			name character varying NOT NULL PRIMARY KEY,
+			host character varying NOT NULL DEFAULT '',
+			created_at timestamp without time zone NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+		)`)
+}
+
+// Tenants returns the tenants registered in the default database sorted by name
+func Tenants() []Tenant {
+	createTenantTable()
+	var res []Tenant
+	dbSelectNoTx(&res, `SELECT name, host, created_at FROM `+tenantTable+` ORDER BY name`)
+	return res
+}
+
+// LoadTenants loads the tenants registered in the default database so
+// that environments can be created on their databases, and loads the
+// group memberships of each tenant. It must be called when starting a
+// multi-tenant server and after tenants are modified.
+func LoadTenants() {
+	tenantsList := Tenants()
+	tenantsMutex.Lock()
+	oldTenants := tenants
+	tenants = make(map[string]Tenant, len(tenantsList))
+	for _, tenant := range tenantsList {
+		tenants[tenant.Name] = tenant
+		delete(oldTenants, tenant.Name)
+	}
+	tenantsMutex.Unlock()
+	for name := range oldTenants {
+		forgetTenantGroups(name)
+	}
+	reloadTenantsGroupMemberships()
+}
+
+// TenantExists returns true if a tenant with the given name has been loaded
+func TenantExists(name string) bool {
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+	_, ok := tenants[name]
+	return ok
+}
+
+// loadedTenants returns the names of the loaded tenants sorted by name,
+// or only the empty name of the default database if no tenant is loaded.
+func loadedTenants() []string {
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+	if len(tenants) == 0 {
+		return []string{""}
+	}
+	res := make([]string, 0, len(tenants))
+	for name := range tenants {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ResolveTenant returns the name of the loaded tenant that serves the
+// requests of the given host, which may include a port.
+//
+// The tenant is the one with the given host. If there is none, it is the
+// tenant named after the first label of the host (e.g. 'acme' for
+// 'acme.example.com').
+func ResolveTenant(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if tenants[name].Host != "" && tenants[name].Host == host {
+			return name, true
+		}
+	}
+	label := host
+	if idx := strings.Index(host, "."); idx >= 0 {
+		label = host[:idx]
+	}
+	if _, ok := tenants[label]; ok {
+		return label, true
+	}
+	return "", false
+}
+
+// CreateTenant creates the database of a new tenant with the given name
+// and registers it in the default database. host is the optional host
+// name of the requests that are served by this tenant.
+//
+// The schema of the new database must then be synchronized with the models.
+func CreateTenant(name, host string) {
+	createTenantDatabase(name, host, "")
+}
+
+// DuplicateTenant creates a new tenant with the given name whose
+// database is a copy of the database of the source tenant.
+//
+// There must be no open connection to the source database.
+func DuplicateTenant(source, name, host string) {
+	checkTenantName(source)
+	createTenantDatabase(name, host, source)
+}
+
+// createTenantDatabase creates and registers the database of the given tenant
+// from the given template database, or from the default template if empty.
+func createTenantDatabase(name, host, template string) {
+	checkTenantName(name)
+	adapter := adapters[db.DriverName()]
+	createTenantTable()
+	dbExecuteNoTx(adapter.createDatabaseSQL(name, template))
+	dbExecuteNoTx(`INSERT INTO `+tenantTable+` (name, host) VALUES (?, ?)`, name, host)
+	log.Info("Tenant created", "tenant", name, "host", host, "template", template)
+}
+
+// DropTenant drops the database of the given tenant and unregisters it.
+func DropTenant(name string) {
+	checkTenantName(name)
+	adapter := adapters[db.DriverName()]
+	tenantsMutex.Lock()
+	if conn, ok := tenantConns[name]; ok {
+		conn.Close()
+		delete(tenantConns, name)
+	}
+	delete(tenants, name)
+	tenantsMutex.Unlock()
+	forgetTenantGroups(name)
+	createTenantTable()
+	dbExecuteNoTx(adapter.dropDatabaseSQL(name))
+	dbExecuteNoTx(`DELETE FROM `+tenantTable+` WHERE name = ?`, name)
+	log.Info("Tenant dropped", "tenant", name)
+}
+
+// tenantDB returns the connection pool to the database of the given
+// tenant, or to the default database if tenant is empty.
+//
+// Connection pools are created at first use. The connection is opened
+// without holding tenantsMutex so that an unreachable tenant database
+// does not block the other tenants. It returns an error if the tenant
+// has not been loaded or if its database cannot be reached.
+func tenantDB(tenant string) (*sqlx.DB, error) {
+	if tenant == "" {
+		return db, nil
+	}
+	tenantsMutex.RLock()
+	conn, ok := tenantConns[tenant]
+	_, exists := tenants[tenant]
+	tenantsMutex.RUnlock()
+	if ok {
+		return conn, nil
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown tenant %s", tenant)
+	}
+	params := connParams
+	params.DBName = tenant
+	params.Replicas = nil
+	conn, err := sqlx.Connect(params.Driver, params.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the database of tenant %s: %s", tenant, err)
+	}
+	configurePool(conn, params)
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	if existing, ok := tenantConns[tenant]; ok {
+		// Another goroutine connected in the meantime
+		conn.Close()
+		return existing, nil
+	}
+	if _, exists = tenants[tenant]; !exists {
+		// The tenant has been removed in the meantime
+		conn.Close()
+		return nil, fmt.Errorf("unknown tenant %s", tenant)
+	}
+	tenantConns[tenant] = conn
+	log.Info("Connected to tenant database", "tenant", tenant)
+	return conn, nil
+}
+
+// closeTenants closes the connection pools to the tenants databases
+func closeTenants() {
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	for name, conn := range tenantConns {
+		err := conn.Close()
+		log.Info("Closed tenant database", "tenant", name, "error", err)
+	}
+	tenantConns = make(map[string]*sqlx.DB)
+}
+
+// tenantContextKey is the key of the tenant in a context.Context
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a copy of the given context in which the
+// Environments created with this context run on the database of the
+// given tenant (see ExecuteInNewEnvironmentContext).
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the given context, or an
+// empty string for the default database.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// ExecuteInTenantEnvironment executes the given fnct in a new Environment
+// on the database of the given tenant. If tenant is empty, the default
+// database is used.
+//
+// It returns an error if the tenant is unknown or if its database cannot
+// be reached. See ExecuteInNewEnvironment.
+func ExecuteInTenantEnvironment(tenant string, uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(context.Background(), tenant, uid, 0, fnct)
+}
+
+// ExecuteInTenantEnvironmentContext executes the given fnct in a new
+// Environment on the database of the given tenant, regardless of the tenant
+// of the given context. The queries of the Environment are canceled when
+// the given context is done.
+//
+// See ExecuteInNewEnvironmentContext.
+func ExecuteInTenantEnvironmentContext(ctx context.Context, tenant string, uid int64, fnct func(Environment)) error {
+	return doExecuteInNewEnvironment(ctx, tenant, uid, 0, fnct)
+}
+
+// SimulateInTenantEnvironment executes the given fnct in a new Environment
+// on the database of the given tenant and rolls back the transaction at
+// the end. If tenant is empty, the default database is used.
+//
+// See SimulateInNewEnvironment.
+func SimulateInTenantEnvironment(tenant string, uid int64, fnct func(Environment)) error {
+	return doSimulateInNewEnvironment(context.Background(), tenant, uid, 0, fnct)
+}
+
+// SimulateInTenantEnvironmentContext executes the given fnct in a new
+// Environment on the database of the given tenant, regardless of the tenant
+// of the given context, and rolls back the transaction at the end. The
+// queries of the Environment are canceled when the given context is done.
+//
+// See SimulateInNewEnvironmentContext.
+func SimulateInTenantEnvironmentContext(ctx context.Context, tenant string, uid int64, fnct func(Environment)) error {
+	return doSimulateInNewEnvironment(ctx, tenant, uid, 0, fnct)
+}
+
+func init() {
+	RegisterSystemTable(tenantTable)
+}
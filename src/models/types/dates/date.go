@@ -148,6 +148,15 @@ func Today() Date {
 	return Date{time.Now()}
 }
 
+// TodayIn returns the current date in the given location.
+//
+// Use this function instead of Today to get the date of the user,
+// which may differ from the server's date around midnight.
+func TodayIn(loc *time.Location) Date {
+	now := time.Now().In(loc)
+	return Date{time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)}
+}
+
 // ParseDate returns a date from the given string value
 // that is formatted with the default YYYY-MM-DD format.
 //
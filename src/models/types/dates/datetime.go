@@ -44,18 +44,22 @@ func (d DateTime) MarshalJSON() ([]byte, error) {
 
 // Value formats our DateTime for storing in database
 // Especially handles empty DateTime.
+//
+// DateTime values are always stored in UTC.
 func (d DateTime) Value() (driver.Value, error) {
 	if d.IsZero() {
 		return time.Time{}, nil
 	}
-	return d.Time, nil
+	return d.Time.UTC(), nil
 }
 
 // Scan casts the database output to a DateTime
+//
+// Database values have no timezone and are considered to be in UTC.
 func (d *DateTime) Scan(src interface{}) error {
 	switch t := src.(type) {
 	case time.Time:
-		d.Time = t
+		d.Time = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
 		return nil
 	case string:
 		if t == "" {
@@ -130,6 +134,18 @@ func (d DateTime) In(loc *time.Location) DateTime {
 	return DateTime{Time: d.Time.In(loc)}
 }
 
+// A TimezoneGetter can give the timezone in which dates should
+// be presented, such as a models.Environment for the current user.
+type TimezoneGetter interface {
+	Timezone() *time.Location
+}
+
+// ToUserTZ returns d with the location set to the timezone of env,
+// typically the timezone of the current user.
+func (d DateTime) ToUserTZ(env TimezoneGetter) DateTime {
+	return d.In(env.Timezone())
+}
+
 // Equal reports whether d and other represent the same time instant
 func (d DateTime) Equal(other DateTime) bool {
 	return d.Time.Equal(other.Time)
@@ -108,6 +108,21 @@ func TestDateTime(t *testing.T) {
 			values := TimeZones()
 			So(values, ShouldContain, "America/Scoresbysund")
 		})
+		Convey("User timezone and database values", func() {
+			paris, _ := LoadLocation("Europe/Paris")
+			dt := ParseDateTime("2017-08-01 23:30:00")
+			So(dt.ToUserTZ(testTZGetter{paris}).String(), ShouldEqual, "2017-08-02 01:30:00")
+			So(dt.ToUserTZ(testTZGetter{paris}).ToDate().String(), ShouldEqual, "2017-08-02")
+			val, _ := dt.In(paris).Value()
+			So(val.(time.Time).Location(), ShouldEqual, time.UTC)
+			So(val.(time.Time).Hour(), ShouldEqual, 23)
+			var scanned DateTime
+			So(scanned.Scan(time.Date(2017, 8, 1, 23, 30, 0, 0, time.FixedZone("", 0))), ShouldBeNil)
+			So(scanned.Location(), ShouldEqual, time.UTC)
+			So(scanned.Equal(dt), ShouldBeTrue)
+			So(TodayIn(paris).Location(), ShouldEqual, paris)
+			So(TodayIn(paris).Hour(), ShouldEqual, 0)
+		})
 		Convey("Changing dates", func() {
 			dateCpy := dateTime1.Copy()
 			So(dateCpy.SetMonth(10).SetDay(4).Equal(ParseDateTime("2017-10-04 10:34:23")), ShouldBeTrue)
@@ -121,3 +136,11 @@ func TestDateTime(t *testing.T) {
 		})
 	})
 }
+
+type testTZGetter struct {
+	loc *time.Location
+}
+
+func (t testTZGetter) Timezone() *time.Location {
+	return t.loc
+}
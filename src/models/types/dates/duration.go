@@ -0,0 +1,199 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package dates
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration type that JSON marshals and unmarshals as a number of hours.
+// It is stored in the database as an interval.
+type Duration struct {
+	time.Duration
+}
+
+// NewDuration returns a Duration of the given hours, minutes and seconds
+func NewDuration(hours, minutes, seconds int) Duration {
+	return Duration{time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second}
+}
+
+// IsZero returns true if this Duration is zero
+func (d Duration) IsZero() bool {
+	return d.Duration == 0
+}
+
+// Add returns a new Duration that is the sum of d and other
+func (d Duration) Add(other Duration) Duration {
+	return Duration{d.Duration + other.Duration}
+}
+
+// Equal returns true if d and other are the same duration
+func (d Duration) Equal(other Duration) bool {
+	return d.Duration == other.Duration
+}
+
+// MarshalJSON for Duration type.
+//
+// Durations are marshalled as a float number of hours.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(d.Hours(), 'f', -1, 64)), nil
+}
+
+// UnmarshalJSON for Duration type.
+//
+// Durations are expected as a float number of hours.
+// false and null give a zero Duration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	str := string(data)
+	if str == "false" || str == "null" {
+		*d = Duration{}
+		return nil
+	}
+	var hours float64
+	if err := json.Unmarshal(data, &hours); err != nil {
+		return err
+	}
+	*d = Duration{time.Duration(math.Round(hours * float64(time.Hour)))}
+	return nil
+}
+
+// Value formats our Duration for storing in database as an interval
+func (d Duration) Value() (driver.Value, error) {
+	return fmt.Sprintf("%d microseconds", d.Microseconds()), nil
+}
+
+// Scan casts the database output to a Duration.
+//
+// float64 values are considered as a number of hours as sent by the client.
+func (d *Duration) Scan(src interface{}) error {
+	switch t := src.(type) {
+	case nil:
+		*d = Duration{}
+		return nil
+	case time.Duration:
+		d.Duration = t
+		return nil
+	case int64:
+		d.Duration = time.Duration(t)
+		return nil
+	case float64:
+		// Values coming from the client are a number of hours
+		d.Duration = time.Duration(math.Round(t * float64(time.Hour)))
+		return nil
+	case []byte:
+		val, err := ParseDurationWithError(string(t))
+		*d = val
+		return err
+	case string:
+		val, err := ParseDurationWithError(t)
+		*d = val
+		return err
+	}
+	return fmt.Errorf("Duration data is not an interval but %T", src)
+}
+
+// ParseDuration returns a Duration from the given string value.
+// See ParseDurationWithError for accepted formats.
+//
+// It panics in case the parsing cannot be done.
+func ParseDuration(value string) Duration {
+	d, err := ParseDurationWithError(value)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// ParseDurationWithError returns a Duration from the given string value.
+//
+// value can be either in PostgreSQL interval output format
+// (e.g. "1 day 02:30:00" or "-01:15:00.5") or in Go duration format
+// (e.g. "2h30m"). An empty string gives a zero Duration.
+func ParseDurationWithError(value string) (Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Duration{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return Duration{d}, nil
+	}
+	var res time.Duration
+	tokens := strings.Fields(value)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if strings.Contains(tok, ":") {
+			d, err := parseIntervalClock(tok)
+			if err != nil {
+				return Duration{}, err
+			}
+			res += d
+			continue
+		}
+		if i+1 >= len(tokens) {
+			return Duration{}, fmt.Errorf("unable to parse duration %q", value)
+		}
+		num, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return Duration{}, fmt.Errorf("unable to parse duration %q: %s", value, err)
+		}
+		i++
+		switch strings.TrimSuffix(tokens[i], "s") {
+		case "year":
+			res += time.Duration(num) * 365 * 24 * time.Hour
+		case "mon":
+			res += time.Duration(num) * 30 * 24 * time.Hour
+		case "day":
+			res += time.Duration(num) * 24 * time.Hour
+		default:
+			return Duration{}, fmt.Errorf("unknown unit %q in duration %q", tokens[i], value)
+		}
+	}
+	return Duration{res}, nil
+}
+
+// parseIntervalClock parses the [-]hh:mm:ss[.ffffff] part of a PostgreSQL interval
+func parseIntervalClock(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign = -1
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("unable to parse interval time %q", value)
+	}
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	var seconds float64
+	if len(parts) == 3 {
+		seconds, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	res := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(math.Round(seconds*float64(time.Second)))
+	return sign * res, nil
+}
+
+var _ driver.Valuer = Duration{}
+var _ sql.Scanner = new(Duration)
+var _ json.Marshaler = Duration{}
+var _ json.Unmarshaler = new(Duration)
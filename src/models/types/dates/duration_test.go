@@ -0,0 +1,61 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package dates
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDuration(t *testing.T) {
+	Convey("Testing Duration objects", t, func() {
+		d := NewDuration(2, 30, 0)
+		Convey("Marshaling should give hours", func() {
+			data, err := json.Marshal(d)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "2.5")
+			data, _ = json.Marshal(Duration{})
+			So(string(data), ShouldEqual, "0")
+		})
+		Convey("Unmarshaling", func() {
+			var d2 Duration
+			So(json.Unmarshal([]byte("1.25"), &d2), ShouldBeNil)
+			So(d2.Duration, ShouldEqual, 75*time.Minute)
+			So(json.Unmarshal([]byte("false"), &d2), ShouldBeNil)
+			So(d2.IsZero(), ShouldBeTrue)
+			So(json.Unmarshal([]byte(`"abc"`), &d2), ShouldNotBeNil)
+		})
+		Convey("Database value", func() {
+			val, err := d.Value()
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, "9000000000 microseconds")
+		})
+		Convey("Scanning PostgreSQL intervals", func() {
+			var d2 Duration
+			So(d2.Scan([]byte("02:30:00")), ShouldBeNil)
+			So(d2.Equal(d), ShouldBeTrue)
+			So(d2.Scan("1 day 01:00:00.5"), ShouldBeNil)
+			So(d2.Duration, ShouldEqual, 25*time.Hour+500*time.Millisecond)
+			So(d2.Scan("-01:15:00"), ShouldBeNil)
+			So(d2.Duration, ShouldEqual, -75*time.Minute)
+			So(d2.Scan("1 year 2 mons 3 days"), ShouldBeNil)
+			So(d2.Duration, ShouldEqual, (365+60+3)*24*time.Hour)
+			So(d2.Scan("2h30m"), ShouldBeNil)
+			So(d2.Equal(d), ShouldBeTrue)
+			So(d2.Scan(nil), ShouldBeNil)
+			So(d2.IsZero(), ShouldBeTrue)
+			So(d2.Scan("3 weeks"), ShouldNotBeNil)
+			So(d2.Scan(1.5), ShouldBeNil)
+			So(d2.Duration, ShouldEqual, 90*time.Minute)
+			So(d2.Scan(true), ShouldNotBeNil)
+		})
+		Convey("Parsing and adding", func() {
+			So(ParseDuration("00:45:00").Add(ParseDuration("01:15:00")).Hours(), ShouldEqual, 2)
+			So(func() { ParseDuration("foo") }, ShouldPanic)
+		})
+	})
+}
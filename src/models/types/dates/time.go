@@ -0,0 +1,149 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package dates
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultServerTimeFormat is the Go layout for Time objects
+	DefaultServerTimeFormat = "15:04:05"
+)
+
+// Time type is a time of the day without date nor timezone.
+// It JSON marshals and unmarshals as "HH:MM:SS".
+//
+// The zero Time is not set and is marshalled as false and stored as NULL.
+// Midnight is a valid Time and is different from the zero Time.
+type Time struct {
+	time.Time
+}
+
+// NewTime returns a Time with the given hour, minutes and seconds
+func NewTime(hour, min, sec int) Time {
+	return Time{time.Date(0, 1, 1, hour, min, sec, 0, time.UTC)}
+}
+
+// IsZero returns true if this Time is not set
+func (t Time) IsZero() bool {
+	return t.Time.IsZero()
+}
+
+// String method for Time.
+func (t Time) String() string {
+	bs, _ := t.MarshalJSON()
+	return strings.Trim(string(bs), "\"")
+}
+
+// MarshalJSON for Time type
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("false"), nil
+	}
+	return []byte(fmt.Sprintf(`"%s"`, t.Time.Format(DefaultServerTimeFormat))), nil
+}
+
+// UnmarshalJSON for Time type
+func (t *Time) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), "\"")
+	if str == "false" || str == "null" {
+		*t = Time{}
+		return nil
+	}
+	val, err := ParseTimeWithLayout(DefaultServerTimeFormat, str)
+	*t = val
+	return err
+}
+
+// Value formats our Time for storing in database
+// Especially handles empty Time.
+func (t Time) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.Time.Format("15:04:05.999999"), nil
+}
+
+// Scan casts the database output to a Time
+func (t *Time) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = Time{}
+		return nil
+	case time.Time:
+		*t = timeOfDay(v)
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		if v == "" {
+			*t = Time{}
+			return nil
+		}
+		val, err := ParseTimeWithLayout("15:04:05.999999999", v)
+		*t = val
+		return err
+	}
+	return fmt.Errorf("Time data is not time.Time but %T", src)
+}
+
+// Equal returns true if t and other are the same time of the day
+func (t Time) Equal(other Time) bool {
+	return t.Time.Equal(other.Time)
+}
+
+// Greater returns true if t is strictly after other in the day
+func (t Time) Greater(other Time) bool {
+	return t.Time.After(other.Time)
+}
+
+// Lower returns true if t is strictly before other in the day
+func (t Time) Lower(other Time) bool {
+	return t.Time.Before(other.Time)
+}
+
+// Sub returns the duration between t and other
+func (t Time) Sub(other Time) Duration {
+	return Duration{t.Time.Sub(other.Time)}
+}
+
+// OnDate returns a DateTime at this time on the given date in the given location
+func (t Time) OnDate(date Date, loc *time.Location) DateTime {
+	return DateTime{time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)}
+}
+
+// ParseTime returns a Time from the given string value
+// that is formatted with the default HH:MM:SS format.
+//
+// It panics in case the parsing cannot be done.
+func ParseTime(value string) Time {
+	t, err := ParseTimeWithLayout(DefaultServerTimeFormat, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTimeWithLayout returns a Time from the given string value
+// that is formatted with layout.
+func ParseTimeWithLayout(layout, value string) (Time, error) {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return Time{}, err
+	}
+	return timeOfDay(t), nil
+}
+
+// timeOfDay returns the time of the day of the given time.Time
+func timeOfDay(t time.Time) Time {
+	return Time{time.Date(0, 1, 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)}
+}
+
+var _ driver.Valuer = Time{}
+var _ sql.Scanner = new(Time)
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package dates
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTime(t *testing.T) {
+	Convey("Testing Time objects", t, func() {
+		tm := ParseTime("14:35:12")
+		Convey("Parsing should be correct", func() {
+			So(tm.Hour(), ShouldEqual, 14)
+			So(tm.Minute(), ShouldEqual, 35)
+			So(tm.Second(), ShouldEqual, 12)
+			So(tm.Equal(NewTime(14, 35, 12)), ShouldBeTrue)
+			So(func() { ParseTime("2017-08-01") }, ShouldPanic)
+		})
+		Convey("Marshaling and String should work", func() {
+			So(tm.String(), ShouldEqual, "14:35:12")
+			data, _ := json.Marshal(tm)
+			So(string(data), ShouldEqual, `"14:35:12"`)
+			data, _ = json.Marshal(Time{})
+			So(string(data), ShouldEqual, "false")
+		})
+		Convey("Unmarshaling", func() {
+			var tm2 Time
+			So(json.Unmarshal([]byte(`"14:35:12"`), &tm2), ShouldBeNil)
+			So(tm2.Equal(tm), ShouldBeTrue)
+			So(json.Unmarshal([]byte("false"), &tm2), ShouldBeNil)
+			So(tm2.IsZero(), ShouldBeTrue)
+		})
+		Convey("Database value and scanning", func() {
+			val, _ := tm.Value()
+			So(val, ShouldEqual, "14:35:12")
+			val, _ = Time{}.Value()
+			So(val, ShouldBeNil)
+			var tm2 Time
+			So(tm2.Scan([]byte("14:35:12.5")), ShouldBeNil)
+			So(tm2.Nanosecond(), ShouldEqual, 500000000)
+			So(tm2.Scan(time.Date(2020, 3, 4, 14, 35, 12, 0, time.UTC)), ShouldBeNil)
+			So(tm2.Equal(tm), ShouldBeTrue)
+			So(tm2.Scan(12), ShouldNotBeNil)
+		})
+		Convey("Midnight should not be zero", func() {
+			midnight := NewTime(0, 0, 0)
+			So(midnight.IsZero(), ShouldBeFalse)
+			So(Time{}.IsZero(), ShouldBeTrue)
+			data, _ := json.Marshal(midnight)
+			So(string(data), ShouldEqual, `"00:00:00"`)
+			var tm2 Time
+			So(json.Unmarshal(data, &tm2), ShouldBeNil)
+			So(tm2.IsZero(), ShouldBeFalse)
+			So(tm2.Equal(midnight), ShouldBeTrue)
+			val, _ := midnight.Value()
+			So(val, ShouldEqual, "00:00:00")
+			var tm3 Time
+			So(tm3.Scan(val), ShouldBeNil)
+			So(tm3.IsZero(), ShouldBeFalse)
+			So(tm3.Equal(midnight), ShouldBeTrue)
+			So(tm3.Scan(time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)), ShouldBeNil)
+			So(tm3.Equal(midnight), ShouldBeTrue)
+		})
+		Convey("Comparing and combining", func() {
+			So(tm.Greater(NewTime(8, 0, 0)), ShouldBeTrue)
+			So(tm.Lower(NewTime(8, 0, 0)), ShouldBeFalse)
+			So(tm.Sub(NewTime(14, 0, 0)).Duration, ShouldEqual, 35*time.Minute+12*time.Second)
+			dt := tm.OnDate(ParseDate("2020-03-04"), time.UTC)
+			So(dt.String(), ShouldEqual, "2020-03-04 14:35:12")
+		})
+	})
+}
@@ -153,16 +153,6 @@ func addIDIfNotPresent(fields []FieldName) []FieldName {
 	return fields
 }
 
-// getGroupCondition returns the condition to retrieve the individual aggregated rows in vals
-// knowing that they were grouped by groups and that we had the given initial condition
-func getGroupCondition(groups []FieldName, vals map[string]interface{}, initialCondition *Condition) *Condition {
-	res := initialCondition
-	for _, group := range groups {
-		res = res.And().Field(group).Equals(vals[group.JSON()])
-	}
-	return res
-}
-
 // substituteKeys returns a new map with its keys substituted following substMap after changing sqlSep into ExprSep.
 // vals keys that are not found in substMap are not returned
 func substituteKeys(vals map[string]interface{}, substMap map[string]string) map[string]interface{} {
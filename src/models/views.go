@@ -0,0 +1,186 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gleke/hexya/src/models/security"
+)
+
+// A ViewDefinition defines the SQL view of a manual model.
+//
+// The view is created or replaced when the database is synchronized.
+// It must return an id column and a column for each stored field of
+// the model.
+type ViewDefinition struct {
+	// SQL is the raw SQL query of the view. If empty, the view is
+	// built from the Query function.
+	SQL string
+	// Query returns the RecordSet from which the view is built. Each
+	// record of this RecordSet is a row of the view with the same id.
+	Query func(env Environment) RecordSet
+	// Fields maps the fields of the view model to the field paths
+	// of the Query RecordSet (e.g. "Profile.City"). Stored fields of
+	// the view model which are not in this map are taken from the
+	// field with the same name.
+	Fields map[string]string
+	// DependsOn is the list of the view models that are used by
+	// this view and must be created before it.
+	DependsOn []string
+	// Materialized is true if this view is a materialized view
+	Materialized bool
+	// RefreshPeriod is the period at which a materialized view is
+	// refreshed by the worker loop. The view is not refreshed
+	// automatically if RefreshPeriod is 0.
+	RefreshPeriod time.Duration
+}
+
+// SetView sets the definition of the SQL view of this model.
+// This model must be a manual model.
+func (m *Model) SetView(def ViewDefinition) {
+	if !m.IsManual() {
+		log.Panic("Views can only be set on manual models", "model", m.name)
+	}
+	if def.SQL == "" && def.Query == nil {
+		log.Panic("View definition must have either an SQL query or a Query function", "model", m.name)
+	}
+	if def.RefreshPeriod > 0 && !def.Materialized {
+		log.Panic("Refresh period can only be set on materialized views", "model", m.name)
+	}
+	m.view = &def
+}
+
+// IsView returns true if this model has a view definition
+func (m *Model) IsView() bool {
+	return m.view != nil
+}
+
+// IsMaterializedView returns true if this model is a materialized view
+func (m *Model) IsMaterializedView() bool {
+	return m.view != nil && m.view.Materialized
+}
+
+// viewSQL returns the SQL query of this model's view
+func (m *Model) viewSQL() string {
+	if m.view.SQL != "" {
+		return strings.TrimSpace(m.view.SQL)
+	}
+	var res string
+	err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := m.view.Query(env).Collection()
+		adapter := adapters[db.DriverName()]
+		fields := []FieldName{rc.model.FieldName("ID")}
+		columns := []string{"id"}
+		for _, colName := range m.storedColumnNames() {
+			fi := m.fields.MustGet(colName)
+			path, ok := m.view.Fields[fi.name]
+			if !ok {
+				path = fi.name
+			}
+			fields = append(fields, rc.model.FieldName(path))
+			columns = append(columns, colName)
+		}
+		query, args, substs := rc.query.selectQuery(fields)
+		aliases := make(map[string]string, len(substs))
+		for realAlias, natAlias := range substs {
+			aliases[natAlias] = realAlias
+		}
+		selects := make([]string, len(fields))
+		for i, field := range fields {
+			natAlias := joinFieldNames(splitFieldNames(field, ExprSep), sqlSep).JSON()
+			selects[i] = fmt.Sprintf("v.%s AS %s", aliases[natAlias], columns[i])
+		}
+		res = fmt.Sprintf("SELECT %s FROM (%s) v", strings.Join(selects, ", "), inlineSQLArgs(adapter, query, args))
+	})
+	if err != nil {
+		log.Panic("Unable to build view query", "model", m.name, "error", err)
+	}
+	return res
+}
+
+// viewModels returns the models with a view definition sorted so
+// that each view comes after the views it depends on.
+func viewModels() []*Model {
+	modelNames := make([]string, 0, len(Registry.registryByName))
+	for modelName, model := range Registry.registryByName {
+		if model.IsView() {
+			modelNames = append(modelNames, modelName)
+		}
+	}
+	sort.Strings(modelNames)
+	var res []*Model
+	visited := make(map[string]bool)
+	inProgress := make(map[string]bool)
+	var visit func(model *Model)
+	visit = func(model *Model) {
+		if visited[model.name] {
+			return
+		}
+		if inProgress[model.name] {
+			log.Panic("Circular dependency between views", "model", model.name)
+		}
+		inProgress[model.name] = true
+		for _, dep := range model.view.DependsOn {
+			depModel := Registry.MustGet(dep)
+			if !depModel.IsView() {
+				log.Panic("View depends on a model which is not a view", "model", model.name, "dependency", dep)
+			}
+			visit(depModel)
+		}
+		delete(inProgress, model.name)
+		visited[model.name] = true
+		res = append(res, model)
+	}
+	for _, modelName := range modelNames {
+		visit(Registry.MustGet(modelName))
+	}
+	return res
+}
+
+// RefreshMaterializedView refreshes the data of this RecordCollection's
+// model, which must be a materialized view.
+//
+// If concurrently is true, the view is refreshed without locking out
+// concurrent selects on it.
+func (rc *RecordCollection) RefreshMaterializedView(concurrently bool) {
+	if !rc.model.IsMaterializedView() {
+		log.Panic("Model is not a materialized view", "model", rc.model.name)
+	}
+	adapter := adapters[db.DriverName()]
+	var concurrentlySQL string
+	if concurrently {
+		concurrentlySQL = "CONCURRENTLY "
+	}
+	rc.env.Cr().Execute(fmt.Sprintf(`REFRESH MATERIALIZED VIEW %s%s`, concurrentlySQL, adapter.quoteTableName(rc.model.tableName)))
+	rc.env.cache.deleteModelData(rc.model.name)
+	rc.env.invalidateSharedCache(rc.model, nil)
+}
+
+// registerViewRefreshWorkers registers a worker for each
+// materialized view with a refresh period.
+//
+// In multi-tenant mode, the views are refreshed in the databases
+// of all the loaded tenants.
+func registerViewRefreshWorkers() {
+	for _, model := range viewModels() {
+		if model.view.RefreshPeriod == 0 {
+			continue
+		}
+		modelName := model.name
+		RegisterWorker(NewWorkerFunction(func() {
+			for _, tenant := range loadedTenants() {
+				err := ExecuteInTenantEnvironment(tenant, security.SuperUserID, func(env Environment) {
+					env.Pool(modelName).RefreshMaterializedView(true)
+				})
+				if err != nil {
+					log.Warn("Unable to refresh materialized view", "model", modelName, "tenant", tenant, "error", err)
+				}
+			}
+		}, model.view.RefreshPeriod))
+	}
+}
@@ -0,0 +1,748 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleke/hexya/src/models/fieldtype"
+	"github.com/gleke/hexya/src/models/operator"
+	"github.com/gleke/hexya/src/models/security"
+	"github.com/gleke/hexya/src/models/types"
+	"github.com/gleke/hexya/src/models/types/dates"
+)
+
+const (
+	// webhookModelName is the name of the system model of webhooks
+	webhookModelName = "HexyaWebhook"
+	// webhookDeliveryModelName is the name of the system model of webhook deliveries
+	webhookDeliveryModelName = "HexyaWebhookDelivery"
+	// webhookConfigTTL is the duration after which the webhooks
+	// configuration is reloaded from the database.
+	webhookConfigTTL = time.Minute
+	// webhookBatchSize is the maximum number of deliveries
+	// sent by the worker at each run for each database.
+	webhookBatchSize = 50
+	// webhookMaxResponseSize is the maximum number of bytes of
+	// the response of a webhook that is kept in the delivery log
+	webhookMaxResponseSize = 1024
+)
+
+// Webhook delivery states
+const (
+	webhookPending = "pending"
+	webhookDone    = "done"
+	webhookFailed  = "failed"
+)
+
+// Webhook HTTP headers
+const (
+	// WebhookSignatureHeader is the HTTP header holding the HMAC-SHA256
+	// signature of the payload with the secret of the webhook.
+	WebhookSignatureHeader = "X-Hexya-Signature"
+	// WebhookEventHeader is the HTTP header holding the event of a webhook delivery
+	WebhookEventHeader = "X-Hexya-Event"
+	// WebhookDeliveryHeader is the HTTP header holding the ID of a webhook delivery
+	WebhookDeliveryHeader = "X-Hexya-Delivery"
+)
+
+var (
+	// webhookWorkerPeriod is the period at which the worker sends the pending deliveries
+	webhookWorkerPeriod = 5 * time.Second
+	// webhookRetryDelay is the delay before the first retry of a failed delivery.
+	// The delay is doubled after each failed attempt.
+	webhookRetryDelay = 30 * time.Second
+	// webhookMaxRetryDelay is the maximum delay between two attempts of a delivery
+	webhookMaxRetryDelay = 6 * time.Hour
+	// webhookLeaseDuration is the duration during which a delivery being sent
+	// by a worker is not sent by the workers of other processes.
+	webhookLeaseDuration = 2 * time.Minute
+	// webhookClient is the HTTP client used to send webhooks
+	webhookClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// A webhookConfig is the configuration of a webhook loaded from the database
+type webhookConfig struct {
+	id         int64
+	name       string
+	model      string
+	url        string
+	events     map[EventType]bool
+	fields     []string
+	condition  *Condition
+	secret     string
+	maxRetries int
+}
+
+// A webhookConfigs holds the active webhooks of a database by model
+type webhookConfigs struct {
+	loadedAt time.Time
+	byModel  map[string][]*webhookConfig
+}
+
+var (
+	webhooksMutex sync.Mutex
+	webhooks      = make(map[string]*webhookConfigs)
+	// webhooksVersion is incremented at each invalidation
+	// of the webhooks configuration.
+	webhooksVersion uint64
+)
+
+// A webhookPayload is the JSON payload sent to the URL of a webhook
+type webhookPayload struct {
+	Webhook   string       `json:"webhook"`
+	Event     string       `json:"event"`
+	Model     string       `json:"model"`
+	Tenant    string       `json:"tenant,omitempty"`
+	UserID    int64        `json:"user_id"`
+	Fields    []string     `json:"fields,omitempty"`
+	Records   []*ModelData `json:"records"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// A webhookDelivery is a delivery being sent by the worker
+type webhookDelivery struct {
+	id         int64
+	event      string
+	payload    string
+	attempts   int64
+	url        string
+	secret     string
+	maxRetries int64
+}
+
+// declareWebhookModels declares the system models
+// of webhooks and of their delivery log.
+func declareWebhookModels() {
+	webhook := CreateModel(webhookModelName, SystemModel)
+	webhook.created = true
+	webhook.InheritModel(Registry.MustGet("CommonMixin"))
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Name",
+		description: "Name",
+		json:        "name",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		required:    true,
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "URL",
+		description: "Target URL",
+		json:        "url",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		required:    true,
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "ModelName",
+		description: "Model",
+		json:        "model_name",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		required:    true,
+		index:       true,
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Events",
+		description: "Trigger Events",
+		help:        "Comma separated list of the events that trigger the webhook among 'create', 'write' and 'unlink'. All events if empty.",
+		json:        "events",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Fields",
+		description: "Fields",
+		help:        "Comma separated list of the fields that are sent in the payload and whose update triggers the webhook. All stored fields if empty.",
+		json:        "fields",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Domain",
+		description: "Condition",
+		help:        "JSON domain that the records must match to trigger the webhook.",
+		json:        "domain",
+		fieldType:   fieldtype.Text,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Secret",
+		description: "Secret",
+		help:        "Secret key with which payloads are signed.",
+		json:        "secret",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		encrypted:   true,
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "MaxRetries",
+		description: "Maximum Retries",
+		json:        "max_retries",
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		defaultFunc: DefaultValue(int64(8)),
+	})
+	webhook.fields.add(&Field{
+		model:       webhook,
+		name:        "Active",
+		description: "Active",
+		json:        "active",
+		fieldType:   fieldtype.Boolean,
+		structField: reflect.StructField{Type: reflect.TypeOf(true)},
+		defaultFunc: DefaultValue(true),
+	})
+
+	delivery := CreateModel(webhookDeliveryModelName, SystemModel)
+	delivery.created = true
+	delivery.InheritModel(Registry.MustGet("CommonMixin"))
+	delivery.fields.add(&Field{
+		model:            delivery,
+		name:             "Webhook",
+		description:      "Webhook",
+		json:             "webhook_id",
+		fieldType:        fieldtype.Many2One,
+		structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		relatedModelName: webhookModelName,
+		onDelete:         Cascade,
+		required:         true,
+		index:            true,
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "Event",
+		description: "Event",
+		json:        "event",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "Payload",
+		description: "Payload",
+		json:        "payload",
+		fieldType:   fieldtype.Text,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "State",
+		description: "State",
+		json:        "state",
+		fieldType:   fieldtype.Selection,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		selection: types.Selection{
+			webhookPending: "Pending",
+			webhookDone:    "Delivered",
+			webhookFailed:  "Failed",
+		},
+		index:       true,
+		defaultFunc: DefaultValue(webhookPending),
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "Attempts",
+		description: "Attempts",
+		json:        "attempts",
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "NextAttempt",
+		description: "Next Attempt",
+		json:        "next_attempt",
+		fieldType:   fieldtype.DateTime,
+		structField: reflect.StructField{Type: reflect.TypeOf(dates.DateTime{})},
+		index:       true,
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "LastAttempt",
+		description: "Last Attempt",
+		json:        "last_attempt",
+		fieldType:   fieldtype.DateTime,
+		structField: reflect.StructField{Type: reflect.TypeOf(dates.DateTime{})},
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "ResponseStatus",
+		description: "Response Status",
+		json:        "response_status",
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+	})
+	delivery.fields.add(&Field{
+		model:       delivery,
+		name:        "Response",
+		description: "Response",
+		json:        "response",
+		fieldType:   fieldtype.Text,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+	})
+
+	webhook.Events().AfterCommit(func(event Event) {
+		invalidateWebhookConfigs(event.Tenant)
+	})
+}
+
+// registerWebhooks subscribes the webhook handlers to the events of all
+// the models and registers the worker that sends the webhooks.
+func registerWebhooks() {
+	for _, model := range Registry.registryByName {
+		if model.IsMixin() || model.IsManual() || model.isSystem() {
+			continue
+		}
+		model.Events().AfterCreate(func(rs RecordSet) {
+			rs.Collection().queueWebhookDeliveries(CreateEvent, nil)
+		})
+		model.Events().AfterWrite(func(rs RecordSet, fields FieldNames) {
+			rs.Collection().queueWebhookDeliveries(WriteEvent, fields)
+		})
+		model.Events().BeforeUnlink(func(rs RecordSet) {
+			rs.Collection().queueWebhookDeliveries(UnlinkEvent, nil)
+		})
+	}
+	RegisterWorker(NewWorkerFunction(deliverWebhooks, webhookWorkerPeriod))
+}
+
+// invalidateWebhookConfigs removes the webhooks configuration of the
+// database of the given tenant so that it is reloaded at next use.
+func invalidateWebhookConfigs(tenant string) {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	delete(webhooks, tenant)
+	webhooksVersion++
+}
+
+// activeWebhooks returns the active webhooks of the given model
+// on the database of the given Environment.
+//
+// The configuration is loaded outside the lock and in the transaction of the
+// Environment, so that no other transaction is opened while records are being
+// written. It is not cached if it has been invalidated while loading or if
+// webhooks have been modified in this transaction.
+func activeWebhooks(env Environment, model string) []*webhookConfig {
+	webhooksMutex.Lock()
+	configs, ok := webhooks[env.tenant]
+	version := webhooksVersion
+	webhooksMutex.Unlock()
+	if ok && time.Since(configs.loadedAt) <= webhookConfigTTL {
+		return configs.byModel[model]
+	}
+	configs = loadWebhookConfigs(env)
+	if !webhooksModified(env) {
+		webhooksMutex.Lock()
+		if webhooksVersion == version {
+			webhooks[env.tenant] = configs
+		}
+		webhooksMutex.Unlock()
+	}
+	return configs.byModel[model]
+}
+
+// webhooksModified returns true if webhooks have been created, modified
+// or deleted in the transaction of the given Environment.
+func webhooksModified(env Environment) bool {
+	for _, event := range *env.events {
+		if event.Model == webhookModelName {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWebhookConfigs loads the active webhooks from the database of
+// the given Environment. Invalid webhooks are logged and skipped.
+func loadWebhookConfigs(env Environment) *webhookConfigs {
+	res := &webhookConfigs{
+		loadedAt: time.Now(),
+		byModel:  make(map[string][]*webhookConfig),
+	}
+	webhookModel := Registry.MustGet(webhookModelName)
+	hooks := env.Pool(webhookModelName).Sudo().Search(webhookModel.Field(webhookModel.FieldName("Active")).Equals(true)).Fetch()
+	for _, hook := range hooks.Records() {
+		config, err := newWebhookConfig(hook)
+		if err != nil {
+			log.Warn("Invalid webhook skipped", "tenant", env.tenant, "webhook", hook.Get(webhookModel.FieldName("Name")), "error", err)
+			continue
+		}
+		res.byModel[config.model] = append(res.byModel[config.model], config)
+	}
+	return res
+}
+
+// newWebhookConfig returns the configuration of the given webhook record.
+// It returns an error if the webhook has an unknown model, event or field,
+// or an invalid domain.
+func newWebhookConfig(hook *RecordCollection) (config *webhookConfig, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			config, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	webhookModel := Registry.MustGet(webhookModelName)
+	modelName := hook.Get(webhookModel.FieldName("ModelName")).(string)
+	model, ok := Registry.Get(modelName)
+	if !ok {
+		return nil, fmt.Errorf("unknown model %s", modelName)
+	}
+	config = &webhookConfig{
+		id:         hook.ids[0],
+		name:       hook.Get(webhookModel.FieldName("Name")).(string),
+		model:      modelName,
+		url:        hook.Get(webhookModel.FieldName("URL")).(string),
+		events:     make(map[EventType]bool),
+		fields:     splitWebhookList(hook.Get(webhookModel.FieldName("Fields")).(string)),
+		secret:     hook.Get(webhookModel.FieldName("Secret")).(string),
+		maxRetries: int(hook.Get(webhookModel.FieldName("MaxRetries")).(int64)),
+	}
+	for _, evt := range splitWebhookList(hook.Get(webhookModel.FieldName("Events")).(string)) {
+		config.events[webhookEventType(evt)] = true
+	}
+	for i, field := range config.fields {
+		config.fields[i] = model.fields.MustGet(field).json
+	}
+	if domain := hook.Get(webhookModel.FieldName("Domain")).(string); domain != "" {
+		var dom []interface{}
+		if err := json.Unmarshal([]byte(domain), &dom); err != nil {
+			return nil, fmt.Errorf("invalid domain %s: %s", domain, err)
+		}
+		config.condition = parseDomain(model, dom)
+		// Build the query of the condition so that invalid
+		// domains are detected before the webhook is used
+		hook.env.Pool(modelName).Search(config.condition).query.selectQuery(FieldNames{ID})
+	}
+	return config, nil
+}
+
+// splitWebhookList returns the trimmed non empty
+// items of the given comma separated list.
+func splitWebhookList(list string) []string {
+	var res []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// webhookEventType returns the EventType with the given name
+func webhookEventType(name string) EventType {
+	for _, et := range []EventType{CreateEvent, WriteEvent, UnlinkEvent} {
+		if et.String() == name {
+			return et
+		}
+	}
+	log.Panic("Unknown webhook event", "event", name)
+	return 0
+}
+
+// triggers returns true if this webhook is triggered by the
+// given event type on the given written fields.
+func (wc *webhookConfig) triggers(eventType EventType, fields FieldNames) bool {
+	if len(wc.events) > 0 && !wc.events[eventType] {
+		return false
+	}
+	if eventType != WriteEvent || len(wc.fields) == 0 {
+		return true
+	}
+	for _, field := range fields {
+		for _, f := range wc.fields {
+			if field.JSON() == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queueWebhookDeliveries creates the deliveries of the webhooks
+// triggered by the given event on this RecordCollection.
+//
+// Deliveries are created in the transaction of the event, so that they
+// are only sent by the worker if the transaction is committed.
+func (rc *RecordCollection) queueWebhookDeliveries(eventType EventType, fields FieldNames) {
+	if rc.hasNegIds {
+		return
+	}
+	for _, hook := range activeWebhooks(*rc.env, rc.model.name) {
+		if !hook.triggers(eventType, fields) {
+			continue
+		}
+		records := rc.env.Pool(rc.model.name).Sudo().Search(rc.model.Field(ID).In(rc.ids))
+		if hook.condition != nil {
+			records = records.Search(hook.condition)
+		}
+		records.Fetch()
+		if records.IsEmpty() {
+			continue
+		}
+		payload := webhookPayload{
+			Webhook:   hook.name,
+			Event:     eventType.String(),
+			Model:     rc.model.name,
+			Tenant:    rc.env.tenant,
+			UserID:    rc.env.uid,
+			Records:   records.webhookRecordsData(hook.fields),
+			Timestamp: time.Now().UTC(),
+		}
+		for _, field := range fields {
+			payload.Fields = append(payload.Fields, field.JSON())
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Panic("Unable to marshal webhook payload", "webhook", hook.name, "error", err)
+		}
+		deliveryModel := Registry.MustGet(webhookDeliveryModelName)
+		rc.env.Pool(webhookDeliveryModelName).Sudo().Call("Create", NewModelData(deliveryModel).
+			Set(deliveryModel.FieldName("Webhook"), hook.id).
+			Set(deliveryModel.FieldName("Event"), eventType.String()).
+			Set(deliveryModel.FieldName("Payload"), string(data)).
+			Set(deliveryModel.FieldName("NextAttempt"), dates.Now()))
+	}
+}
+
+// webhookRecordsData returns the data of the given fields of the records
+// of this RecordCollection, or of all their stored fields if fields is empty.
+// Relation fields are given as ids.
+func (rc *RecordCollection) webhookRecordsData(fields []string) []*ModelData {
+	fNames := rc.model.fields.storedFieldNames()
+	if len(fields) > 0 {
+		fNames = FieldNames{ID}
+		for _, f := range fields {
+			fNames = append(fNames, rc.model.FieldName(f))
+		}
+	}
+	res := make([]*ModelData, 0, rc.Len())
+	for _, rec := range rc.Records() {
+		data := NewModelData(rc.model)
+		for _, fName := range fNames {
+			value := rec.Get(fName)
+			if rs, ok := value.(RecordSet); ok {
+				fi := rc.model.fields.MustGet(fName.JSON())
+				if fi.fieldType.IsFKRelationType() {
+					var id int64
+					if !rs.IsEmpty() {
+						id = rs.Ids()[0]
+					}
+					value = id
+				} else {
+					value = rs.Ids()
+				}
+			}
+			data.Set(fName, value)
+		}
+		res = append(res, data)
+	}
+	return res
+}
+
+// deliverWebhooks sends the pending webhook deliveries
+// of the default database, or of all loaded tenants.
+func deliverWebhooks() {
+	for _, tenant := range loadedTenants() {
+		deliverTenantWebhooks(tenant)
+	}
+}
+
+// deliverTenantWebhooks sends the pending webhook
+// deliveries of the database of the given tenant.
+func deliverTenantWebhooks(tenant string) {
+	var deliveries []webhookDelivery
+	err := ExecuteInTenantEnvironment(tenant, security.SuperUserID, func(env Environment) {
+		deliveries = claimWebhookDeliveries(env)
+	})
+	if err != nil {
+		log.Warn("Unable to fetch webhook deliveries", "tenant", tenant, "error", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		status, response, sendErr := delivery.send()
+		err = ExecuteInTenantEnvironment(tenant, security.SuperUserID, func(env Environment) {
+			delivery.record(env, status, response, sendErr)
+		})
+		if err != nil {
+			log.Warn("Unable to record webhook delivery", "tenant", tenant, "delivery", delivery.id, "error", err)
+		}
+	}
+}
+
+// claimWebhookDeliveries returns the deliveries that are due to be sent and
+// postpones their next attempt by the lease duration so that they are not sent
+// concurrently by other processes.
+func claimWebhookDeliveries(env Environment) []webhookDelivery {
+	deliveryModel := Registry.MustGet(webhookDeliveryModelName)
+	webhookModel := Registry.MustGet(webhookModelName)
+	now := dates.Now()
+	pending := env.Pool(webhookDeliveryModelName).
+		Search(deliveryModel.Field(deliveryModel.FieldName("State")).Equals(webhookPending).
+			And().Field(deliveryModel.FieldName("NextAttempt")).LowerOrEqual(now)).
+		OrderBy("NextAttempt", "ID").
+		Limit(webhookBatchSize).
+		Fetch()
+	if pending.IsEmpty() {
+		return nil
+	}
+	var res []webhookDelivery
+	for _, rec := range pending.Records() {
+		hook := rec.Get(deliveryModel.FieldName("Webhook")).(RecordSet).Collection()
+		res = append(res, webhookDelivery{
+			id:         rec.ids[0],
+			event:      rec.Get(deliveryModel.FieldName("Event")).(string),
+			payload:    rec.Get(deliveryModel.FieldName("Payload")).(string),
+			attempts:   rec.Get(deliveryModel.FieldName("Attempts")).(int64),
+			url:        hook.Get(webhookModel.FieldName("URL")).(string),
+			secret:     hook.Get(webhookModel.FieldName("Secret")).(string),
+			maxRetries: hook.Get(webhookModel.FieldName("MaxRetries")).(int64),
+		})
+	}
+	pending.Set(deliveryModel.FieldName("NextAttempt"), now.Add(webhookLeaseDuration))
+	return res
+}
+
+// WebhookSignature returns the signature of the given webhook payload with
+// the given secret, as sent in the WebhookSignatureHeader.
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts this delivery's payload to the URL of its webhook.
+// It returns the HTTP status and body of the response.
+func (wd webhookDelivery) send() (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, wd.url, bytes.NewBufferString(wd.payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, wd.event)
+	req.Header.Set(WebhookDeliveryHeader, fmt.Sprintf("%d", wd.id))
+	if wd.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(wd.secret, []byte(wd.payload)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// record updates the delivery log with the result of an attempt
+// to send this delivery and schedules the next attempt on failure.
+func (wd webhookDelivery) record(env Environment, status int, response string, sendErr error) {
+	deliveryModel := Registry.MustGet(webhookDeliveryModelName)
+	attempts := wd.attempts + 1
+	data := NewModelData(deliveryModel).
+		Set(deliveryModel.FieldName("Attempts"), attempts).
+		Set(deliveryModel.FieldName("LastAttempt"), dates.Now()).
+		Set(deliveryModel.FieldName("ResponseStatus"), int64(status)).
+		Set(deliveryModel.FieldName("Response"), response)
+	switch {
+	case sendErr == nil:
+		data.Set(deliveryModel.FieldName("State"), webhookDone)
+	case attempts > wd.maxRetries:
+		log.Warn("Webhook delivery failed", "delivery", wd.id, "url", wd.url, "attempts", attempts, "error", sendErr)
+		data.Set(deliveryModel.FieldName("State"), webhookFailed)
+		data.Set(deliveryModel.FieldName("Response"), fmt.Sprintf("%s\n%s", sendErr, response))
+	default:
+		data.Set(deliveryModel.FieldName("NextAttempt"), dates.Now().Add(webhookRetryBackoff(attempts)))
+		data.Set(deliveryModel.FieldName("Response"), fmt.Sprintf("%s\n%s", sendErr, response))
+	}
+	env.Pool(webhookDeliveryModelName).withIds([]int64{wd.id}).Call("Write", data)
+}
+
+// webhookRetryBackoff returns the delay before the next attempt
+// of a delivery that failed the given number of times.
+func webhookRetryBackoff(attempts int64) time.Duration {
+	delay := webhookRetryDelay
+	for i := int64(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxRetryDelay {
+			return webhookMaxRetryDelay
+		}
+	}
+	return delay
+}
+
+// parseDomain returns the Condition on the given model of the
+// given domain, which is a list of terms in prefix notation such
+// as the result of Condition.Serialize.
+//
+// Terms are either the "&", "|" or "!" operators, or a list of
+// a field path, an operator and a value.
+func parseDomain(model *Model, domain []interface{}) *Condition {
+	res := newCondition()
+	for len(domain) > 0 {
+		var cond *Condition
+		cond, domain = parseDomainTerm(model, domain)
+		res = res.AndCond(cond)
+	}
+	return res
+}
+
+// parseDomainTerm returns the Condition of the first term of the given domain
+// and the remaining terms.
+func parseDomainTerm(model *Model, domain []interface{}) (*Condition, []interface{}) {
+	if len(domain) == 0 {
+		log.Panic("Incomplete domain", "model", model.name)
+	}
+	switch term := domain[0].(type) {
+	case string:
+		switch term {
+		case "!":
+			cond, rest := parseDomainTerm(model, domain[1:])
+			return newCondition().AndNotCond(cond), rest
+		case "&", "|":
+			left, rest := parseDomainTerm(model, domain[1:])
+			right, rest := parseDomainTerm(model, rest)
+			if term == "|" {
+				return newCondition().AndCond(left).OrCond(right), rest
+			}
+			return newCondition().AndCond(left).AndCond(right), rest
+		}
+	case []interface{}:
+		if len(term) != 3 {
+			break
+		}
+		field, ok := term[0].(string)
+		if !ok {
+			break
+		}
+		op, ok := term[1].(string)
+		if !ok || !operator.Operator(op).IsValid() {
+			log.Panic("Invalid operator in domain", "model", model.name, "operator", term[1])
+		}
+		return model.Field(model.FieldName(field)).AddOperator(operator.Operator(op), term[2]), domain[1:]
+	}
+	log.Panic("Invalid domain term", "model", model.name, "term", domain[0])
+	return nil, nil
+}
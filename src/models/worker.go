@@ -6,6 +6,8 @@ package models
 import (
 	"sync"
 	"time"
+
+	"github.com/gleke/hexya/src/tools/logging"
 )
 
 // A WorkerFunction can be executed in a loop in background every given LoopPeriod.
@@ -67,7 +69,7 @@ func RunWorkerLoop() {
 			for {
 				select {
 				case <-ticker.C:
-					wf.Run()
+					runWorkerFunction(wf)
 				case <-workerStop:
 					workerGroup.Done()
 					return
@@ -77,6 +79,17 @@ func RunWorkerLoop() {
 	}
 }
 
+// runWorkerFunction runs the given WorkerFunction once, logging
+// its panics so that a failing worker does not stop the server.
+func runWorkerFunction(wf WorkerFunction) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.LogPanicData(r)
+		}
+	}()
+	wf.Run()
+}
+
 // StopWorkerLoop stops the hexya core worker loop.
 //
 // Calling this method if the core worker loop is not running will cause panic.
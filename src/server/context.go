@@ -12,6 +12,7 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/gleke/hexya/src/models"
 	"github.com/gleke/hexya/src/tools/exceptions"
 	"github.com/gleke/hexya/src/tools/hweb"
 )
@@ -82,6 +83,43 @@ func (c *Context) Session() sessions.Session {
 	return sessions.Default(c.Context)
 }
 
+// Tenant returns the name of the tenant of this request, or an
+// empty string if the server is not in multi-tenant mode.
+func (c *Context) Tenant() string {
+	return models.TenantFromContext(c.Request.Context())
+}
+
+// SetTenant stores the given tenant in a new session so that it is
+// selected for the next requests that have no tenant header and whose
+// host name does not resolve to a tenant.
+func (c *Context) SetTenant(tenant string) {
+	session := c.Session()
+	session.Clear()
+	session.Set(tenantSessionKey, tenant)
+	session.Save()
+}
+
+// ExecuteInNewEnvironment executes the given fnct in a new Environment on
+// the database of the tenant of this request. The queries of the
+// Environment are canceled if the client cancels the request.
+//
+// It is a shortcut for models.ExecuteInNewEnvironmentContext with the
+// context of the request.
+func (c *Context) ExecuteInNewEnvironment(uid int64, fnct func(models.Environment)) error {
+	return models.ExecuteInNewEnvironmentContext(c.Request.Context(), uid, fnct)
+}
+
+// SimulateInNewEnvironment executes the given fnct in a new Environment on
+// the database of the tenant of this request and rolls back the transaction.
+// The queries of the Environment are canceled if the client cancels the
+// request.
+//
+// It is a shortcut for models.SimulateInNewEnvironmentContext with the
+// context of the request.
+func (c *Context) SimulateInNewEnvironment(uid int64, fnct func(models.Environment)) error {
+	return models.SimulateInNewEnvironmentContext(c.Request.Context(), uid, fnct)
+}
+
 // Super calls the next middleware / handler layer
 // It is an alias for Next
 func (c *Context) Super() {
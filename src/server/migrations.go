@@ -0,0 +1,268 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gleke/hexya/src/models"
+	"github.com/gleke/hexya/src/models/security"
+)
+
+// migrationTable is the name of the system table in which
+// applied migrations are recorded.
+const migrationTable = "hexya_migration"
+
+// A MigrationStage defines when a migration is run
+// relatively to the database schema synchronization.
+type MigrationStage string
+
+// Available migration stages
+const (
+	// PreSyncMigration migrations are run before the database schema is synchronized.
+	// Models may not match the database yet, so these migrations should use SQL
+	// queries through env.Cr() instead of RecordSets.
+	PreSyncMigration MigrationStage = "pre"
+	// PostSyncMigration migrations are run after the database schema is synchronized.
+	PostSyncMigration MigrationStage = "post"
+	// installStage is the stage of the record of the installation of a
+	// module in the migration table.
+	installStage MigrationStage = "install"
+)
+
+// A Migration is a versioned data migration function of a module.
+//
+// Migrations of a module are run once, in ascending version order.
+// Versions are dot separated numbers such as "1.2.0".
+type Migration struct {
+	Version     string
+	Description string
+	Func        func(env models.Environment)
+}
+
+// A MigrationStatus gives the state of a migration in the database
+type MigrationStatus struct {
+	Module      string
+	Stage       MigrationStage
+	Version     string
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// An appliedMigration is a migration record in the database
+type appliedMigration struct {
+	Module    string    `db:"module"`
+	Stage     string    `db:"stage"`
+	Version   string    `db:"version"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// migrations returns the migrations of this module for the given stage
+// sorted by version.
+func (m *Module) migrations(stage MigrationStage) []Migration {
+	var migrations []Migration
+	switch stage {
+	case PreSyncMigration:
+		migrations = append(migrations, m.PreMigrations...)
+	case PostSyncMigration:
+		migrations = append(migrations, m.PostMigrations...)
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	for i := 1; i < len(migrations); i++ {
+		if compareVersions(migrations[i-1].Version, migrations[i].Version) == 0 {
+			log.Panic("Duplicate migration version", "module", m.Name, "stage", stage, "version", migrations[i].Version)
+		}
+	}
+	return migrations
+}
+
+// RunMigrations runs the migrations of the given stage of all modules
+// that have not been applied yet.
+//
+// Modules are processed in the order they are registered and all
+// migrations are run inside a single transaction.
+//
+// A module is installed in the database when the post-sync migrations are
+// run for the first time with this module. The migrations of both stages of
+// a module that is not installed yet are recorded as applied without being
+// run, since there is no data of this module to migrate.
+//
+// Databases whose schema was created before modules installations were
+// recorded have all the current modules marked as installed when the
+// pre-sync migrations are run.
+func RunMigrations(stage MigrationStage) {
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		applied := loadAppliedMigrations(env)
+		installed := installedModules(applied)
+		if stage == PreSyncMigration && len(installed) == 0 && models.SchemaCreated(env) {
+			for _, mod := range Modules {
+				recordModuleInstalled(env, mod.Name)
+				installed[mod.Name] = true
+			}
+		}
+		for _, mod := range Modules {
+			if !installed[mod.Name] {
+				recordMigrationsOfNewModule(env, mod, applied)
+				if stage == PostSyncMigration {
+					recordModuleInstalled(env, mod.Name)
+				}
+				continue
+			}
+			for _, migration := range mod.migrations(stage) {
+				if _, ok := applied[migrationKey(mod.Name, stage, migration.Version)]; ok {
+					continue
+				}
+				log.Info("Running migration", "module", mod.Name, "stage", stage, "version", migration.Version, "description", migration.Description)
+				if migration.Func != nil {
+					migration.Func(env)
+				}
+				recordMigration(env, mod.Name, stage, migration)
+			}
+		}
+	})
+	if err != nil {
+		log.Panic("Error while running migrations", "stage", stage, "error", err)
+	}
+}
+
+// installedModules returns the names of the modules
+// recorded as installed in the given applied migrations.
+func installedModules(applied map[string]appliedMigration) map[string]bool {
+	res := make(map[string]bool)
+	for _, am := range applied {
+		if MigrationStage(am.Stage) == installStage {
+			res[am.Module] = true
+		}
+	}
+	return res
+}
+
+// recordMigrationsOfNewModule records the migrations of both stages of the
+// given module, which is not installed yet, as applied without running them.
+func recordMigrationsOfNewModule(env models.Environment, mod *Module, applied map[string]appliedMigration) {
+	for _, st := range []MigrationStage{PreSyncMigration, PostSyncMigration} {
+		for _, migration := range mod.migrations(st) {
+			key := migrationKey(mod.Name, st, migration.Version)
+			if _, ok := applied[key]; ok {
+				continue
+			}
+			log.Info("Recording migration of new module as applied", "module", mod.Name, "stage", st, "version", migration.Version)
+			recordMigration(env, mod.Name, st, migration)
+			applied[key] = appliedMigration{Module: mod.Name, Stage: string(st), Version: migration.Version}
+		}
+	}
+}
+
+// recordModuleInstalled records the given module as installed
+func recordModuleInstalled(env models.Environment, module string) {
+	log.Info("Recording module as installed", "module", module)
+	recordMigration(env, module, installStage, Migration{Description: "Module installed"})
+}
+
+// MigrationsStatus returns the status of all migrations of all modules.
+func MigrationsStatus() []MigrationStatus {
+	var res []MigrationStatus
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		applied := loadAppliedMigrations(env)
+		for _, mod := range Modules {
+			for _, stage := range []MigrationStage{PreSyncMigration, PostSyncMigration} {
+				for _, migration := range mod.migrations(stage) {
+					status := MigrationStatus{
+						Module:      mod.Name,
+						Stage:       stage,
+						Version:     migration.Version,
+						Description: migration.Description,
+					}
+					if am, ok := applied[migrationKey(mod.Name, stage, migration.Version)]; ok {
+						status.Applied = true
+						status.AppliedAt = am.AppliedAt
+					}
+					res = append(res, status)
+				}
+			}
+		}
+	})
+	if err != nil {
+		log.Panic("Error while reading migrations status", "error", err)
+	}
+	return res
+}
+
+// loadAppliedMigrations creates the migration table if needed and
+// returns the migrations already applied, indexed by migrationKey.
+func loadAppliedMigrations(env models.Environment) map[string]appliedMigration {
+	env.Cr().Execute(`
+		CREATE TABLE IF NOT EXISTS ` + migrationTable + ` (
+			id serial NOT NULL PRIMARY KEY,
+			module character varying NOT NULL,
+			stage character varying NOT NULL,
+			version character varying NOT NULL,
+			description text,
+			applied_at timestamp without time zone NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+			UNIQUE (module, stage, version)
+		)`)
+	var migrations []appliedMigration
+	env.Cr().Select(&migrations, `SELECT module, stage, version, applied_at FROM `+migrationTable)
+	res := make(map[string]appliedMigration, len(migrations))
+	for _, am := range migrations {
+		res[migrationKey(am.Module, MigrationStage(am.Stage), am.Version)] = am
+	}
+	return res
+}
+
+// recordMigration records the given migration of the given module as applied
+func recordMigration(env models.Environment, module string, stage MigrationStage, migration Migration) {
+	env.Cr().Execute(`INSERT INTO `+migrationTable+` (module, stage, version, description) VALUES (?, ?, ?, ?)`,
+		module, string(stage), migration.Version, migration.Description)
+}
+
+// migrationKey returns a unique key for the given migration
+func migrationKey(module string, stage MigrationStage, version string) string {
+	return strings.Join([]string{module, string(stage), version}, "/")
+}
+
+// compareVersions compares the dot separated versions a and b.
+// It returns -1 if a < b, 0 if a == b and 1 if a > b.
+//
+// Numeric parts are compared as numbers and other parts as strings.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aPart != bPart:
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func init() {
+	models.RegisterSystemTable(migrationTable)
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompareVersions(t *testing.T) {
+	Convey("Testing versions comparison", t, func() {
+		So(compareVersions("1.2.0", "1.2.0"), ShouldEqual, 0)
+		So(compareVersions("1.2", "1.2.0"), ShouldEqual, 0)
+		So(compareVersions("1.2.0", "1.10.0"), ShouldEqual, -1)
+		So(compareVersions("1.10.0", "1.2.0"), ShouldEqual, 1)
+		So(compareVersions("2", "1.9.9"), ShouldEqual, 1)
+		So(compareVersions("1.2.1", "1.2"), ShouldEqual, 1)
+		So(compareVersions("1.0.alpha", "1.0.beta"), ShouldEqual, -1)
+		So(compareVersions("1.0.beta", "1.0.alpha"), ShouldEqual, 1)
+		So(compareVersions("1.0.alpha", "1.0.alpha"), ShouldEqual, 0)
+	})
+}
+
+func TestModuleMigrations(t *testing.T) {
+	Convey("Testing module migrations ordering", t, func() {
+		mod := &Module{
+			Name: "migrations_test",
+			PreMigrations: []Migration{
+				{Version: "1.10.0"},
+				{Version: "1.2.0"},
+				{Version: "1.2.1"},
+			},
+			PostMigrations: []Migration{
+				{Version: "2.0"},
+			},
+		}
+		Convey("Migrations should be sorted by version", func() {
+			migrations := mod.migrations(PreSyncMigration)
+			So(migrations, ShouldHaveLength, 3)
+			So(migrations[0].Version, ShouldEqual, "1.2.0")
+			So(migrations[1].Version, ShouldEqual, "1.2.1")
+			So(migrations[2].Version, ShouldEqual, "1.10.0")
+			So(mod.migrations(PostSyncMigration), ShouldHaveLength, 1)
+		})
+		Convey("Duplicate versions should panic", func() {
+			mod.PostMigrations = append(mod.PostMigrations, Migration{Version: "2.0.0"})
+			So(func() { mod.migrations(PostSyncMigration) }, ShouldPanic)
+		})
+	})
+}
@@ -40,6 +40,10 @@ type Module struct {
 	Name     string
 	PreInit  func() // Function to be run before bootstrap but after all calls to init
 	PostInit func() // Function to be run after initialisation is complete and before server starts
+	// PreMigrations are data migrations to run before the database schema synchronization
+	PreMigrations []Migration
+	// PostMigrations are data migrations to run after the database schema synchronization
+	PostMigrations []Migration
 }
 
 // A ModulesList is a list of Module objects
@@ -0,0 +1,71 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gleke/hexya/src/models"
+)
+
+// profilingSummarySize is the number of methods detailed
+// in the log line of a slow request
+const profilingSummarySize = 5
+
+// EnableProfiling enables the profiling of model method calls and collects
+// the method statistics of each request. It must be called before routes
+// are registered.
+//
+// Requests whose method calls last longer than slowThreshold in total are
+// logged with a summary of the called methods. Slow requests are not logged
+// if slowThreshold is 0.
+//
+// The statistics of a request are those of the Environments created with
+// the context of the request, i.e. with models.ExecuteInNewEnvironmentContext
+// or models.SimulateInNewEnvironmentContext and c.Request.Context(), or with
+// the ExecuteInNewEnvironment and SimulateInNewEnvironment methods of Context.
+//
+// In debug mode, the statistics of all method calls are served as JSON at
+// /debug/methods. They are reset if the reset query parameter is set.
+func EnableProfiling(slowThreshold time.Duration) {
+	models.EnableProfiling(slowThreshold)
+	hexyaServer.Use(func(c *gin.Context) {
+		profileRequest(c, slowThreshold)
+	})
+	if gin.IsDebugging() {
+		hexyaServer.GET("/debug/methods", func(c *gin.Context) {
+			c.JSON(http.StatusOK, models.MethodStatistics())
+			if _, reset := c.GetQuery("reset"); reset {
+				models.ResetMethodStatistics()
+			}
+		})
+	}
+}
+
+// profileRequest is the middleware that collects the method statistics
+// of the request and logs slow requests.
+func profileRequest(c *gin.Context, slowThreshold time.Duration) {
+	profile := models.NewProfile()
+	c.Request = c.Request.WithContext(models.ContextWithProfile(c.Request.Context(), profile))
+	c.Next()
+	if slowThreshold == 0 {
+		return
+	}
+	var (
+		duration time.Duration
+		queries  int64
+	)
+	for _, ms := range profile.Stats() {
+		duration += ms.ExclusiveTime
+		queries += ms.ExclusiveQueries
+	}
+	if duration < slowThreshold {
+		return
+	}
+	log.Warn("Slow request", "method", c.Request.Method, "path", c.Request.URL.Path,
+		"tenant", models.TenantFromContext(c.Request.Context()), "duration", duration,
+		"queries", queries, "summary", profile.Summary(profilingSummarySize))
+}
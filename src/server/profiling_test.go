@@ -0,0 +1,40 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gleke/hexya/src/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProfileRequest(t *testing.T) {
+	Convey("Testing request profiling", t, func() {
+		var (
+			profile *models.Profile
+			tenant  string
+		)
+		engine := gin.New()
+		engine.Use(func(c *gin.Context) {
+			profileRequest(c, 0)
+		})
+		engine.Use(func(c *gin.Context) {
+			c.Request = c.Request.WithContext(models.ContextWithTenant(c.Request.Context(), "acme"))
+			c.Next()
+		})
+		engine.GET("/profiled", func(c *gin.Context) {
+			profile = models.ProfileFromContext(c.Request.Context())
+			tenant = models.TenantFromContext(c.Request.Context())
+		})
+		Convey("Profile and tenant should both be in the request context", func() {
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/profiled", nil))
+			So(profile, ShouldNotBeNil)
+			So(tenant, ShouldEqual, "acme")
+		})
+	})
+}
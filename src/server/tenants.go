@@ -0,0 +1,66 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gleke/hexya/src/models"
+)
+
+const (
+	// TenantHeader is the HTTP header that selects the tenant
+	// of a request in multi-tenant mode.
+	TenantHeader = "X-Hexya-Tenant"
+	// tenantSessionKey is the session key of the tenant of a request
+	tenantSessionKey = "hexya_tenant"
+)
+
+// EnableMultiTenant loads the tenants and makes the server select
+// the tenant of each request. It must be called before routes are
+// registered.
+//
+// The tenant of a request is given by the TenantHeader header, then by
+// the host name of the request (see models.ResolveTenant) and finally by
+// the session. Requests without a known tenant are answered with a 404
+// Not Found status.
+//
+// The session is cleared when the tenant of a request differs from the
+// tenant of the session, so that a session is never used on the database
+// of another tenant.
+//
+// The tenant is set in the context.Context of the request, so that the
+// Environments created with models.ExecuteInNewEnvironmentContext and
+// models.SimulateInNewEnvironmentContext and this context run on the
+// database of the tenant.
+func EnableMultiTenant() {
+	models.LoadTenants()
+	hexyaServer.Use(selectTenant)
+}
+
+// selectTenant is the middleware that sets the tenant of the request in its context
+func selectTenant(c *gin.Context) {
+	session := (&Context{Context: c}).Session()
+	sessionTenant, _ := session.Get(tenantSessionKey).(string)
+	tenant := c.GetHeader(TenantHeader)
+	if tenant == "" {
+		tenant, _ = models.ResolveTenant(c.Request.Host)
+	}
+	if tenant == "" {
+		tenant = sessionTenant
+	}
+	if tenant == "" || !models.TenantExists(tenant) {
+		log.Debug("No tenant found for request", "host", c.Request.Host, "tenant", tenant)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if tenant != sessionTenant {
+		session.Clear()
+		session.Set(tenantSessionKey, tenant)
+		session.Save()
+	}
+	c.Request = c.Request.WithContext(models.ContextWithTenant(c.Request.Context(), tenant))
+	c.Next()
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/gleke/hexya/src/models"
+	"github.com/gleke/hexya/src/models/security"
+	"github.com/gleke/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrations(t *testing.T) {
+	var calls []string
+	migration := func(name string) func(env models.Environment) {
+		return func(env models.Environment) {
+			calls = append(calls, name)
+		}
+	}
+	modules := server.Modules
+	server.Modules = server.ModulesList{
+		{
+			Name: "migrations_a",
+			PreMigrations: []server.Migration{
+				{Version: "1.10", Description: "A pre 1.10", Func: migration("a-pre-1.10")},
+				{Version: "1.2", Description: "A pre 1.2", Func: migration("a-pre-1.2")},
+			},
+			PostMigrations: []server.Migration{
+				{Version: "1.0", Description: "A post 1.0", Func: migration("a-post-1.0")},
+			},
+		},
+		{
+			Name: "migrations_b",
+			PreMigrations: []server.Migration{
+				{Version: "0.1", Description: "B pre 0.1", Func: migration("b-pre-0.1")},
+			},
+		},
+	}
+	defer func() {
+		server.Modules = modules
+	}()
+	Convey("Testing data migrations", t, func() {
+		Convey("Migrations should be pending before being run", func() {
+			status := server.MigrationsStatus()
+			So(status, ShouldHaveLength, 4)
+			for _, st := range status {
+				So(st.Applied, ShouldBeFalse)
+			}
+		})
+		Convey("Migrations should be run by module and version order", func() {
+			server.RunMigrations(server.PreSyncMigration)
+			So(calls, ShouldResemble, []string{"a-pre-1.2", "a-pre-1.10", "b-pre-0.1"})
+			server.RunMigrations(server.PostSyncMigration)
+			So(calls, ShouldResemble, []string{"a-pre-1.2", "a-pre-1.10", "b-pre-0.1", "a-post-1.0"})
+		})
+		Convey("Migrations should be applied only once", func() {
+			server.RunMigrations(server.PreSyncMigration)
+			server.RunMigrations(server.PostSyncMigration)
+			So(calls, ShouldHaveLength, 4)
+		})
+		Convey("Status should give applied migrations in order", func() {
+			status := server.MigrationsStatus()
+			So(status, ShouldHaveLength, 4)
+			So(status[0].Module, ShouldEqual, "migrations_a")
+			So(status[0].Stage, ShouldEqual, server.PreSyncMigration)
+			So(status[0].Version, ShouldEqual, "1.2")
+			So(status[1].Version, ShouldEqual, "1.10")
+			So(status[2].Stage, ShouldEqual, server.PostSyncMigration)
+			So(status[2].Version, ShouldEqual, "1.0")
+			So(status[3].Module, ShouldEqual, "migrations_b")
+			for _, st := range status {
+				So(st.Applied, ShouldBeTrue)
+				So(st.AppliedAt.IsZero(), ShouldBeFalse)
+			}
+		})
+		Convey("New migrations should be run on next update", func() {
+			server.Modules[1].PreMigrations = append(server.Modules[1].PreMigrations,
+				server.Migration{Version: "0.2", Description: "B pre 0.2", Func: migration("b-pre-0.2")})
+			server.RunMigrations(server.PreSyncMigration)
+			So(calls, ShouldHaveLength, 5)
+			So(calls[4], ShouldEqual, "b-pre-0.2")
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				So(models.SchemaCreated(env), ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		Convey("Migrations of a new module should be recorded without being run", func() {
+			server.Modules = append(server.Modules, &server.Module{
+				Name: "migrations_c",
+				PreMigrations: []server.Migration{
+					{Version: "1.0", Description: "C pre 1.0", Func: migration("c-pre-1.0")},
+				},
+				PostMigrations: []server.Migration{
+					{Version: "1.0", Description: "C post 1.0", Func: migration("c-post-1.0")},
+				},
+			})
+			server.RunMigrations(server.PreSyncMigration)
+			server.RunMigrations(server.PostSyncMigration)
+			So(calls, ShouldHaveLength, 5)
+			status := server.MigrationsStatus()
+			So(status, ShouldHaveLength, 7)
+			So(status[5].Module, ShouldEqual, "migrations_c")
+			So(status[5].Applied, ShouldBeTrue)
+			So(status[6].Applied, ShouldBeTrue)
+		})
+		Convey("New migrations of an installed module should be run", func() {
+			server.Modules[2].PostMigrations = append(server.Modules[2].PostMigrations,
+				server.Migration{Version: "1.1", Description: "C post 1.1", Func: migration("c-post-1.1")})
+			server.RunMigrations(server.PreSyncMigration)
+			server.RunMigrations(server.PostSyncMigration)
+			So(calls, ShouldHaveLength, 6)
+			So(calls[5], ShouldEqual, "c-post-1.1")
+		})
+	})
+}
@@ -113,6 +113,7 @@ func InitializeTests(moduleName string) {
 		Password: password,
 		SSLMode:  "disable",
 	})
+	models.SetEncryptionKeys("hexya tests secret")
 	models.BootStrap()
 	resourceDir, _ := filepath.Abs(filepath.Join(".", "res"))
 	server.ResourceDir = resourceDir
@@ -0,0 +1,178 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package encryption provides functions to encrypt and decrypt string values
+// with AES-256-GCM, with support for key rotation.
+// Encrypted values are in '$aes-gcm$<base64 nonce and ciphertext>' format.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	prefix = "$aes-gcm$"
+	// cipherKeyInfo and nonceKeyInfo are the HKDF info labels
+	// of the subkeys derived from each secret.
+	cipherKeyInfo = "hexya-encryption-aes-gcm"
+	nonceKeyInfo  = "hexya-encryption-nonce-hmac"
+)
+
+// ErrNoKey is returned when trying to encrypt or decrypt with an empty Keyring
+var ErrNoKey = errors.New("no encryption key defined")
+
+// ErrDecrypt is returned when a value cannot be decrypted with any key of the Keyring
+var ErrDecrypt = errors.New("unable to decrypt value with any known key")
+
+// A Keyring holds the keys used to encrypt and decrypt values.
+//
+// The first key is used for encryption. All keys are tried in turn
+// for decryption, so that a new key can be set in first position
+// while values encrypted with older keys remain readable.
+type Keyring struct {
+	keys []key
+}
+
+// A key holds the subkeys derived from a secret: cipherKey encrypts the
+// values with AES-GCM and nonceKey computes the nonces of deterministic
+// encryption with HMAC-SHA256.
+type key struct {
+	cipherKey []byte
+	nonceKey  []byte
+}
+
+// NewKeyring returns a pointer to a new Keyring with the given secrets.
+// Secrets can be of any length, since the actual keys are derived from them.
+func NewKeyring(secrets ...string) *Keyring {
+	res := new(Keyring)
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		res.keys = append(res.keys, key{
+			cipherKey: deriveKey(secret, cipherKeyInfo),
+			nonceKey:  deriveKey(secret, nonceKeyInfo),
+		})
+	}
+	return res
+}
+
+// deriveKey returns the 256 bits subkey of the given
+// secret for the given info label with HKDF-SHA256.
+func deriveKey(secret, info string) []byte {
+	res := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(info)), res); err != nil {
+		// Cannot happen for a 32 bytes key
+		panic(err)
+	}
+	return res
+}
+
+// IsEmpty returns true if this Keyring has no key
+func (k *Keyring) IsEmpty() bool {
+	return k == nil || len(k.keys) == 0
+}
+
+// Encrypt returns the given value encrypted with the first key of this Keyring.
+//
+// If deterministic is true, the same value will always give the same encrypted
+// result with a given key, which allows searching by equality at the cost of
+// revealing which records share the same value.
+func (k *Keyring) Encrypt(value string, deterministic bool) (string, error) {
+	if k.IsEmpty() {
+		return "", ErrNoKey
+	}
+	return encrypt(k.keys[0], value, deterministic)
+}
+
+// EncryptAll returns the given value deterministically encrypted with each
+// key of this Keyring. It is meant for searching values whatever the key
+// they have been encrypted with.
+func (k *Keyring) EncryptAll(value string) ([]string, error) {
+	if k.IsEmpty() {
+		return nil, ErrNoKey
+	}
+	res := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		enc, err := encrypt(key, value, true)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = enc
+	}
+	return res, nil
+}
+
+// Decrypt returns the clear text of the given encrypted value.
+//
+// Values that are not in the encrypted format are returned unchanged,
+// so that columns can be encrypted progressively.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k.IsEmpty() {
+		return "", ErrNoKey
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", err
+	}
+	for _, key := range k.keys {
+		gcm, err := newGCM(key.cipherKey)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < gcm.NonceSize() {
+			return "", ErrDecrypt
+		}
+		nonce, cipherText := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+		plain, err := gcm.Open(nil, nonce, cipherText, nil)
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", ErrDecrypt
+}
+
+// IsEncrypted returns true if the given value is in the encrypted format
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// encrypt the given value with the given key
+func encrypt(key key, value string, deterministic bool) (string, error) {
+	gcm, err := newGCM(key.cipherKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if deterministic {
+		mac := hmac.New(sha256.New, key.nonceKey)
+		mac.Write([]byte(value))
+		copy(nonce, mac.Sum(nil))
+	} else if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return prefix + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// newGCM returns an AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
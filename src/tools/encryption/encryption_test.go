@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package encryption
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryption(t *testing.T) {
+	Convey("Testing values encryption", t, func() {
+		keyring := NewKeyring("secret")
+		Convey("Encrypting and decrypting a value", func() {
+			enc, err := keyring.Encrypt("FR76 3000 6000 0112 3456 7890 189", false)
+			So(err, ShouldBeNil)
+			So(IsEncrypted(enc), ShouldBeTrue)
+			So(enc, ShouldNotContainSubstring, "3000")
+			dec, err := keyring.Decrypt(enc)
+			So(err, ShouldBeNil)
+			So(dec, ShouldEqual, "FR76 3000 6000 0112 3456 7890 189")
+		})
+		Convey("Random mode should give different results", func() {
+			enc1, _ := keyring.Encrypt("token", false)
+			enc2, _ := keyring.Encrypt("token", false)
+			So(enc1, ShouldNotEqual, enc2)
+		})
+		Convey("Deterministic mode should give identical results", func() {
+			enc1, _ := keyring.Encrypt("token", true)
+			enc2, _ := keyring.Encrypt("token", true)
+			So(enc1, ShouldEqual, enc2)
+			enc3, _ := keyring.Encrypt("other token", true)
+			So(enc1, ShouldNotEqual, enc3)
+		})
+		Convey("Clear text values should be returned unchanged", func() {
+			dec, err := keyring.Decrypt("clear text")
+			So(err, ShouldBeNil)
+			So(dec, ShouldEqual, "clear text")
+		})
+		Convey("Key rotation", func() {
+			enc, _ := keyring.Encrypt("token", true)
+			rotated := NewKeyring("new secret", "secret")
+			dec, err := rotated.Decrypt(enc)
+			So(err, ShouldBeNil)
+			So(dec, ShouldEqual, "token")
+			encAll, err := rotated.EncryptAll("token")
+			So(err, ShouldBeNil)
+			So(encAll, ShouldHaveLength, 2)
+			So(encAll[1], ShouldEqual, enc)
+			_, err = NewKeyring("new secret").Decrypt(enc)
+			So(err, ShouldEqual, ErrDecrypt)
+		})
+		Convey("Cipher and nonce keys should be distinct", func() {
+			So(keyring.keys, ShouldHaveLength, 1)
+			So(keyring.keys[0].cipherKey, ShouldHaveLength, 32)
+			So(keyring.keys[0].nonceKey, ShouldHaveLength, 32)
+			So(keyring.keys[0].cipherKey, ShouldNotResemble, keyring.keys[0].nonceKey)
+			So(NewKeyring("secret").keys[0], ShouldResemble, keyring.keys[0])
+		})
+		Convey("Empty keyring should fail", func() {
+			_, err := NewKeyring().Encrypt("token", false)
+			So(err, ShouldEqual, ErrNoKey)
+			enc, _ := keyring.Encrypt("token", false)
+			_, err = NewKeyring("").Decrypt(enc)
+			So(err, ShouldEqual, ErrNoKey)
+		})
+	})
+}
@@ -30,6 +30,7 @@ type fieldData struct {
 	SanType     string
 	ImportPath  string
 	IsRS        bool
+	IsArray     bool
 	MixinField  bool
 	EmbedField  bool
 }
@@ -273,6 +274,7 @@ func addFieldsToModelData(modelASTData ModelASTData, modelData *modelData, depsM
 			Type:       typStr,
 			IType:      iTypStr,
 			IsRS:       fieldASTData.IsRS,
+			IsArray:    fieldASTData.FType.IsArrayType(),
 			RelModel:   fieldASTData.RelModel,
 			SanType:    createTypeIdent(typStr),
 			MixinField: fieldASTData.MixinField,
@@ -297,16 +299,24 @@ func addFieldTypesToModelData(mData *modelData) {
 		}
 		fTypes[f.IType] = true
 		tDeps[f.ImportPath] = true
+		operators := []operatorDef{
+			{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
+			{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
+			{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
+			{Name: "ChildOf"},
+		}
+		if f.IsArray {
+			// Array operators take a slice of elements which is already the field's type
+			operators = []operatorDef{
+				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Contains"}, {Name: "NotContains"},
+				{Name: "Overlaps"}, {Name: "In"}, {Name: "NotIn"},
+			}
+		}
 		mData.Types = append(mData.Types, fieldType{
-			Type:    f.IType,
-			SanType: f.SanType,
-			IsRS:    f.IsRS,
-			Operators: []operatorDef{
-				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
-				{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
-				{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
-				{Name: "ChildOf"},
-			},
+			Type:      f.IType,
+			SanType:   f.SanType,
+			IsRS:      f.IsRS,
+			Operators: operators,
 		})
 	}
 	for dep := range tDeps {
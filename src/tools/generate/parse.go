@@ -422,7 +422,7 @@ func parseAddFields(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[str
 			typeStr = strings.TrimSuffix(ft.Sel.Name, "Field")
 		}
 		var importPath string
-		if typeStr == "Date" || typeStr == "DateTime" {
+		if typeStr == "Date" || typeStr == "DateTime" || typeStr == "Duration" || typeStr == "Time" {
 			importPath = DatesPath
 		}
 
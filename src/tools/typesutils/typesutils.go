@@ -231,6 +231,17 @@ func getSimpleTypeValue(value interface{}, target interface{}) error {
 			return err
 		}
 		res = fval
+	case typ.Kind() == reflect.Slice && targetType.Kind() == reflect.Slice && typ != reflect.TypeOf([]byte{}):
+		// convert slices element by element (e.g. []interface{} from JSON)
+		resVal := reflect.MakeSlice(targetType, val.Len(), val.Len())
+		for i := 0; i < val.Len(); i++ {
+			elem := reflect.New(targetType.Elem())
+			if err := Convert(val.Index(i).Interface(), elem.Interface(), false); err != nil {
+				return err
+			}
+			resVal.Index(i).Set(elem.Elem())
+		}
+		res = resVal.Interface()
 	default:
 		return fmt.Errorf("impossible conversion of %v (%T) to %s", value, value, targetType)
 	}
@@ -238,6 +238,8 @@ var convertTestCases = []convertTestCase{
 	{value: []int64{1}, target: new(int64), result: int64(1), isRS: true},
 	{value: (*interface{})(nil), target: new(int64), result: int64(0), isRS: true},
 	{value: (*interface{})(nil), target: new([]int64), result: []int64{}, isRS: true},
+	{value: []interface{}{float64(1), float64(2)}, target: new([]int64), result: []int64{1, 2}},
+	{value: []interface{}{"a", "b"}, target: new([]string), result: []string{"a", "b"}},
 }
 
 var convertErrorCases = []convertTestCase{
@@ -248,6 +250,7 @@ var convertErrorCases = []convertTestCase{
 	{value: "ST", target: new(float32), isRS: true, err: "expected number value, got ST: value ST cannot be casted to int64"},
 	{value: 1, target: new(float64), isRS: true, err: "non consistent type"},
 	{value: false, target: new(int), err: "impossible conversion of false (bool) to int"},
+	{value: []interface{}{false}, target: new([]int64), err: "impossible conversion of false (bool) to int64"},
 }
 
 func TestConvert(t *testing.T) {